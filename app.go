@@ -5,18 +5,26 @@ import (
 	"fmt"
 	"github.com/fatih/color"
 	"github.com/nosyliam/revolution/macro"
+	"github.com/nosyliam/revolution/pkg/api"
 	"github.com/nosyliam/revolution/pkg/common"
 	. "github.com/nosyliam/revolution/pkg/config"
 	"github.com/nosyliam/revolution/pkg/control"
 	"github.com/nosyliam/revolution/pkg/logging"
 	"github.com/nosyliam/revolution/pkg/movement"
 	"github.com/nosyliam/revolution/pkg/movement/alignment"
+	"github.com/nosyliam/revolution/pkg/networking"
+	"github.com/nosyliam/revolution/pkg/orchestrator"
 	"github.com/nosyliam/revolution/pkg/vichop"
 	"github.com/nosyliam/revolution/pkg/window"
 	"github.com/pkg/errors"
 	"github.com/sqweek/dialog"
 	"github.com/wailsapp/wails/v2/pkg/runtime"
+	"image"
+	"maps"
 	"os"
+	"strings"
+	"sync"
+	"time"
 )
 
 type Macro struct {
@@ -33,7 +41,12 @@ type Macro struct {
 	vicHop    *vichop.Manager
 	logger    *logging.Logger
 
-	interfaces map[string]*macro.Interface
+	orchestrator *orchestrator.Orchestrator
+	api          *api.Server
+
+	// The interfaces are also used by the control API's goroutines
+	interfacesMu sync.RWMutex
+	interfaces   map[string]*macro.Interface
 
 	err chan string
 }
@@ -66,6 +79,9 @@ func (m *Macro) startup(ctx context.Context) {
 		dialog.Message(errors.Wrap(err, "Failed to load configuration").Error()).Error()
 		os.Exit(1)
 	}
+	if err := logging.Initialize("logs", m.config.Object().Logging); err != nil {
+		dialog.Message(errors.Wrap(err, "Failed to initialize logging").Error()).Error()
+	}
 	m.state, err = NewState(m.runtime)
 	if err != nil {
 		dialog.Message(errors.Wrap(err, "Failed to load state").Error()).Error()
@@ -124,31 +140,23 @@ func (m *Macro) startup(ctx context.Context) {
 		}
 	}
 
+	db := m.database.Object()
+	db.SetState(m.state)
+	db.OnDelete(func(account *Account) {
+		if err := m.windowMgr.Release(account.Name); err != nil {
+			m.logger.Log(0, logging.Error, fmt.Sprintf("Failed to release window for account %s: %v", account.Name, err))
+		}
+	})
+
+	m.orchestrator = orchestrator.NewOrchestrator(m.logger, m.config.Object().Orchestration)
+	m.orchestrator.EnableRotation(m.config, m.state, m.database, m.windowMgr.Release)
+	m.orchestrator.Start()
+
 	fmt.Println("accounts", accounts)
 	for name, preset := range accounts {
-		macroPath := fmt.Sprintf("macros[%s]", name)
-		fmt.Println("macroPath", macroPath)
-		var macroState *Object[MacroState]
-		if state := Concrete[*Object[MacroState]](m.state, macroPath); state == nil {
-			if err = m.state.AppendPath(macroPath); err != nil {
-				m.exitError(errors.Wrap(err, fmt.Sprintf("Failed to load state for macro %s", name)))
-			}
-			macroState = *Concrete[*Object[MacroState]](m.state, macroPath)
-		} else {
-			macroState = *state
+		if err := m.registerAccount(name, preset); err != nil {
+			m.exitError(err)
 		}
-		macroState.SetPath("status", "Ready")
-		m.interfaces[name] = macro.NewInterface(
-			name,
-			preset,
-			macroState,
-			m.database,
-			m.pattern,
-			m.windowMgr,
-			m.vicHop,
-			m.eventBus,
-			m.backend,
-		)
 	}
 
 	runtime.EventsOn(ctx, "command", func(data ...interface{}) {
@@ -159,22 +167,152 @@ func (m *Macro) startup(ctx context.Context) {
 			}
 			args = append(args, arg.(string))
 		}
-		if ok := m.ReceiveCommand(args...); ok {
-			return
+		m.dispatchCommand(args)
+	})
+
+	m.api = api.NewServer(m.config.Object().API, m.state, &apiController{m})
+	if m.config.Object().API.Object().Enabled {
+		m.startAPI()
+	}
+	if _, err := m.config.Subscribe("api.enabled", func(change Change) {
+		if change.Value.(bool) {
+			m.startAPI()
+		} else {
+			m.api.Close()
 		}
-		active := m.state.Object().Config.Object().ActiveAccount
-		if ifc, ok := m.interfaces[active]; ok && ifc.Macro != nil {
+	}); err != nil {
+		m.logger.Log(0, logging.Error, fmt.Sprintf("Failed to watch API settings: %v", err))
+	}
+}
+
+func (m *Macro) startAPI() {
+	if err := m.api.Start(); err != nil {
+		m.logger.Log(0, logging.Error, fmt.Sprintf("Failed to start the control API: %v", err))
+		return
+	}
+	m.logger.Log(0, logging.Info, fmt.Sprintf("Control API listening on %s", m.api.Addr()))
+}
+
+// dispatchCommand runs an app command, or forwards it to the active account's macro
+func (m *Macro) dispatchCommand(args []string) {
+	if ok := m.ReceiveCommand(args...); ok {
+		return
+	}
+	active := m.state.Object().Config.Object().ActiveAccount
+	if ifc, ok := m.lookupInterface(active); ok && ifc.Macro != nil {
+		fmt.Println("sending command")
+		ifc.Command() <- args
+	} else {
+		if ifc, _ = m.lookupInterface("Default"); ifc.Macro != nil {
 			fmt.Println("sending command")
 			ifc.Command() <- args
 		} else {
-			if ifc = m.interfaces["Default"]; ifc.Macro != nil {
-				fmt.Println("sending command")
-				ifc.Command() <- args
-			} else {
-				runtime.EventsEmit(ctx, "console", color.RedString("Macro must be started to use this command!\r\n"))
-			}
+			runtime.EventsEmit(AppContext, "console", color.RedString("Macro must be started to use this command!\r\n"))
 		}
+	}
+}
+
+// apiController exposes the macro to the control API without binding extra methods to the frontend
+type apiController struct {
+	m *Macro
+}
+
+func (c *apiController) Start(account string) {
+	if _, ok := c.m.lookupInterface(account); ok {
+		c.m.Start(account)
+	}
+}
+
+func (c *apiController) Pause(account string) {
+	if _, ok := c.m.lookupInterface(account); ok {
+		c.m.Pause(account)
+	}
+}
+
+func (c *apiController) Stop(account string) {
+	if _, ok := c.m.lookupInterface(account); ok {
+		c.m.Stop(account)
+	}
+}
+
+func (c *apiController) Command(args ...string) {
+	c.m.dispatchCommand(args)
+}
+
+func (c *apiController) Screenshot(account string) *image.RGBA {
+	ifc, ok := c.m.lookupInterface(account)
+	if !ok || ifc.Macro == nil {
+		return nil
+	}
+	if win := ifc.Macro.GetWindow(); win != nil {
+		return win.Screenshot()
+	}
+	return nil
+}
+
+func (m *Macro) lookupInterface(name string) (*macro.Interface, bool) {
+	m.interfacesMu.RLock()
+	defer m.interfacesMu.RUnlock()
+	ifc, ok := m.interfaces[name]
+	return ifc, ok
+}
+
+// allInterfaces returns a copy of the interfaces, which may be ranged over while accounts are added or deleted
+func (m *Macro) allInterfaces() map[string]*macro.Interface {
+	m.interfacesMu.RLock()
+	defer m.interfacesMu.RUnlock()
+	return maps.Clone(m.interfaces)
+}
+
+func (m *Macro) registerAccount(name string, preset *Object[Settings]) error {
+	macroPath := fmt.Sprintf("macros[%s]", name)
+	var macroState *Object[MacroState]
+	if state := Concrete[*Object[MacroState]](m.state, macroPath); state == nil {
+		if err := m.state.AppendPath(macroPath); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("Failed to load state for macro %s", name))
+		}
+		macroState = *Concrete[*Object[MacroState]](m.state, macroPath)
+	} else {
+		macroState = *state
+	}
+	macroState.SetPath("status", "Ready")
+	ifc := macro.NewInterface(
+		name,
+		preset,
+		macroState,
+		m.database,
+		m.pattern,
+		m.windowMgr,
+		m.vicHop,
+		m.eventBus,
+		m.backend,
+	)
+	m.interfacesMu.Lock()
+	m.interfaces[name] = ifc
+	m.interfacesMu.Unlock()
+	ifc.NetworkRelay.Elect(func() AltSync {
+		return m.config.Object().Tools.Object().AltSync.Object()
 	})
+	return m.orchestrator.Register(name, ifc, macroState)
+}
+
+func (m *Macro) shutdown(ctx context.Context) {
+	if m.api != nil {
+		m.api.Close()
+	}
+	if m.orchestrator != nil {
+		m.orchestrator.Close()
+	}
+	if m.vicHop != nil {
+		m.vicHop.Stop()
+	}
+	// Stopping a relay names its successor, which takes over without waiting for an election
+	for _, ifc := range m.allInterfaces() {
+		ifc.NetworkRelay.Stop()
+	}
+	logging.FlushDiscord(5 * time.Second)
+	logging.FlushNotifications(5 * time.Second)
+	logging.Close()
 }
 
 func (m *Macro) ReceiveCommand(args ...string) bool {
@@ -186,6 +324,41 @@ func (m *Macro) ReceiveCommand(args ...string) bool {
 			}
 			logging.Console(AppContext, logging.Info, "\r\n")
 		},
+		"undo": func(args ...string) {
+			m.replayCommand(args, m.runtime.Undo, "Undid")
+		},
+		"redo": func(args ...string) {
+			m.replayCommand(args, m.runtime.Redo, "Redid")
+		},
+		"history": func(args ...string) {
+			file := "settings"
+			if len(args) > 0 {
+				file = args[0]
+			}
+			txs := m.runtime.History(file)
+			if len(txs) == 0 {
+				logging.Console(AppContext, logging.Info, fmt.Sprintf("No changes recorded for %s\r\n", file))
+				return
+			}
+			logging.Console(AppContext, logging.Info, fmt.Sprintf("Recent changes to %s:", file))
+			for _, tx := range txs {
+				logging.Console(AppContext, logging.Info, fmt.Sprintf("  [%s] %s", tx.Time.Format("15:04:05"), tx))
+			}
+			logging.Console(AppContext, logging.Info, "\r\n")
+		},
+		"report": func(args ...string) {
+			m.reportCommand(args)
+		},
+		"remote": func(args ...string) {
+			m.remoteCommand(args)
+		},
+		"rotatekey": func(args ...string) {
+			if err := RotateSecretKey(m.config.File(), m.state.File(), m.database.File()); err != nil {
+				logging.Console(AppContext, logging.Error, fmt.Sprintf("Failed to rotate secret key: %v\r\n", err))
+				return
+			}
+			logging.Console(AppContext, logging.Success, "Secret key rotated\r\n")
+		},
 	}
 	if _, ok := handlers[args[0]]; !ok {
 		return false
@@ -194,9 +367,111 @@ func (m *Macro) ReceiveCommand(args ...string) bool {
 	return true
 }
 
+// reportCommand prints the session report of the active account as Markdown or JSON, or posts it to the webhook
+func (m *Macro) reportCommand(args []string) {
+	active := m.state.Object().Config.Object().ActiveAccount
+	ifc, ok := m.lookupInterface(active)
+	if !ok {
+		logging.Console(AppContext, logging.Error, fmt.Sprintf("Account \"%s\" does not exist!\r\n", active))
+		return
+	}
+	report := ifc.Report()
+	if report == nil {
+		logging.Console(AppContext, logging.Error, "The macro has not been started!\r\n")
+		return
+	}
+	format := "markdown"
+	if len(args) > 0 {
+		format = args[0]
+	}
+	switch format {
+	case "markdown":
+		for _, line := range strings.Split(report.Markdown(), "\n") {
+			logging.Console(AppContext, logging.Info, line)
+		}
+	case "json":
+		data, err := report.JSON()
+		if err != nil {
+			logging.Console(AppContext, logging.Error, fmt.Sprintf("Failed to encode report: %v\r\n", err))
+			return
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			logging.Console(AppContext, logging.Info, line)
+		}
+		logging.Console(AppContext, logging.Info, "\r\n")
+	case "post":
+		settings := ifc.Settings.Object()
+		if err := logging.PostReport(&settings, report); err != nil {
+			logging.Console(AppContext, logging.Error, fmt.Sprintf("Failed to post report: %v\r\n", err))
+			return
+		}
+		logging.Console(AppContext, logging.Success, "Report posted\r\n")
+	default:
+		logging.Console(AppContext, logging.Error, "Expected markdown, json or post!\r\n")
+	}
+}
+
+// remoteCommand queries the status of another account on the network, or runs one of its macro's commands
+func (m *Macro) remoteCommand(args []string) {
+	if len(args) < 2 {
+		logging.Console(AppContext, logging.Error, "Expected an identity followed by status, execroutine or execpattern!\r\n")
+		return
+	}
+	active := m.state.Object().Config.Object().ActiveAccount
+	ifc, ok := m.lookupInterface(active)
+	if !ok {
+		logging.Console(AppContext, logging.Error, fmt.Sprintf("Account \"%s\" does not exist!\r\n", active))
+		return
+	}
+	identity, command := args[0], args[1]
+	if command == "status" {
+		reply, err := networking.Call[networking.StatusReply](ifc.NetworkClient, identity, networking.StatusQueryMessage{}, 0)
+		if err != nil {
+			logging.Console(AppContext, logging.Error, fmt.Sprintf("Failed to query status: %v\r\n", err))
+			return
+		}
+		state := "stopped"
+		if reply.Paused {
+			state = "paused"
+		} else if reply.Running {
+			state = "running"
+		}
+		logging.Console(AppContext, logging.Info, fmt.Sprintf("%s (%s): %s", reply.Account, state, reply.Status))
+		if reply.Routine != "" {
+			logging.Console(AppContext, logging.Info, fmt.Sprintf("  Routine: %s", reply.Routine))
+		}
+		if reply.Field != "" {
+			logging.Console(AppContext, logging.Info, fmt.Sprintf("  Vic Field: %s", reply.Field))
+		}
+		if reply.Server != "" {
+			logging.Console(AppContext, logging.Info, fmt.Sprintf("  Server: %s", reply.Server))
+		}
+		logging.Console(AppContext, logging.Info, "\r\n")
+		return
+	}
+	request := networking.ExecCommandMessage{Command: command, Args: args[2:]}
+	if _, err := ifc.NetworkClient.Request(identity, request, 0); err != nil {
+		logging.Console(AppContext, logging.Error, fmt.Sprintf("Failed to execute %s: %v\r\n", command, err))
+		return
+	}
+	logging.Console(AppContext, logging.Success, fmt.Sprintf("Executed %s on %s\r\n", command, identity))
+}
+
+func (m *Macro) replayCommand(args []string, replay func(string) (*Transaction, error), verb string) {
+	file := "settings"
+	if len(args) > 0 {
+		file = args[0]
+	}
+	if tx, err := replay(file); err != nil {
+		logging.Console(AppContext, logging.Error, fmt.Sprintf("%v\r\n", err))
+	} else {
+		logging.Console(AppContext, logging.Success, fmt.Sprintf("%s %s\r\n", verb, tx))
+	}
+}
+
 func (m *Macro) Start(instance string) {
-	account := m.interfaces[instance]
-	fmt.Println(m.interfaces, account)
+	account, _ := m.lookupInterface(instance)
+	fmt.Println(instance, account)
 	if *Concrete[bool](m.state, "macros[%s].paused", instance) {
 		account.Unpause()
 		return
@@ -205,25 +480,22 @@ func (m *Macro) Start(instance string) {
 }
 
 func (m *Macro) Pause(instance string) {
-	account := m.interfaces[instance]
+	account, _ := m.lookupInterface(instance)
 	account.Pause()
 }
 
 func (m *Macro) Stop(instance string) {
-	account := m.interfaces[instance]
+	account, _ := m.lookupInterface(instance)
+	m.orchestrator.Detach(instance)
 	account.Stop()
 }
 
 func (m *Macro) StartAll() {
-	for name, account := range m.interfaces {
-		if !*Concrete[bool](m.state, "macros[%s].running", name) || *Concrete[bool](m.state, "macros[%s].paused", name) {
-			account.Start()
-		}
-	}
+	m.orchestrator.StartAll()
 }
 
 func (m *Macro) PauseAll() {
-	for name, account := range m.interfaces {
+	for name, account := range m.allInterfaces() {
 		if !*Concrete[bool](m.state, "macros[%s].paused", name) && *Concrete[bool](m.state, "macros[%s].running", name) {
 			account.Pause()
 		}
@@ -231,15 +503,11 @@ func (m *Macro) PauseAll() {
 }
 
 func (m *Macro) StopAll() {
-	for name, account := range m.interfaces {
-		if *Concrete[bool](m.state, "macros[%s].running", name) {
-			account.Stop()
-		}
-	}
+	m.orchestrator.StopAll()
 }
 
 func (m *Macro) StartRelay(instance string) string {
-	account := m.interfaces[instance]
+	account, _ := m.lookupInterface(instance)
 	if err := account.NetworkRelay.Start(); err != nil {
 		return err.Error()
 	}
@@ -247,24 +515,47 @@ func (m *Macro) StartRelay(instance string) string {
 }
 
 func (m *Macro) StopRelay(instance string) {
-	account := m.interfaces[instance]
+	account, _ := m.lookupInterface(instance)
 	account.NetworkRelay.Stop()
 }
 
 func (m *Macro) ConnectRelay(instance string, address string) {
-	account := m.interfaces[instance]
+	account, _ := m.lookupInterface(instance)
 	if err := account.NetworkClient.Connect(address); err != nil {
 		dialog.Message(fmt.Sprintf("Failed to connect to relay: %v", err)).Error()
 	}
 }
 
+func (m *Macro) PairRelay(instance string, address string, code string) {
+	account, _ := m.lookupInterface(instance)
+	if err := account.NetworkClient.Pair(address, code); err != nil {
+		dialog.Message(fmt.Sprintf("Failed to pair with relay: %v", err)).Error()
+	}
+}
+
+func (m *Macro) RefreshPairingCode(instance string) string {
+	account, _ := m.lookupInterface(instance)
+	if err := account.NetworkRelay.RefreshPairingCode(); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+func (m *Macro) BridgeRelay(instance string, address string, code string) string {
+	account, _ := m.lookupInterface(instance)
+	if err := account.NetworkRelay.Bridge(address, code); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
 func (m *Macro) DisconnectRelay(instance string) {
-	account := m.interfaces[instance]
+	account, _ := m.lookupInterface(instance)
 	account.NetworkClient.Disconnect()
 }
 
 func (m *Macro) BanIdentity(instance string, identity string) {
-	account := m.interfaces[instance]
+	account, _ := m.lookupInterface(instance)
 	account.NetworkRelay.Ban(identity)
 }
 
@@ -275,7 +566,7 @@ func (m *Macro) DownloadDataset() {
 }
 
 func (m *Macro) SetAccountPreset(account, name string) string {
-	ifc, ok := m.interfaces[account]
+	ifc, ok := m.lookupInterface(account)
 	if !ok {
 		return fmt.Sprintf("Failed to find account \"%s\"", account)
 	}
@@ -297,36 +588,43 @@ func (m *Macro) SetAccountPreset(account, name string) string {
 	return ""
 }
 
-func (m *Macro) DeleteAccount(name string) string {
-	ifc, ok := m.interfaces[name]
-	if !ok {
-		return fmt.Sprintf("Failed to find account \"%s\"", name)
+func (m *Macro) AddAccount(name string) string {
+	preset := (*Concrete[[]*Object[Settings]](m.config, "presets"))[0]
+	if def := Concrete[*Object[Settings]](m.config, "presets[%s]", *Concrete[string](m.state, "config.defaultPreset")); def != nil {
+		preset = *def
 	}
-	account := Concrete[Account](m.database, "accounts[%s]", name)
-	if account == nil {
-		return fmt.Sprintf("Failed to find account \"%s\"", name)
-	}
-	state := Concrete[MacroState](m.state, "macros[%s]", name)
-	if state == nil {
-		return fmt.Sprintf("Failed to find account \"%s\" in state", name)
-	}
-	if err := m.database.DeletePathf("accounts[%s]", name); err != nil {
-		return fmt.Sprintf("Failed to delete account \"%s\" from database", name)
+	db := m.database.Object()
+	if _, err := db.Add(name, preset.Object().Name); err != nil {
+		return err.Error()
 	}
-	if err := m.database.DeletePathf("state[%s]", name); err != nil {
-		return fmt.Sprintf("Failed to delete account \"%s\" from state", name)
+	if err := m.registerAccount(name, preset); err != nil {
+		_ = db.Delete(name)
+		return err.Error()
 	}
-	account.Delete()
-	if state.Paused {
-		ifc.Unpause()
+	return ""
+}
+
+func (m *Macro) DeleteAccount(name string) string {
+	ifc, ok := m.lookupInterface(name)
+	if !ok {
+		return fmt.Sprintf("Failed to find account \"%s\"", name)
 	}
-	if state.Running {
-		ifc.Stop()
+	m.orchestrator.Unregister(name)
+	if state := Concrete[MacroState](m.state, "macros[%s]", name); state != nil {
+		if state.Paused {
+			ifc.Unpause()
+		}
+		if state.Running {
+			ifc.Stop()
+		}
 	}
-	if ifc.Macro.Window != nil {
-		_ = ifc.Macro.Window.Close()
+	db := m.database.Object()
+	if err := db.Delete(name); err != nil {
+		return err.Error()
 	}
+	m.interfacesMu.Lock()
 	delete(m.interfaces, name)
+	m.interfacesMu.Unlock()
 	return ""
 }
 
@@ -343,22 +641,49 @@ func (m *Macro) DeletePreset(name string) string {
 		}
 	}
 	if preset == nil {
-		return fmt.Sprintf("Failed to find preset \"%s\"", preset)
+		return fmt.Sprintf("Failed to find preset \"%s\"", name)
+	}
+	var fallback *Object[Settings]
+	for _, object := range presets {
+		if object != preset {
+			fallback = object
+			break
+		}
+	}
+	if fallback == nil {
+		return "The last preset cannot be deleted"
 	}
 
-	for _, ifc := range m.interfaces {
+	for _, ifc := range m.allInterfaces() {
 		if ifc.Settings == preset {
 			if ifc.Account != "Default" {
-				if err := m.database.SetPathf(presets[0].Object().Name, "accounts[%s].preset"); err != nil {
+				if err := m.database.SetPathf(fallback.Object().Name, "accounts[%s].preset", ifc.Account); err != nil {
 					return "Failed to set active preset"
 				}
 			} else {
-				if err := m.state.SetPath("config.defaultPreset", presets[0].Object().Name); err != nil {
+				if err := m.state.SetPath("config.defaultPreset", fallback.Object().Name); err != nil {
 					return "Failed to set active preset"
 				}
 			}
-			ifc.Settings = presets[0]
+			ifc.Settings = fallback
 		}
 	}
+	if err := m.config.DeletePathf("presets[%s]", name); err != nil {
+		return fmt.Sprintf("Failed to delete preset \"%s\"", name)
+	}
+	return ""
+}
+
+func (m *Macro) Undo(file string) string {
+	if _, err := m.runtime.Undo(file); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+func (m *Macro) Redo(file string) string {
+	if _, err := m.runtime.Redo(file); err != nil {
+		return err.Error()
+	}
 	return ""
 }
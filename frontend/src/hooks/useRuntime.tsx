@@ -228,6 +228,11 @@ export class Object implements Reactive {
                         this.objects[path.value] = new Object(this.path.extend(path.value), this.runtime)
                 }
             case "delete":
+                if (path.peekFinal && this.values[path.value] != undefined) {
+                    // Map entries are stored as values of the object representing the map
+                    delete this.values[path.value]
+                    return
+                }
                 this.objects[path.value].Receive(path.increment(), event)
         }
     }
@@ -22,16 +22,28 @@ import {modals} from '@mantine/modals';
 import {
     IconForbid,
     IconForbidFilled,
+    IconGitMerge,
+    IconKey,
     IconLogin2,
     IconLogout,
     IconNetwork,
     IconPlus,
+    IconRefresh,
     IconRocket,
     IconSquare,
     IconStar
 } from "@tabler/icons-react";
 import {useHover} from "@mantine/hooks";
-import {BanIdentity, ConnectRelay, DisconnectRelay, StartRelay, StopRelay} from "../../../wailsjs/go/main/Macro";
+import {
+    BanIdentity,
+    BridgeRelay,
+    ConnectRelay,
+    DisconnectRelay,
+    PairRelay,
+    RefreshPairingCode,
+    StartRelay,
+    StopRelay
+} from "../../../wailsjs/go/main/Macro";
 
 interface RoleMetadata {
     color: string
@@ -48,6 +60,7 @@ function AddRelayModalContent() {
     const runtime = useContext(RuntimeContext)
     const [address, setAddress] = useState("");
     const [identity, setIdentity] = useState("");
+    const [fingerprint, setFingerprint] = useState("");
     const macroState = runtime.State()
     const networking = macroState.Object("networking")
 
@@ -55,6 +68,9 @@ function AddRelayModalContent() {
         console.log("Saving", address, identity)
         const item = networking.List<KeyedObject>("savedRelays").Append(address) as KeyedObject
         item.object.SetAfterInitialization("identity", identity)
+        if (fingerprint.trim() != "") {
+            item.object.SetAfterInitialization("fingerprint", fingerprint.trim().toLowerCase())
+        }
         modals.closeAll();
     }
 
@@ -70,6 +86,12 @@ function AddRelayModalContent() {
                 value={address}
                 onChange={(e) => setAddress(e.currentTarget.value)}
             />
+            <TextInput
+                label="Certificate Fingerprint"
+                description="Optional. If left empty, the first certificate presented by the relay is trusted."
+                value={fingerprint}
+                onChange={(e) => setFingerprint(e.currentTarget.value)}
+            />
             <Button fullWidth mt="md" onClick={handleAdd}>
                 Add
             </Button>
@@ -77,6 +99,74 @@ function AddRelayModalContent() {
     )
 }
 
+function PairRelayModalContent({account, address}: { account: string, address: string }) {
+    const [code, setCode] = useState("");
+
+    const handlePair = () => {
+        PairRelay(account, address, code)
+        modals.closeAll();
+    }
+
+    return (
+        <>
+            <Text size="sm">
+                Enter the pairing code shown on the computer running the relay. Once paired, this account will
+                connect to the relay automatically.
+            </Text>
+            <TextInput
+                label="Pairing Code"
+                mt="xs"
+                value={code}
+                onChange={(e) => setCode(e.currentTarget.value)}
+            />
+            <Button fullWidth mt="md" disabled={code.trim() == ""} onClick={handlePair}>
+                Pair
+            </Button>
+        </>
+    )
+}
+
+function BridgeRelayModalContent({account}: { account: string }) {
+    const [address, setAddress] = useState("");
+    const [code, setCode] = useState("");
+    const [error, setError] = useState("");
+
+    const handleBridge = () => {
+        BridgeRelay(account, address.trim(), code).then((err) => {
+            if (err != "") {
+                setError(err)
+            } else {
+                modals.closeAll();
+            }
+        })
+    }
+
+    return (
+        <>
+            <Text size="sm">
+                Bridge this relay to a relay on another network, so that identities on both networks can exchange
+                messages. Enter the pairing code shown on the computer running the other relay.
+            </Text>
+            <TextInput
+                label="Address"
+                mt="xs"
+                placeholder="203.0.113.5:45645"
+                value={address}
+                onChange={(e) => setAddress(e.currentTarget.value)}
+            />
+            <TextInput
+                label="Pairing Code"
+                value={code}
+                error={error != "" ? error : undefined}
+                onChange={(e) => setCode(e.currentTarget.value)}
+            />
+            <Button fullWidth mt="md" disabled={address.trim() == "" || code.trim() == ""} onClick={handleBridge}>
+                Bridge
+            </Button>
+        </>
+    )
+}
+
 export default function Networking() {
     const [hoveredIndex, setHoveredIndex] = useState("")
     const [actionHovered, setActionHovered] = useState(false)
@@ -87,7 +177,10 @@ export default function Networking() {
     const settings = runtime.Object("settings.networking")
     const networking = macroState.Object("networking")
 
+    const altSync = runtime.Object("settings.tools.altSync")
+
     const autoConnect = settings.Value("autoConnect", false)
+    const autoStartRelay = altSync.Value("autoStartRelay", false)
 
     let relayStarting = networking.Value("relayStarting", false)
     let relayActive = networking.Value("relayActive", false)
@@ -97,6 +190,10 @@ export default function Networking() {
     let connectedAddress = networking.Value("connectedAddress", "")
 
     let identity = networking.Value("identity", "Unknown/Unknown")
+    let pairingCode = networking.Value("pairingCode", "")
+    let upstream = networking.Value("upstream", "")
+    let upstreamConnected = networking.Value("upstreamConnected", false)
+    let paired = networking.Value("networkKey", "") != ""
     let availableRelays = networking.List<KeyedObject>("availableRelays").Values(true)
     let savedRelays = networking.List<KeyedObject>("savedRelays").Values(true)
 
@@ -105,20 +202,29 @@ export default function Networking() {
     let mappedSavedRelays = savedRelays.map((v) => ({
         address: v.object.Concrete<string>("address"),
         identity: v.object.Concrete<string>("identity"),
+        fingerprint: v.object.Concrete<string>("fingerprint"),
         role: 'none',
+        latency: undefined as string | undefined,
+        stale: false,
     }))
     console.log("saved", mappedSavedRelays)
 
     let mappedConnectedIdentities = connectedIdentities.map((v) => ({
         address: v.object.Concrete<string>("address"),
         identity: v.object.Concrete<string>("identity"),
-        role: v.object.Concrete<string>("role")
+        fingerprint: undefined as string | undefined,
+        role: v.object.Concrete<string>("role"),
+        latency: v.object.Concrete<string>("latency"),
+        stale: v.object.Concrete<boolean>("stale"),
     }))
 
     let mappedRelays = availableRelays.map((v) => ({
         address: v.object.Concrete<string>("address"),
         identity: v.object.Concrete<string>("identity"),
-        role: 'none'
+        fingerprint: v.object.Concrete<string>("fingerprint"),
+        role: 'none',
+        latency: undefined as string | undefined,
+        stale: false,
     }))
 
     let uniqueRelays = Array.from(
@@ -143,6 +249,12 @@ export default function Networking() {
             bottom: 9,
             right: 32
         }
+        const pairStyle: React.CSSProperties = {
+            display: hoveredIndex == relay.address ? 'inherit' : 'none',
+            position: 'absolute',
+            bottom: 9,
+            right: 56
+        }
         const loadingStyle: React.CSSProperties = {
             position: 'absolute',
             bottom: 0,
@@ -158,12 +270,24 @@ export default function Networking() {
                 } else {
                     const item = networking.List<KeyedObject>("savedRelays").Append(relay.address!) as KeyedObject
                     item.object.SetAfterInitialization("identity", relay.identity!)
+                    if (relay.fingerprint) {
+                        item.object.SetAfterInitialization("fingerprint", relay.fingerprint)
+                    }
                 }
             }
         }
 
+        const pair = () => modals.open({
+            title: 'Pair with a Relay',
+            children: <PairRelayModalContent account={activeAccount} address={relay.address!}/>,
+        })
+
         const connect = () => {
-            ConnectRelay(activeAccount, relay.address!)
+            if (paired) {
+                ConnectRelay(activeAccount, relay.address!)
+            } else {
+                pair()
+            }
         }
 
         return (
@@ -172,11 +296,13 @@ export default function Networking() {
                       onMouseLeave={() => setHoveredIndex((i) => i == relay.address ? "" : i)}>
                 <Table.Td pb={2} pr={0}><IconNetwork size={16}/></Table.Td>
                 <Table.Td style={{display: 'flex', alignItems: 'center', paddingLeft: 0, position: 'relative'}}>
-                    <Tooltip label={`${relay.identity} @ ${relay.address}`} withArrow>
+                    <Tooltip label={`${relay.identity} @ ${relay.address}` +
+                        (relay.fingerprint ? ` (${relay.fingerprint.slice(0, 16)})` : '') +
+                        (relay.latency ? ` - ${relay.stale ? 'not responding' : relay.latency}` : '')} withArrow>
                         <Text
                             fz={14}
                             mr={4}
-                            c="gray.8"
+                            c={relay.stale ? "orange.7" : "gray.8"}
                             style={{
                                 whiteSpace: 'nowrap',
                                 overflow: 'hidden',
@@ -186,7 +312,7 @@ export default function Networking() {
                                 textAlign: 'left',
                             }}
                         >
-                            {relay.identity!.split('/').slice(-2).join('/')}
+                            {relay.identity!.split('@')[0].split('/').slice(-2).join('/')}
                         </Text>
                     </Tooltip>
                     {connectingAddress != relay.address ? <div>
@@ -211,6 +337,9 @@ export default function Networking() {
                         {!showNetwork && <UnstyledButton onClick={connect}>
                             <IconLogin2 size={18} style={connectStyle}/>
                         </UnstyledButton>}
+                        {(!showNetwork && paired) && <UnstyledButton onClick={pair}>
+                            <IconKey size={18} style={pairStyle}/>
+                        </UnstyledButton>}
                     </div> : <Loader type="dots" color="blue" style={loadingStyle}/>}
                 </Table.Td>
             </Table.Tr>
@@ -277,6 +406,35 @@ export default function Networking() {
                             {relayActive ? 'Active' : 'Start'}
                         </Button>
                     </ControlBox>
+                    {relayActive && <ControlBox height={38} title="Pairing Code">
+                        <Text fz={14} mr={4} c="gray.8" ff="monospace">{pairingCode}</Text>
+                        <Tooltip label="Generate a new code" withArrow>
+                            <UnstyledButton mr={4} mt={4} onClick={() => RefreshPairingCode(activeAccount)}>
+                                <IconRefresh size={18}/>
+                            </UnstyledButton>
+                        </Tooltip>
+                    </ControlBox>}
+                    {relayActive && <ControlBox height={38} title="Bridge">
+                        {upstream != "" && <Tooltip label={upstreamConnected ? `Bridged to ${upstream}` : `Connecting to ${upstream}`} withArrow>
+                            <Text fz={14} mr={4} c={upstreamConnected ? "gray.8" : "orange.7"} style={{
+                                whiteSpace: 'nowrap',
+                                overflow: 'hidden',
+                                textOverflow: 'ellipsis',
+                                width: '100px',
+                                textAlign: 'right',
+                            }}>
+                                {upstream}
+                            </Text>
+                        </Tooltip>}
+                        <Tooltip label={upstream != "" ? "Remove the bridge" : "Bridge to a relay on another network"} withArrow>
+                            <UnstyledButton mr={4} mt={4} onClick={() => upstream != "" ? BridgeRelay(activeAccount, "", "") : modals.open({
+                                title: 'Bridge to a Relay',
+                                children: <BridgeRelayModalContent account={activeAccount}/>,
+                            })}>
+                                {upstream != "" ? <IconLogout size={18}/> : <IconGitMerge size={18}/>}
+                            </UnstyledButton>
+                        </Tooltip>
+                    </ControlBox>}
                     <ControlBox height={38} title="Auto-Connect">
                         <Switch
                             size="md"
@@ -285,6 +443,14 @@ export default function Networking() {
                             height={38}
                         />
                     </ControlBox>
+                    <ControlBox height={38} title="Auto-Start Relay">
+                        <Switch
+                            size="md"
+                            checked={autoStartRelay}
+                            onChange={(event) => altSync.Set("autoStartRelay", event.currentTarget.checked)}
+                            height={38}
+                        />
+                    </ControlBox>
                 </Stack>
             </Grid.Col>
             <Grid.Col span={6}>
@@ -66,6 +66,12 @@ Available commands:\r
  execpattern:   Execute a pattern\r
  moveto:        Move to a field\r
  reset:         Reset to hive\r
+ undo:          Undo the last settings change\r
+ redo:          Redo the last undone change\r
+ history:       List recent settings changes\r
+ report:        Show the session report (markdown, json or post)\r
+ rotatekey:     Re-encrypt secrets with a new key\r
+ remote:        Query or command another account (status, execroutine or execpattern)\r
  clear:         Clear the terminal\r\n`,
 
     echo: (...args: string[]) => {
@@ -104,6 +110,30 @@ Available commands:\r
         EventsEmit("command", "detectvic", args[0])
     },
 
+    undo: (...args: string[]) => {
+        EventsEmit("command", "undo", args[0])
+    },
+
+    redo: (...args: string[]) => {
+        EventsEmit("command", "redo", args[0])
+    },
+
+    history: (...args: string[]) => {
+        EventsEmit("command", "history", args[0])
+    },
+
+    report: (...args: string[]) => {
+        EventsEmit("command", "report", args[0])
+    },
+
+    rotatekey: () => {
+        EventsEmit("command", "rotatekey")
+    },
+
+    remote: (...args: string[]) => {
+        EventsEmit("command", "remote", ...args)
+    },
+
     clear: () => null,
 };
 
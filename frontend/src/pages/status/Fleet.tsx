@@ -0,0 +1,42 @@
+import React, {useContext} from "react";
+import {ScrollArea, Table, Text} from "@mantine/core";
+import {KeyedObject, RuntimeContext} from "../../hooks/useRuntime";
+
+export default function Fleet() {
+    const runtime = useContext(RuntimeContext)
+    const networking = runtime.State().Object("networking")
+    const fleet = networking.List<KeyedObject>("fleet").Values(true)
+
+    const rows = fleet.map((v) => {
+        const identity = v.object.Concrete<string>("identity") ?? ''
+        const running = v.object.Concrete<boolean>("running")
+        const paused = v.object.Concrete<boolean>("paused")
+        const routine = v.object.Concrete<string>("routine")
+        const field = v.object.Concrete<string>("field")
+        return (
+            <Table.Tr key={identity}>
+                <Table.Td>
+                    <Text fz={13} fw={500}>{identity.split('/').slice(-2).join('/')}</Text>
+                    <Text fz={11} c="dimmed">{v.object.Concrete<string>("role") || 'none'}</Text>
+                </Table.Td>
+                <Table.Td>
+                    <Text fz={13}>{paused ? 'Paused' : running ? 'Running' : 'Stopped'}</Text>
+                    <Text fz={11} c="dimmed">{routine}{field ? ` (${field})` : ''}</Text>
+                </Table.Td>
+                <Table.Td>
+                    <Text fz={13} lineClamp={2}>{v.object.Concrete<string>("status")}</Text>
+                </Table.Td>
+            </Table.Tr>
+        )
+    })
+
+    return (
+        <ScrollArea style={{height: '100%'}} type="scroll" offsetScrollbars>
+            {rows.length == 0 ?
+                <Text fz={13} c="dimmed" p={4}>No accounts have reported their status. Statuses are collected by the main.</Text> :
+                <Table striped>
+                    <Table.Tbody>{rows}</Table.Tbody>
+                </Table>}
+        </ScrollArea>
+    )
+}
@@ -1,8 +1,9 @@
 import {Box, Group, Paper, rem, px, Stack, Tabs} from "@mantine/core";
 import React, {useState} from "react";
 import FloatingSelector from "../../components/FloatingSelector";
-import {IconChartBarPopular, IconLogs, IconWebhook} from "@tabler/icons-react";
+import {IconChartBarPopular, IconLogs, IconNetwork, IconWebhook} from "@tabler/icons-react";
 import Console from "./Console";
+import Fleet from "./Fleet";
 
 export default function Status() {
     const iconStyle = { width: rem(16), height: rem(16), marginRight: px(-3) };
@@ -25,6 +26,9 @@ export default function Status() {
                             <Tabs.Tab value="webhook" leftSection={<IconWebhook style={iconStyle} />}>
                                 Webhook
                             </Tabs.Tab>
+                            <Tabs.Tab value="fleet" leftSection={<IconNetwork style={iconStyle} />}>
+                                Fleet
+                            </Tabs.Tab>
                         </Tabs.List>
 
                         <Tabs.Panel value="statistics">
@@ -35,6 +39,10 @@ export default function Status() {
 
                         <Tabs.Panel value="webhook">
                         </Tabs.Panel>
+
+                        <Tabs.Panel value="fleet">
+                            <Fleet />
+                        </Tabs.Panel>
                     </Tabs>
                 </Stack>
             </Paper>
@@ -1,8 +1,12 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
 
+export function AddAccount(arg1:string):Promise<string>;
+
 export function BanIdentity(arg1:string,arg2:string):Promise<void>;
 
+export function BridgeRelay(arg1:string,arg2:string,arg3:string):Promise<string>;
+
 export function ConnectRelay(arg1:string,arg2:string):Promise<void>;
 
 export function DeleteAccount(arg1:string):Promise<string>;
@@ -15,12 +19,18 @@ export function DownloadDataset():Promise<void>;
 
 export function GetLoginCode():Promise<string>;
 
+export function PairRelay(arg1:string,arg2:string,arg3:string):Promise<void>;
+
 export function Pause(arg1:string):Promise<void>;
 
 export function PauseAll():Promise<void>;
 
 export function ReceiveCommand(arg1:Array<string>):Promise<boolean>;
 
+export function Redo(arg1:string):Promise<string>;
+
+export function RefreshPairingCode(arg1:string):Promise<string>;
+
 export function SetAccountPreset(arg1:string,arg2:string):Promise<string>;
 
 export function Start(arg1:string):Promise<void>;
@@ -34,3 +44,5 @@ export function Stop(arg1:string):Promise<void>;
 export function StopAll():Promise<void>;
 
 export function StopRelay(arg1:string):Promise<void>;
+
+export function Undo(arg1:string):Promise<string>;
@@ -2,10 +2,18 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
 
+export function AddAccount(arg1) {
+  return window['go']['main']['Macro']['AddAccount'](arg1);
+}
+
 export function BanIdentity(arg1, arg2) {
   return window['go']['main']['Macro']['BanIdentity'](arg1, arg2);
 }
 
+export function BridgeRelay(arg1, arg2, arg3) {
+  return window['go']['main']['Macro']['BridgeRelay'](arg1, arg2, arg3);
+}
+
 export function ConnectRelay(arg1, arg2) {
   return window['go']['main']['Macro']['ConnectRelay'](arg1, arg2);
 }
@@ -30,6 +38,10 @@ export function GetLoginCode() {
   return window['go']['main']['Macro']['GetLoginCode']();
 }
 
+export function PairRelay(arg1, arg2, arg3) {
+  return window['go']['main']['Macro']['PairRelay'](arg1, arg2, arg3);
+}
+
 export function Pause(arg1) {
   return window['go']['main']['Macro']['Pause'](arg1);
 }
@@ -42,6 +54,14 @@ export function ReceiveCommand(arg1) {
   return window['go']['main']['Macro']['ReceiveCommand'](arg1);
 }
 
+export function Redo(arg1) {
+  return window['go']['main']['Macro']['Redo'](arg1);
+}
+
+export function RefreshPairingCode(arg1) {
+  return window['go']['main']['Macro']['RefreshPairingCode'](arg1);
+}
+
 export function SetAccountPreset(arg1, arg2) {
   return window['go']['main']['Macro']['SetAccountPreset'](arg1, arg2);
 }
@@ -69,3 +89,7 @@ export function StopAll() {
 export function StopRelay(arg1) {
   return window['go']['main']['Macro']['StopRelay'](arg1);
 }
+
+export function Undo(arg1) {
+  return window['go']['main']['Macro']['Undo'](arg1);
+}
@@ -33,6 +33,9 @@ type Interface struct {
 	NetworkClient *networking.Client
 	NetworkRelay  *networking.Relay
 
+	history  *logging.StatusHistory
+	recorder *logging.FlightRecorder
+
 	pause    chan struct{}
 	unpause  chan struct{}
 	stop     chan struct{}
@@ -64,38 +67,19 @@ func (i *Interface) ReceiveCommands() {
 					common.Console(logging.Error, "Expected a pattern name!")
 					return
 				}
-				if i.Macro.Window == nil {
-					common.Console(logging.Error, "Macro not started!")
-					return
-				}
-				if !i.Macro.Pattern.Exists(args[0]) {
-					common.Console(logging.Error, fmt.Sprintf("Pattern \"%s\" does not exist!", args[0]))
-					return
-				}
-				i.Macro.Scratch.Set("PatternToExecute", args[0])
-				if err := i.Macro.SetRedirect(develop.ExecuteDevelopmentPatternRoutineKind); err != nil {
+				if err := i.ExecPattern(args[0]); err != nil {
 					common.Console(logging.Error, err.Error())
 					return
 				}
 				common.Console(logging.Success, "Pattern successfully queued for execution")
-				i.Unpause()
 			},
 			"execroutine": func(args ...string) {
 				if len(args) != 1 {
 					common.Console(logging.Error, "Expected a routine name!")
 					return
 				}
-				if i.Macro.Window == nil {
-					common.Console(logging.Error, "Macro not started!")
-					return
-				}
-				if _, ok := common.Routines[common.RoutineKind(args[0])]; !ok {
-					common.Console(logging.Error, fmt.Sprintf("Routine \"%s\" does not exist!", args[0]))
-					return
-				}
-				if err := i.Macro.SetRedirect(common.RoutineKind(args[0])); err != nil {
+				if err := i.ExecRoutine(args[0]); err != nil {
 					common.Console(logging.Error, err.Error())
-					return
 				}
 			},
 			"detectvic": func(args ...string) {
@@ -129,6 +113,33 @@ func (i *Interface) ReceiveCommands() {
 	}
 }
 
+// ExecPattern redirects the macro to execute a pattern
+func (i *Interface) ExecPattern(pattern string) error {
+	if i.Macro == nil || i.Macro.Window == nil {
+		return errors.New("Macro not started!")
+	}
+	if !i.Macro.Pattern.Exists(pattern) {
+		return errors.New(fmt.Sprintf("Pattern \"%s\" does not exist!", pattern))
+	}
+	i.Macro.Scratch.Set("PatternToExecute", pattern)
+	if err := i.Macro.SetRedirect(develop.ExecuteDevelopmentPatternRoutineKind); err != nil {
+		return err
+	}
+	i.Unpause()
+	return nil
+}
+
+// ExecRoutine redirects the macro to execute a routine
+func (i *Interface) ExecRoutine(routine string) error {
+	if i.Macro == nil || i.Macro.Window == nil {
+		return errors.New("Macro not started!")
+	}
+	if _, ok := common.Routines[common.RoutineKind(routine)]; !ok {
+		return errors.New(fmt.Sprintf("Routine \"%s\" does not exist!", routine))
+	}
+	return i.Macro.SetRedirect(common.RoutineKind(routine))
+}
+
 func (i *Interface) Start() {
 	for len(i.stop) > 0 {
 		<-i.stop
@@ -137,6 +148,8 @@ func (i *Interface) Start() {
 	pause := make(chan (<-chan struct{}), 1)
 	stop := make(chan struct{}, 1)
 	err := make(chan string, 1)
+	i.history = logging.NewStatusHistory(i.Account)
+	i.recorder.Reset()
 	i.Macro = &common.Macro{
 		Account:    i.Account,
 		EventBus:   i.EventBus,
@@ -145,6 +158,8 @@ func (i *Interface) Start() {
 		MacroState: i.State,
 		Database:   i.Database,
 		Logger:     i.Logger,
+		History:    i.history,
+		Recorder:   i.recorder,
 		WinManager: i.WinMgr,
 		VicHop:     i.VicHop,
 		BuffDetect: movement.NewBuffDetector(i.Settings),
@@ -179,8 +194,10 @@ func (i *Interface) Start() {
 		for {
 			select {
 			case stat := <-status:
+				i.history.Record(stat)
 				_ = i.State.SetPath("status", stat)
 			case errStr := <-err:
+				i.history.Error(errStr)
 				i.SendError(errStr)
 				i.Pause()
 			case <-i.pause:
@@ -243,6 +260,8 @@ func (i *Interface) Start() {
 				i.Macro = nil
 				_ = i.State.SetPath("running", false)
 				_ = i.State.SetPath("status", "Ready")
+				i.history.Stop()
+				i.postReport()
 				return
 			}
 		}
@@ -254,6 +273,25 @@ func (i *Interface) Start() {
 	go control.ExecuteRoutine(i.Macro, main, status, err)
 }
 
+// Report returns a report of the current session, or of the last session if the macro is stopped. Nil is returned if
+// the macro has not been started.
+func (i *Interface) Report() *logging.SessionReport {
+	if i.history == nil {
+		return nil
+	}
+	return i.history.Report()
+}
+
+func (i *Interface) postReport() {
+	settings := i.Settings.Object()
+	if settings.Discord == nil || !settings.Discord.Object().SessionReport {
+		return
+	}
+	if err := logging.PostReport(&settings, i.history.Report()); err != nil {
+		_ = i.Logger.Log(0, logging.Error, errors.Wrap(err, "Failed to post session report").Error())
+	}
+}
+
 func (i *Interface) SendError(err string) {
 	dialog.Message(err).Error()
 }
@@ -310,9 +348,13 @@ func NewInterface(
 		pause:   make(chan struct{}, 1),
 		stop:    make(chan struct{}, 1),
 		command: make(chan []string, 100),
+
+		recorder: logging.NewFlightRecorder(account),
 	}
 	ifc.NetworkClient = networking.NewClient(state, ifc.Logger)
 	ifc.NetworkRelay = networking.NewRelay(ifc.NetworkClient, state, ifc.Logger)
+	ifc.handleRemoteRequests()
+	ifc.NetworkClient.PublishStatus(ifc.StatusReply)
 	go ifc.NetworkClient.Start()
 	return ifc
 }
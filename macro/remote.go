@@ -0,0 +1,50 @@
+package macro
+
+import (
+	"fmt"
+	"github.com/nosyliam/revolution/pkg/logging"
+	"github.com/nosyliam/revolution/pkg/networking"
+	"github.com/pkg/errors"
+)
+
+// handleRemoteRequests answers the status queries and commands sent to the account by other clients on the network
+func (i *Interface) handleRemoteRequests() {
+	networking.HandleRequest(i.NetworkClient, func(sender string, _ *networking.StatusQueryMessage) (interface{}, error) {
+		return i.StatusReply(), nil
+	})
+	networking.HandleRequest(i.NetworkClient, func(sender string, request *networking.ExecCommandMessage) (interface{}, error) {
+		_ = i.Logger.Log(0, logging.Info, fmt.Sprintf("Received command \"%s\" from %s", request.Command, sender))
+		if len(request.Args) != 1 {
+			return nil, errors.New("Expected a single argument!")
+		}
+		switch request.Command {
+		case "execroutine":
+			return nil, i.ExecRoutine(request.Args[0])
+		case "execpattern":
+			return nil, i.ExecPattern(request.Args[0])
+		}
+		return nil, errors.New(fmt.Sprintf("Command \"%s\" cannot be executed remotely!", request.Command))
+	})
+}
+
+// StatusReply describes the account to remote status queries, and in the snapshots published to the main
+func (i *Interface) StatusReply() networking.StatusReply {
+	state := i.State.Object()
+	reply := networking.StatusReply{
+		Account: i.Account,
+		Status:  state.Status,
+		Running: state.Running,
+		Paused:  state.Paused,
+	}
+	if i.Macro != nil {
+		scratch := i.Macro.Scratch
+		reply.Routine = scratch.CurrentRoutine()
+		if field, ok := scratch.Lookup("vic-field"); ok {
+			reply.Field, _ = field.(string)
+		}
+		if instance, ok := scratch.Lookup("game-instance"); ok {
+			reply.Server, _ = instance.(string)
+		}
+	}
+	return reply
+}
@@ -47,6 +47,9 @@ func openWindow(macro *Macro) error {
 		return err
 	} else {
 		macro.Root.Window = win
+		if macro.Recorder != nil {
+			win.OnFrame(macro.Recorder.AddFrame)
+		}
 		return nil
 	}
 }
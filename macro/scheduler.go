@@ -104,7 +104,7 @@ func (s *Scheduler) Tick(frame *image.RGBA) {
 	// If we're not opening the window or unwinding a redirect, check the Roblox window
 	if opening := s.macro.Scratch.Stack[0] == string(routines.OpenRobloxRoutineKind); !opening && !s.macro.Scratch.Redirect {
 		if s.macro.Root.Window == nil {
-			s.macro.SetRedirect(routines.OpenRobloxRoutineKind)
+			s.reopen()
 			return
 		}
 		// Fix window every 5 ticks to avoid expensive CGo calls
@@ -112,7 +112,7 @@ func (s *Scheduler) Tick(frame *image.RGBA) {
 			if err := s.macro.Root.Window.Fix(); err != nil && s.adjustFails > 100 {
 				s.macro.Action(Error("Failed to adjust Roblox! Re-opening")(Status))
 				s.macro.Action(Error("Failed to adjust Roblox: %s! Attempting to re-open", err)(Discord))
-				s.macro.SetRedirect(routines.OpenRobloxRoutineKind)
+				s.reopen()
 				return
 			} else if err != nil {
 				s.adjustFails++
@@ -130,6 +130,13 @@ func (s *Scheduler) Tick(frame *image.RGBA) {
 	}
 }
 
+// reopen redirects the macro to re-open Roblox, which is recorded as a reconnect
+func (s *Scheduler) reopen() {
+	if err := s.macro.SetRedirect(routines.OpenRobloxRoutineKind); err == nil && s.macro.History != nil {
+		s.macro.History.Reconnect()
+	}
+}
+
 func (s *Scheduler) Start() {
 	s.close = make(chan struct{}, 1)
 	input := s.macro.Root.Window.Output()
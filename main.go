@@ -4,6 +4,7 @@ import (
 	"embed"
 	"flag"
 	"github.com/getsentry/sentry-go"
+	"github.com/nosyliam/revolution/pkg/config"
 	"github.com/nosyliam/revolution/platform"
 	"github.com/pkg/errors"
 	"github.com/sqweek/dialog"
@@ -33,6 +34,9 @@ func main() {
 	}
 
 	flag.Parse()
+	if flag.Arg(0) == "config" {
+		os.Exit(config.Command(flag.Args()[1:], os.Stdout, os.Stderr))
+	}
 	if *cpuprofile != "" {
 		f, err := os.Create(*cpuprofile)
 		if err != nil {
@@ -65,6 +69,7 @@ func main() {
 		},
 		BackgroundColour: &options.RGBA{R: 210, G: 211, B: 214, A: 0},
 		OnStartup:        app.startup,
+		OnShutdown:       app.shutdown,
 		Bind: []interface{}{
 			app,
 		},
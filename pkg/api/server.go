@@ -0,0 +1,398 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/nosyliam/revolution/pkg/config"
+	"github.com/nosyliam/revolution/pkg/logging"
+	"github.com/pkg/errors"
+	"golang.org/x/net/websocket"
+	"image"
+	"image/png"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAddress   = "127.0.0.1"
+	clientBufferSize = 256
+)
+
+// Controller performs macro actions on behalf of API clients
+type Controller interface {
+	Start(account string)
+	Pause(account string)
+	Stop(account string)
+	Command(args ...string)
+	Screenshot(account string) *image.RGBA
+}
+
+// AccountStatus is the live status of an account
+type AccountStatus struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Running bool   `json:"running"`
+	Paused  bool   `json:"paused"`
+	Health  string `json:"health,omitempty"`
+}
+
+// Event is sent to WebSocket clients whenever the status of an account changes or a message is logged
+type Event struct {
+	Type   string         `json:"type"` // status or log
+	Status *AccountStatus `json:"status,omitempty"`
+	Log    *logging.Entry `json:"log,omitempty"`
+}
+
+type wsClient struct {
+	events chan *Event
+	once   sync.Once
+	done   chan struct{}
+}
+
+func (c *wsClient) close() {
+	c.once.Do(func() { close(c.done) })
+}
+
+// Server exposes macro control and monitoring over HTTP and WebSocket. Every request must carry the token from the API
+// settings, either as a bearer token or as the token query parameter.
+type Server struct {
+	mu            sync.Mutex
+	settings      *config.Object[config.APISettings]
+	state         *config.Object[config.State]
+	controller    Controller
+	server        *http.Server
+	listener      net.Listener
+	clients       map[*wsClient]bool
+	subscriptions []*config.Subscription
+	stopLogs      func()
+}
+
+func NewServer(settings *config.Object[config.APISettings], state *config.Object[config.State], controller Controller) *Server {
+	return &Server{
+		settings:   settings,
+		state:      state,
+		controller: controller,
+		clients:    make(map[*wsClient]bool),
+	}
+}
+
+// Token returns the API token, generating and saving one if none has been set
+func (s *Server) Token() (string, error) {
+	if token := s.settings.Object().Token; token != "" {
+		return token, nil
+	}
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", errors.Wrap(err, "failed to generate token")
+	}
+	token := hex.EncodeToString(buf)
+	if err := s.settings.SetPath("token", token); err != nil {
+		return "", errors.Wrap(err, "failed to save token")
+	}
+	return token, nil
+}
+
+// Start listens on the configured address and begins streaming events to WebSocket clients
+func (s *Server) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.server != nil {
+		return errors.New("the API server is already running")
+	}
+	if _, err := s.Token(); err != nil {
+		return err
+	}
+	settings := s.settings.Object()
+	address := settings.Address
+	if address == "" {
+		address = defaultAddress
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort(address, strconv.Itoa(settings.Port)))
+	if err != nil {
+		return errors.Wrap(err, "failed to listen")
+	}
+	if err := s.watch(); err != nil {
+		_ = listener.Close()
+		return err
+	}
+	s.listener = listener
+	s.server = &http.Server{Handler: s.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		_ = s.server.Serve(listener)
+	}()
+	return nil
+}
+
+// Addr returns the address the server is listening on, or an empty string if it is not running
+func (s *Server) Addr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return ""
+	}
+	return s.listener.Addr().String()
+}
+
+// Close stops the server and disconnects every WebSocket client
+func (s *Server) Close() {
+	s.mu.Lock()
+	server := s.server
+	s.server, s.listener = nil, nil
+	for _, sub := range s.subscriptions {
+		sub.Close()
+	}
+	s.subscriptions = nil
+	if s.stopLogs != nil {
+		s.stopLogs()
+		s.stopLogs = nil
+	}
+	for client := range s.clients {
+		client.close()
+	}
+	s.mu.Unlock()
+	if server != nil {
+		_ = server.Close()
+	}
+}
+
+// watch forwards status changes and log entries to the WebSocket clients
+func (s *Server) watch() error {
+	for _, field := range []string{"status", "running", "paused", "health.status"} {
+		sub, err := s.state.Subscribe(fmt.Sprintf("macros[*].%s", field), func(change config.Change) {
+			if len(change.Wildcards) == 0 {
+				return
+			}
+			if status := s.accountStatus(change.Wildcards[0]); status != nil {
+				s.broadcast(&Event{Type: "status", Status: status})
+			}
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to subscribe to macro state")
+		}
+		s.subscriptions = append(s.subscriptions, sub)
+	}
+	logs, stop := logging.Listen(clientBufferSize)
+	s.stopLogs = stop
+	go func() {
+		for entry := range logs {
+			entry := entry
+			s.broadcast(&Event{Type: "log", Log: &entry})
+		}
+	}()
+	return nil
+}
+
+func (s *Server) broadcast(event *Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for client := range s.clients {
+		select {
+		case client.events <- event:
+		default:
+			// Slow clients are disconnected rather than allowed to block the macro
+			client.close()
+		}
+	}
+}
+
+func (s *Server) accountStatus(name string) *AccountStatus {
+	macros := s.state.Object().Macros
+	if macros == nil {
+		return nil
+	}
+	obj := macros.Lookup(name)
+	if obj == nil {
+		return nil
+	}
+	state := obj.Object()
+	status := &AccountStatus{Name: name, Status: state.Status, Running: state.Running, Paused: state.Paused}
+	if state.Health != nil {
+		status.Health = state.Health.Object().Status
+	}
+	return status
+}
+
+func (s *Server) accounts() []*AccountStatus {
+	var accounts []*AccountStatus
+	if macros := s.state.Object().Macros; macros != nil {
+		macros.ForEach(func(state *config.MacroState) {
+			if status := s.accountStatus(state.AccountName); status != nil {
+				accounts = append(accounts, status)
+			}
+		})
+	}
+	return accounts
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	token := s.settings.Object().Token
+	if token == "" {
+		return false
+	}
+	provided := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if provided == "" {
+		provided = r.URL.Query().Get("token")
+	}
+	return subtle.ConstantTimeCompare([]byte(provided), []byte(token)) == 1
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// Handler returns the API's HTTP handler:
+//
+//	GET  /api/accounts                     list the status of every account
+//	GET  /api/accounts/{name}              get the status of an account
+//	POST /api/accounts/{name}/{action}     start, pause or stop an account
+//	GET  /api/accounts/{name}/screenshot   get the latest screenshot of an account as a PNG
+//	POST /api/command                      run a console command, e.g. {"args": ["execpattern", "vic_path"]}
+//	GET  /api/events                       stream status changes and log entries over WebSocket
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/accounts", s.handleAccounts)
+	mux.HandleFunc("/api/accounts/", s.handleAccount)
+	mux.HandleFunc("/api/command", s.handleCommand)
+	mux.Handle("/api/events", websocket.Server{
+		// Scripts do not send an origin, and every request is already authenticated by its token
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler:   s.handleEvents,
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.authorized(r) {
+			writeError(w, http.StatusUnauthorized, "invalid token")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleAccounts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.accounts())
+}
+
+func (s *Server) handleAccount(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/accounts/"), "/")
+	name := parts[0]
+	status := s.accountStatus(name)
+	if status == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("account %s does not exist", name))
+		return
+	}
+	if len(parts) == 1 {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		writeJSON(w, http.StatusOK, status)
+		return
+	}
+	if len(parts) != 2 {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	action := parts[1]
+	if action == "screenshot" {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		screenshot := s.controller.Screenshot(name)
+		if screenshot == nil {
+			writeError(w, http.StatusNotFound, "no screenshot is available")
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		_ = png.Encode(w, screenshot)
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	switch action {
+	case "start":
+		s.controller.Start(name)
+	case "pause":
+		s.controller.Pause(name)
+	case "stop":
+		s.controller.Stop(name)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Sprintf("unknown action %s", action))
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) handleCommand(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var body struct {
+		Args []string `json:"args"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.Args) == 0 {
+		writeError(w, http.StatusBadRequest, "expected a command")
+		return
+	}
+	// Command output is streamed to WebSocket clients as console log entries
+	s.controller.Command(body.Args...)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) handleEvents(conn *websocket.Conn) {
+	client := &wsClient{events: make(chan *Event, clientBufferSize), done: make(chan struct{})}
+	s.mu.Lock()
+	s.clients[client] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, client)
+		s.mu.Unlock()
+		client.close()
+		_ = conn.Close()
+	}()
+
+	// The current status of every account is sent when a client connects
+	for _, status := range s.accounts() {
+		if err := websocket.JSON.Send(conn, &Event{Type: "status", Status: status}); err != nil {
+			return
+		}
+	}
+	go func() {
+		// Messages from clients are not used, but reading detects disconnects
+		var discard []byte
+		for websocket.Message.Receive(conn, &discard) == nil {
+		}
+		client.close()
+	}()
+	for {
+		select {
+		case event := <-client.events:
+			if err := websocket.JSON.Send(conn, event); err != nil {
+				return
+			}
+		case <-client.done:
+			return
+		}
+	}
+}
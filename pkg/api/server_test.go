@@ -0,0 +1,163 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/nosyliam/revolution/pkg/config"
+	"github.com/nosyliam/revolution/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/websocket"
+	"image"
+	"image/png"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeController struct {
+	sync.Mutex
+	calls      []string
+	screenshot *image.RGBA
+}
+
+func (f *fakeController) record(call string) {
+	f.Lock()
+	defer f.Unlock()
+	f.calls = append(f.calls, call)
+}
+
+func (f *fakeController) Start(account string) { f.record("start " + account) }
+func (f *fakeController) Pause(account string) { f.record("pause " + account) }
+func (f *fakeController) Stop(account string)  { f.record("stop " + account) }
+func (f *fakeController) Command(args ...string) {
+	f.record("command " + strings.Join(args, " "))
+}
+func (f *fakeController) Screenshot(account string) *image.RGBA { return f.screenshot }
+
+func newTestServer(t *testing.T) (*Server, *fakeController, *config.Object[config.State], string) {
+	cwd, _ := os.Getwd()
+	assert.NoError(t, os.Chdir(t.TempDir()))
+	t.Cleanup(func() { _ = os.Chdir(cwd) })
+
+	runtime := config.NewOfflineRuntime()
+	cfg, err := config.NewConfig(runtime)
+	assert.NoError(t, err)
+	state, err := config.NewState(runtime)
+	assert.NoError(t, err)
+	for _, name := range []string{"Main", "Alt"} {
+		assert.NoError(t, state.AppendPath(fmt.Sprintf("macros[%s]", name)))
+	}
+	settings := cfg.Object().API
+	assert.NoError(t, settings.SetPath("port", 0))
+
+	controller := &fakeController{}
+	server := NewServer(settings, state, controller)
+	assert.NoError(t, server.Start())
+	t.Cleanup(server.Close)
+	token := settings.Object().Token
+	assert.Len(t, token, 48, "a token is generated on start")
+	return server, controller, state, token
+}
+
+func request(t *testing.T, server *Server, method, path, token, body string) *http.Response {
+	req, err := http.NewRequest(method, "http://"+server.Addr()+path, strings.NewReader(body))
+	assert.NoError(t, err)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = resp.Body.Close() })
+	return resp
+}
+
+func TestServer_Auth(t *testing.T) {
+	server, controller, _, token := newTestServer(t)
+	assert.True(t, strings.HasPrefix(server.Addr(), "127.0.0.1:"), "the server is bound to localhost")
+
+	assert.Equal(t, http.StatusUnauthorized, request(t, server, http.MethodGet, "/api/accounts", "", "").StatusCode)
+	assert.Equal(t, http.StatusUnauthorized, request(t, server, http.MethodPost, "/api/accounts/Main/start", "wrong", "").StatusCode)
+	assert.Empty(t, controller.calls)
+
+	resp, err := http.Get("http://" + server.Addr() + "/api/accounts?token=" + token)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestServer_Control(t *testing.T) {
+	server, controller, state, token := newTestServer(t)
+	assert.NoError(t, state.SetPath("macros[Main].status", "Game Loaded"))
+	assert.NoError(t, state.SetPath("macros[Main].running", true))
+
+	resp := request(t, server, http.MethodGet, "/api/accounts", token, "")
+	var accounts []AccountStatus
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&accounts))
+	assert.Len(t, accounts, 2)
+	resp = request(t, server, http.MethodGet, "/api/accounts/Main", token, "")
+	var status AccountStatus
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+	assert.Equal(t, AccountStatus{Name: "Main", Status: "Game Loaded", Running: true, Health: "idle"}, status)
+
+	for _, action := range []string{"start", "pause", "stop"} {
+		assert.Equal(t, http.StatusAccepted, request(t, server, http.MethodPost, "/api/accounts/Alt/"+action, token, "").StatusCode)
+	}
+	assert.Equal(t, http.StatusMethodNotAllowed, request(t, server, http.MethodGet, "/api/accounts/Alt/start", token, "").StatusCode)
+	assert.Equal(t, http.StatusNotFound, request(t, server, http.MethodPost, "/api/accounts/Alt/restart", token, "").StatusCode)
+	assert.Equal(t, http.StatusNotFound, request(t, server, http.MethodPost, "/api/accounts/Missing/start", token, "").StatusCode)
+
+	assert.Equal(t, http.StatusAccepted, request(t, server, http.MethodPost, "/api/command", token, `{"args": ["execpattern", "vic_path"]}`).StatusCode)
+	assert.Equal(t, http.StatusBadRequest, request(t, server, http.MethodPost, "/api/command", token, `{"args": []}`).StatusCode)
+	assert.Equal(t, []string{"start Alt", "pause Alt", "stop Alt", "command execpattern vic_path"}, controller.calls)
+
+	assert.Equal(t, http.StatusNotFound, request(t, server, http.MethodGet, "/api/accounts/Main/screenshot", token, "").StatusCode)
+	controller.screenshot = image.NewRGBA(image.Rect(0, 0, 8, 6))
+	resp = request(t, server, http.MethodGet, "/api/accounts/Main/screenshot", token, "")
+	assert.Equal(t, "image/png", resp.Header.Get("Content-Type"))
+	img, err := png.Decode(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, 8, img.Bounds().Dx())
+}
+
+func TestServer_Events(t *testing.T) {
+	server, _, state, token := newTestServer(t)
+
+	_, err := websocket.Dial("ws://"+server.Addr()+"/api/events", "", "http://localhost")
+	assert.Error(t, err, "websocket connections require a token")
+
+	conn, err := websocket.Dial("ws://"+server.Addr()+"/api/events?token="+token, "", "http://localhost")
+	assert.NoError(t, err)
+	defer conn.Close()
+	receive := func() Event {
+		var event Event
+		assert.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+		assert.NoError(t, websocket.JSON.Receive(conn, &event))
+		return event
+	}
+
+	// The status of every account is sent on connect
+	names := []string{receive().Status.Name, receive().Status.Name}
+	assert.ElementsMatch(t, []string{"Main", "Alt"}, names)
+
+	assert.NoError(t, state.SetPath("macros[Alt].status", "Claiming hive"))
+	event := receive()
+	assert.Equal(t, "status", event.Type)
+	assert.Equal(t, "Alt", event.Status.Name)
+	assert.Equal(t, "Claiming hive", event.Status.Status)
+
+	assert.NoError(t, logging.NewLogger("Alt", nil).Log(0, logging.Error, "Failed to claim hive!"))
+	event = receive()
+	assert.Equal(t, "log", event.Type)
+	assert.Equal(t, "Alt", event.Log.Account)
+	assert.Equal(t, logging.Error, event.Log.Level)
+	assert.Equal(t, "Failed to claim hive!", event.Log.Message)
+
+	// Closing the server disconnects clients
+	server.Close()
+	var discard Event
+	assert.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	assert.Error(t, websocket.JSON.Receive(conn, &discard))
+}
@@ -58,6 +58,8 @@ type Macro struct {
 	BuffDetect BuffDetector
 	Pattern    PatternLoader
 	Logger     *logging.Logger
+	History    *logging.StatusHistory
+	Recorder   *logging.FlightRecorder
 	Window     *window.Window
 	WinManager *window.Manager
 	Scratch    *config.Scratch
@@ -171,6 +173,8 @@ func (m *Macro) Copy() *Macro {
 		Scratch:    m.Scratch,
 		Subroutine: m.Subroutine,
 		Logger:     m.Logger,
+		History:    m.History,
+		Recorder:   m.Recorder,
 		Pause:      m.Pause,
 		Stop:       m.Stop,
 		Redirect:   m.Redirect,
@@ -1,5 +1,7 @@
 package common
 
+import "time"
+
 type MessageKind int
 
 const (
@@ -12,6 +14,14 @@ const (
 	NightDetectMessageKind
 	SearchedServerMessageKind
 	ShutdownMessageKind
+	ChallengeMessageKind
+	HeartbeatMessageKind
+	AckHeartbeatMessageKind
+	DeliveryAckMessageKind
+	ResponseMessageKind
+	StatusQueryMessageKind
+	ExecCommandMessageKind
+	StatusSnapshotMessageKind
 	UnknownMessageKind
 )
 
@@ -30,7 +40,14 @@ type Message struct {
 	Receiver string
 	Content  string
 
-	Data []byte `json:"-"`
+	ID   string `json:",omitempty"`
+	Time time.Time
+
+	// The relays a message was bridged through, in order
+	Route []string `json:",omitempty"`
+
+	Sequence  uint64 `json:",omitempty"`
+	Signature string `json:",omitempty"`
 }
 
 type Network struct {
@@ -48,6 +65,7 @@ type NetworkClient interface {
 	Send(receiver string, content interface{})
 	Broadcast(content interface{})
 	Connect(address string) error
+	Pair(address, code string) error
 	Disconnect()
 	UnsubscribeAll()
 }
@@ -0,0 +1,338 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const commandUsage = `usage: revolution config [-file settings|state|database] [-json] [-reveal] <command> [path] [value]
+
+commands:
+  get <path>           print the value at path
+  set <path> <value>   set a primitive value
+  append <path> [val]  append to a list (presets[Name] for keyed lists, a value for primitive lists)
+  delete <path>        delete a list element or map entry
+  list [path]          list the fields of an object or the entries of a list
+  rotate-key           re-encrypt all secrets with a newly generated key
+`
+
+// Command edits a configuration file in the working directory using the reactive path syntax without starting the UI.
+// The exit code of the command is returned.
+func Command(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("config", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() { _, _ = fmt.Fprint(stderr, commandUsage) }
+	file := flags.String("file", "settings", "configuration file to edit")
+	jsonOutput := flags.Bool("json", false, "print results as JSON")
+	reveal := flags.Bool("reveal", false, "print secret values in plain text")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	result, err := runCommand(*file, flags.Arg(0), flags.Args()[1:], *reveal)
+	if err != nil {
+		if *jsonOutput {
+			data, _ := json.Marshal(map[string]string{"error": err.Error()})
+			_, _ = fmt.Fprintln(stdout, string(data))
+		} else {
+			_, _ = fmt.Fprintf(stderr, "error: %v\n", err)
+		}
+		return 1
+	}
+	if result == nil {
+		return 0
+	}
+	if *jsonOutput {
+		data, err := json.Marshal(result)
+		if err != nil {
+			_, _ = fmt.Fprintf(stderr, "error: failed to encode result: %v\n", err)
+			return 1
+		}
+		_, _ = fmt.Fprintln(stdout, string(data))
+		return 0
+	}
+	switch value := result.(type) {
+	case []string:
+		for _, entry := range value {
+			_, _ = fmt.Fprintln(stdout, entry)
+		}
+	case map[string]interface{}, []interface{}:
+		data, _ := yaml.Marshal(value)
+		_, _ = fmt.Fprint(stdout, string(data))
+	default:
+		_, _ = fmt.Fprintln(stdout, value)
+	}
+	return 0
+}
+
+func loadRoot(name string) (Reactive, error) {
+	runtime := NewOfflineRuntime()
+	switch name {
+	case "settings":
+		obj, err := NewConfig(runtime)
+		if err != nil {
+			return nil, err
+		}
+		return obj, nil
+	case "state":
+		obj, err := NewState(runtime)
+		if err != nil {
+			return nil, err
+		}
+		return obj, nil
+	case "database":
+		obj, err := NewDatabase(runtime)
+		if err != nil {
+			return nil, err
+		}
+		return obj, nil
+	}
+	return nil, errors.New(fmt.Sprintf("unknown configuration file \"%s\"", name))
+}
+
+func runCommand(file, command string, args []string, reveal bool) (interface{}, error) {
+	var path string
+	if len(args) > 0 {
+		path = args[0]
+	}
+	if command == "rotate-key" {
+		var files []Savable
+		for _, name := range []string{"settings", "state", "database"} {
+			root, err := loadRoot(name)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, root.File())
+		}
+		if err := RotateSecretKey(files...); err != nil {
+			return nil, err
+		}
+		return "secret key rotated", nil
+	}
+	if path == "" && command != "get" && command != "list" {
+		return nil, errors.New(fmt.Sprintf("%s requires a path", command))
+	}
+	root, err := loadRoot(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var chain chain
+	if path != "" {
+		if chain, err = compilePath(path); err != nil {
+			return nil, err
+		}
+	}
+
+	switch command {
+	case "get":
+		value, err := concrete(root, chain)
+		if err != nil {
+			return nil, err
+		}
+		if reveal {
+			return plain(value)
+		}
+		if secretPath(root, chain) {
+			return SecretMask, nil
+		}
+		return maskSecrets(value)
+	case "list":
+		value, err := concrete(root, chain)
+		if err != nil {
+			return nil, err
+		}
+		return entries(value), nil
+	case "set":
+		if len(args) != 2 {
+			return nil, errors.New("set requires a path and a value")
+		}
+		current, err := root.Get(chain, 0)
+		if err != nil {
+			return nil, err
+		}
+		value, err := parseValue(reflect.TypeOf(current), args[1])
+		if err != nil {
+			return nil, errors.Wrap(err, path)
+		}
+		if err := root.Set(chain, 0, value); err != nil {
+			return nil, err
+		}
+		return root.Get(chain, 0)
+	case "append":
+		var value interface{}
+		if !chain[len(chain)-1].brackets {
+			list, err := root.GetConcrete(chain, 0)
+			if err != nil {
+				return nil, err
+			}
+			if t := reflect.TypeOf(list); t == nil || t.Kind() != reflect.Slice {
+				return nil, errors.New(fmt.Sprintf("%s is not a list", path))
+			} else if t.Elem().Kind() != reflect.Ptr {
+				if len(args) != 2 {
+					return nil, errors.New("appending to a primitive list requires a value")
+				}
+				if value, err = parseValue(t.Elem(), args[1]); err != nil {
+					return nil, errors.Wrap(err, path)
+				}
+			}
+		}
+		if err := root.Append(chain, 0, value); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	case "delete":
+		if !chain[len(chain)-1].brackets {
+			return nil, errors.New("only list elements and map entries can be deleted")
+		}
+		if err := root.Delete(chain, 0); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+	return nil, errors.New(fmt.Sprintf("unknown command \"%s\"", command))
+}
+
+func concrete(root Reactive, chain chain) (interface{}, error) {
+	if len(chain) == 0 {
+		return root.(reactiveObject).object(), nil
+	}
+	value, err := root.GetConcrete(chain, 0)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, errors.New(fmt.Sprintf("%s does not exist", chain))
+	}
+	if obj, ok := value.(reactiveObject); ok {
+		return obj.object(), nil
+	}
+	return value, nil
+}
+
+// plain converts a concrete configuration value into maps, slices and primitives keyed by their YAML field names
+func plain(value interface{}) (interface{}, error) {
+	data, err := yaml.Marshal(value)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal value")
+	}
+	var result interface{}
+	if err := yaml.Unmarshal(data, &result); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal value")
+	}
+	return result, nil
+}
+
+// secretPath reports whether the path refers to a field tagged as secret
+func secretPath(root Reactive, chain chain) bool {
+	if len(chain) == 0 || chain[len(chain)-1].brackets {
+		return false
+	}
+	parent, err := concrete(root, chain.TrimRight())
+	if err != nil {
+		return false
+	}
+	v := reflect.ValueOf(parent)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return false
+	}
+	t := v.Elem().Type()
+	for i := 0; i < t.NumField(); i++ {
+		if getFieldTag(t.Field(i).Tag) == chain[len(chain)-1].val {
+			return isSecret(t.Field(i))
+		}
+	}
+	return false
+}
+
+func entries(value interface{}) []string {
+	var result = make([]string, 0)
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Slice:
+		for i := 0; i < rv.Len(); i++ {
+			elem := rv.Index(i).Interface()
+			if obj, ok := elem.(reactiveObject); ok {
+				if key := objectKey(obj.object()); key != "" {
+					result = append(result, key)
+				} else {
+					result = append(result, strconv.Itoa(i))
+				}
+			} else {
+				result = append(result, fmt.Sprint(elem))
+			}
+		}
+	case reflect.Map:
+		result = append(result, sortedKeys(rv)...)
+	case reflect.Ptr:
+		if rv.Elem().Kind() != reflect.Struct {
+			break
+		}
+		t := rv.Elem().Type()
+		for i := 0; i < t.NumField(); i++ {
+			if tag := getFieldTag(t.Field(i).Tag); tag != "" {
+				result = append(result, tag)
+			}
+		}
+	default:
+		result = append(result, fmt.Sprint(value))
+	}
+	return result
+}
+
+func objectKey(obj interface{}) string {
+	val := reflect.ValueOf(obj).Elem()
+	for i := 0; i < val.NumField(); i++ {
+		if val.Type().Field(i).Tag.Get("key") == "true" {
+			return val.Field(i).String()
+		}
+	}
+	return ""
+}
+
+func parseValue(t reflect.Type, raw string) (interface{}, error) {
+	if t == nil {
+		return nil, errors.New("the value has an unsupported type")
+	}
+	if t == durationType || t == timeType {
+		val, err := convertValue(t, raw)
+		if err != nil {
+			return nil, err
+		}
+		return val.Interface(), nil
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int64:
+		if val, err := strconv.ParseInt(raw, 10, 64); err != nil {
+			return nil, errors.New(fmt.Sprintf("\"%s\" is not an integer", raw))
+		} else {
+			return reflect.ValueOf(val).Convert(t).Interface(), nil
+		}
+	case reflect.Float64:
+		if val, err := strconv.ParseFloat(raw, 64); err != nil {
+			return nil, errors.New(fmt.Sprintf("\"%s\" is not a number", raw))
+		} else {
+			return reflect.ValueOf(val).Convert(t).Interface(), nil
+		}
+	case reflect.Bool:
+		if val, err := strconv.ParseBool(strings.ToLower(raw)); err != nil {
+			return nil, errors.New(fmt.Sprintf("\"%s\" is not a boolean", raw))
+		} else {
+			return reflect.ValueOf(val).Convert(t).Interface(), nil
+		}
+	case reflect.String:
+		return reflect.ValueOf(raw).Convert(t).Interface(), nil
+	}
+	return nil, errors.New(fmt.Sprintf("values of type %s cannot be set from the command line", t))
+}
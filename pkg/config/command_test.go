@@ -0,0 +1,51 @@
+package config
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCommand(t *testing.T) {
+	cwd, _ := os.Getwd()
+	assert.NoError(t, os.Chdir(t.TempDir()))
+	defer os.Chdir(cwd)
+
+	tests := []struct {
+		args   []string
+		code   int
+		output string
+	}{
+		{[]string{"get", "presets[Default].vicHop.role"}, 0, "\n"},
+		{[]string{"set", "presets[Default].vicHop.role", "searcher"}, 0, "searcher\n"},
+		{[]string{"-json", "get", "presets[Default].vicHop.role"}, 0, "\"searcher\"\n"},
+		{[]string{"set", "presets[Default].player.moveSpeed", "28.5"}, 0, "28.5\n"},
+		{[]string{"set", "presets[Default].vicHop.enabled", "maybe"}, 1, ""},
+		{[]string{"set", "presets[Default].macro.keyDelay", "fast"}, 1, ""},
+		{[]string{"append", "presets[Alt]"}, 0, ""},
+		{[]string{"append", "presets[Alt]"}, 1, ""},
+		{[]string{"-json", "list", "presets"}, 0, "[\"Default\",\"Alt\"]\n"},
+		{[]string{"append", "tools.jellyTool.beeTypes", "Basic"}, 0, ""},
+		{[]string{"-json", "get", "tools.jellyTool.beeTypes"}, 0, "[\"Basic\"]\n"},
+		{[]string{"delete", "presets[Alt]"}, 0, ""},
+		{[]string{"list", "presets"}, 0, "Default\n"},
+		{[]string{"-json", "get", "presets[Default].macro"}, 0, "{\"keyDelay\":50}\n"},
+		{[]string{"-json", "get", "presets[Missing].macro"}, 1, ""},
+		{[]string{"-file", "state", "get", "config.defaultPreset"}, 0, "Default\n"},
+		{[]string{"frobnicate", "presets"}, 1, ""},
+	}
+	for _, test := range tests {
+		var stdout, stderr bytes.Buffer
+		code := Command(test.args, &stdout, &stderr)
+		assert.Equal(t, test.code, code, strings.Join(test.args, " ")+": "+stderr.String())
+		if test.code == 0 {
+			assert.Equal(t, test.output, stdout.String(), strings.Join(test.args, " "))
+		}
+	}
+
+	data, err := os.ReadFile("settings.yaml")
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "moveSpeed: 28.5")
+}
@@ -3,74 +3,172 @@ package config
 import (
 	"fmt"
 	"github.com/pkg/errors"
+	"strings"
+	"sync"
 )
 
 type Account struct {
 	Name           string `yaml:"name" key:"true"`
 	Preset         string `yaml:"preset"`
 	ServerID       string `yaml:"serverID,omitempty"`
-	LinkCode       string `yaml:"linkCode,omitempty"`
+	LinkCode       string `yaml:"linkCode,omitempty" secret:"true" validate:"privateServerLink"`
 	Invalid        bool   `yaml:"invalid,omitempty"`
 	WindowConfigID string `yaml:"windowConfigID,omitempty"`
+
+	db *databaseContext
 }
 
-func (a *Account) Load() error {
-	// Load account information & private servers from token
+const maxAccountNameLength = 32
+
+func validateAccountName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return errors.New("An account name is required")
+	}
+	if name != strings.TrimSpace(name) {
+		return errors.New("Account names cannot start or end with spaces")
+	}
+	if len(name) > maxAccountNameLength {
+		return errors.New(fmt.Sprintf("Account names cannot be longer than %d characters", maxAccountNameLength))
+	}
+	if strings.ContainsAny(name, "[]") {
+		return errors.New("Account names cannot contain brackets")
+	}
+	if strings.EqualFold(name, "Default") {
+		return errors.New("The name \"Default\" is reserved for the default account")
+	}
 	return nil
 }
 
-func (a *Account) Refresh() error {
+func (a *Account) validate() error {
+	if err := validateAccountName(a.Name); err != nil {
+		return err
+	}
+	if a.LinkCode != "" {
+		if err := validate("privateServerLink", a.LinkCode); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("The private server link for account %s is malformed", a.Name))
+		}
+	}
 	return nil
 }
 
-func (a *Account) GenerateJoinUrl(ignoreLink bool) (string, error) {
-	if a.Invalid {
-		return "", errors.New(fmt.Sprintf("The session for account %s has expired", a.Name))
+// Load attaches a loaded account to its database and validates it, marking the account as invalid on failure
+func (a *Account) Load() error {
+	if a.db == nil {
+		return errors.New(fmt.Sprintf("Account %s is not attached to a database", a.Name))
 	}
+	if err := a.validate(); err != nil {
+		if !a.Invalid {
+			_ = a.db.object.SetPathf(true, "accounts[%s].invalid", a.Name)
+		}
+		return err
+	}
+	return nil
+}
 
-	//
-
-	return "", nil
+// Refresh re-validates an account, clearing its invalid flag if the account is usable again
+func (a *Account) Refresh() error {
+	if a.db == nil {
+		return errors.New(fmt.Sprintf("Account %s is not attached to a database", a.Name))
+	}
+	if err := a.Load(); err != nil {
+		return err
+	}
+	if a.Invalid {
+		return a.db.object.SetPathf(false, "accounts[%s].invalid", a.Name)
+	}
+	return nil
 }
 
+// Delete releases the resources held by a removed account: its macro state and any reserved window
 func (a *Account) Delete() {
+	if a.db == nil {
+		return
+	}
+	a.db.mu.Lock()
+	state, hooks := a.db.state, a.db.deleteHooks
+	a.db.mu.Unlock()
+	if state != nil && Concrete[MacroState](state, "macros[%s]", a.Name) != nil {
+		_ = state.DeletePathf("macros[%s]", a.Name)
+	}
+	for _, hook := range hooks {
+		hook(a)
+	}
+	a.db = nil
+}
 
+// databaseContext is shared by every copy of an AccountDatabase and by its accounts
+type databaseContext struct {
+	mu          sync.Mutex
+	object      *Object[AccountDatabase]
+	state       *Object[State]
+	deleteHooks []func(account *Account)
 }
 
 type AccountDatabase struct {
 	Accounts *List[Account] `yaml:"accounts"`
 	Servers  *List[Server]  `yaml:"servers"`
+
+	db *databaseContext
 }
 
-func (d *AccountDatabase) Add(code string) {
-	/*
-		account, err := NewAccount(d, code)
-		if err != nil {
-			// TODO: send error to UI
-		}
-		d.accountMap[code] = account*/
+// SetState sets the state whose macro state entries are removed along with their accounts
+func (d *AccountDatabase) SetState(state *Object[State]) {
+	d.db.mu.Lock()
+	d.db.state = state
+	d.db.mu.Unlock()
 }
 
-func (d *AccountDatabase) Delete(name string) {
-	/*
-		if _, ok := d.accountMap[name]; !ok {
-			return
-		}
-		delete(d.accountMap, name)
-		for i, account := range d.Accounts {
-			if account.Name == name {
-				d.Accounts = append(d.Accounts[:i], d.Accounts[i+1:]...)
-			}
-		}*/
+// OnDelete registers a hook which is called after an account is removed from the database
+func (d *AccountDatabase) OnDelete(hook func(account *Account)) {
+	d.db.mu.Lock()
+	d.db.deleteHooks = append(d.db.deleteHooks, hook)
+	d.db.mu.Unlock()
+}
+
+// Add creates a new account using the given preset. Account names are unique regardless of case.
+func (d *AccountDatabase) Add(name string, preset string) (*Object[Account], error) {
+	if err := validateAccountName(name); err != nil {
+		return nil, err
+	}
+	var exists bool
+	d.Accounts.ForEach(func(account *Account) {
+		exists = exists || strings.EqualFold(account.Name, name)
+	})
+	if exists {
+		return nil, errors.New(fmt.Sprintf("An account named \"%s\" already exists", name))
+	}
+	if err := d.db.object.AppendPathf("accounts[%s]", name); err != nil {
+		return nil, errors.Wrap(err, "Failed to add account")
+	}
+	object := d.Accounts.Lookup(name)
+	if object == nil {
+		return nil, errors.New(fmt.Sprintf("Failed to add account \"%s\"", name))
+	}
+	if err := object.SetPath("preset", preset); err != nil {
+		return nil, errors.Wrap(err, "Failed to set account preset")
+	}
+	if err := object.obj.Load(); err != nil {
+		return nil, err
+	}
+	return object, nil
+}
+
+// Delete removes an account from the database along with its macro state and window reservation
+func (d *AccountDatabase) Delete(name string) error {
+	object := d.Accounts.Lookup(name)
+	if object == nil {
+		return errors.New(fmt.Sprintf("Account \"%s\" does not exist", name))
+	}
+	if err := d.db.object.DeletePathf("accounts[%s]", name); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("Failed to delete account \"%s\"", name))
+	}
+	return nil
 }
 
 func (d *AccountDatabase) Get(name string) *Account {
-	/*
-		if account, ok := d.accountMap[name]; !ok {
-			return nil
-		} else {
-			return account
-		}*/
+	if object := d.Accounts.Lookup(name); object != nil {
+		return object.obj
+	}
 	return nil
 }
 
@@ -79,5 +177,24 @@ func NewDatabase(runtime *Runtime) (*Object[AccountDatabase], error) {
 	if err := db.load(); err != nil {
 		return nil, errors.Wrap(err, "Failed to load macro state")
 	}
-	return db.Object(), nil
+	obj := db.Object()
+	ctx := &databaseContext{object: obj}
+	obj.obj.db = ctx
+	obj.obj.Accounts.ForEachObject(func(account *Object[Account]) {
+		account.obj.db = ctx
+		_ = account.obj.Load()
+	})
+	// Accounts may also be appended or deleted by the frontend and the undo history
+	if _, err := obj.Subscribe("accounts", func(change Change) {
+		account := change.Value.(*Object[Account]).obj
+		switch change.Op {
+		case Append:
+			account.db = ctx
+		case Delete:
+			account.Delete()
+		}
+	}); err != nil {
+		return nil, err
+	}
+	return obj, nil
 }
@@ -0,0 +1,125 @@
+package config
+
+import (
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strings"
+	"testing"
+)
+
+func newTestDatabase(t *testing.T) (*Object[AccountDatabase], *Object[State]) {
+	runtime := &Runtime{roots: make(map[string]Reactive)}
+	state, err := NewState(runtime)
+	assert.NoError(t, err)
+	db, err := NewDatabase(runtime)
+	assert.NoError(t, err)
+	database := db.Object()
+	database.SetState(state)
+	return db, state
+}
+
+func TestAccountDatabase_AddGet(t *testing.T) {
+	cwd, _ := os.Getwd()
+	assert.NoError(t, os.Chdir(t.TempDir()))
+	defer os.Chdir(cwd)
+
+	db, _ := newTestDatabase(t)
+	database := db.Object()
+	account, err := database.Add("Alt", "Default")
+	assert.NoError(t, err)
+	assert.Equal(t, "Default", account.Object().Preset)
+	assert.Equal(t, account.object(), database.Get("Alt"))
+	assert.Nil(t, database.Get("Missing"))
+
+	tests := []struct {
+		name string
+		err  bool
+	}{
+		{"alt", true},
+		{"Default", true},
+		{"", true},
+		{" Padded", true},
+		{"Bad[Name]", true},
+		{"A Very Long Account Name That Exceeds The Limit", true},
+		{"Second", false},
+	}
+	for _, test := range tests {
+		_, err := database.Add(test.name, "Default")
+		assert.Equal(t, test.err, err != nil, test.name)
+	}
+	assert.Equal(t, 2, db.LengthPath("accounts"))
+
+	data, err := os.ReadFile("accounts.yaml")
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "name: Second")
+}
+
+func TestAccountDatabase_DeleteCascade(t *testing.T) {
+	cwd, _ := os.Getwd()
+	assert.NoError(t, os.Chdir(t.TempDir()))
+	defer os.Chdir(cwd)
+
+	db, state := newTestDatabase(t)
+	database := db.Object()
+	var released []string
+	database.OnDelete(func(account *Account) {
+		released = append(released, account.Name)
+	})
+	for _, name := range []string{"Alt", "Other"} {
+		_, err := database.Add(name, "Default")
+		assert.NoError(t, err)
+		assert.NoError(t, state.AppendPathf("macros[%s]", name))
+	}
+
+	assert.NoError(t, database.Delete("Alt"))
+	assert.Error(t, database.Delete("Alt"))
+	assert.Nil(t, database.Get("Alt"))
+	assert.Nil(t, Concrete[MacroState](state, "macros[Alt]"))
+	assert.NotNil(t, Concrete[MacroState](state, "macros[Other]"))
+	assert.Equal(t, []string{"Alt"}, released)
+
+	// Deletions made through the reactive path (e.g. by the frontend) cascade as well
+	assert.NoError(t, db.DeletePath("accounts[Other]"))
+	assert.Nil(t, Concrete[MacroState](state, "macros[Other]"))
+	assert.Equal(t, []string{"Alt", "Other"}, released)
+
+	// The name becomes available again once deleted
+	_, err := database.Add("alt", "Default")
+	assert.NoError(t, err)
+}
+
+func TestAccount_LoadRefresh(t *testing.T) {
+	// The window package registers the real validator, which cannot be imported here
+	RegisterValidator("privateServerLink", func(value interface{}) error {
+		if strings.Contains(value.(string), " ") {
+			return errors.New("invalid link")
+		}
+		return nil
+	})
+	cwd, _ := os.Getwd()
+	assert.NoError(t, os.Chdir(t.TempDir()))
+	defer os.Chdir(cwd)
+
+	assert.NoError(t, os.WriteFile("accounts.yaml", []byte(`accounts:
+    - name: Alt
+      preset: Default
+      linkCode: bad code
+`), 0644))
+	db, _ := newTestDatabase(t)
+	database := db.Object()
+	account := database.Get("Alt")
+	assert.NotNil(t, account)
+	assert.True(t, account.Invalid)
+	assert.Error(t, account.Refresh())
+
+	assert.NoError(t, db.SetPath("accounts[Alt].linkCode", "12345"))
+	assert.NoError(t, account.Refresh())
+	assert.False(t, account.Invalid)
+
+	// Full links are validated by the registered validator, and remain valid once loaded
+	link := "https://www.roblox.com/games/1537690962/Bee-Swarm-Simulator?privateServerLinkCode=12345"
+	assert.NoError(t, db.SetPath("accounts[Alt].linkCode", link))
+	assert.NoError(t, account.Load())
+	assert.False(t, account.Invalid)
+}
@@ -26,35 +26,44 @@ type File[T any] struct {
 	mu      sync.Mutex
 	name    string
 	path    string
+	dir     string // The directory holding the file and the key encrypting its secrets
 	file    *os.File
 	runtime *Runtime
 	format  Format
 	obj     *T
 }
 
+func (f *File[T]) Dir() string {
+	return f.dir
+}
+
 func (f *File[T]) Runtime() *Runtime {
 	return f.runtime
 }
 
-func (f *File[T]) Save() error {
-	f.mu.Lock()
-	defer f.mu.Unlock()
-	var data []byte
-	var err error
+// marshal encodes the file's object while its values are locked against concurrent writes
+func (f *File[T]) marshal() ([]byte, error) {
+	if f.runtime != nil {
+		f.runtime.data.RLock()
+		defer f.runtime.data.RUnlock()
+	}
 	switch f.format {
 	case JSON:
-		data, err = json.Marshal(f.obj)
-		if err != nil {
-			return errors.Wrap(err, "failed to marshal")
-		}
+		return marshalJSONSecrets(f.obj, f.dir)
 	case YAML:
-		data, err = yaml.Marshal(f.obj)
-		if err != nil {
-			return errors.Wrap(err, "failed to marshal")
-		}
+		return marshalSecrets(f.obj, f.dir)
 	default:
 		panic("unknown format")
 	}
+}
+
+func (f *File[T]) Save() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, err := f.marshal()
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal")
+	}
 	if err := f.file.Truncate(0); err != nil {
 		return err
 	}
@@ -76,6 +85,7 @@ func (f *File[T]) load() error {
 	}
 
 	path := filepath.Join(cwd, f.path)
+	f.dir = filepath.Dir(path)
 	f.file, err = os.OpenFile(path, os.O_RDWR, 0755)
 	if os.IsNotExist(err) {
 		f.file, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0755)
@@ -107,6 +117,9 @@ func (f *File[T]) load() error {
 		panic("unknown format")
 	}
 
+	if err := decryptSecrets(&obj, f.dir); err != nil {
+		return errors.Wrap(err, "failed to decrypt secrets")
+	}
 	f.obj = &obj
 	return nil
 }
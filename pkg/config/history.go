@@ -0,0 +1,150 @@
+package config
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"reflect"
+	"time"
+)
+
+const historyLimit = 100
+
+// Transaction records a single persisted set, append or delete operation along with the values required to reverse it
+type Transaction struct {
+	Op       ListenOp
+	Path     string
+	OldValue interface{}
+	NewValue interface{}
+	Time     time.Time
+
+	undo func() error
+	redo func() error
+}
+
+func (t *Transaction) String() string {
+	switch t.Op {
+	case Set:
+		return fmt.Sprintf("set %s: %v -> %v", t.Path, t.OldValue, t.NewValue)
+	case Append:
+		return fmt.Sprintf("append %s", t.Path)
+	default:
+		return fmt.Sprintf("delete %s", t.Path)
+	}
+}
+
+type history struct {
+	undo []*Transaction
+	redo []*Transaction
+}
+
+func persisted(meta reflect.StructField) bool {
+	tag := meta.Tag.Get("yaml")
+	return tag != "" && tag != "-"
+}
+
+func (r *Runtime) record(tx *Transaction) {
+	if r.replaying.Load() {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	if r.history == nil {
+		r.history = make(map[string]*history)
+	}
+	root := getRoot(tx.Path)
+	h, ok := r.history[root]
+	if !ok {
+		h = &history{}
+		r.history[root] = h
+	}
+	tx.Time = time.Now()
+	h.undo = append(h.undo, tx)
+	if len(h.undo) > historyLimit {
+		h.undo = h.undo[len(h.undo)-historyLimit:]
+	}
+	h.redo = nil
+}
+
+// apply replays an operation against the root object owning the given path
+func (r *Runtime) apply(op ListenOp, path string, value interface{}) error {
+	root, ok := r.roots[getRoot(path)]
+	if !ok {
+		return errors.New(fmt.Sprintf("unknown configuration file \"%s\"", getRoot(path)))
+	}
+	chain, err := compilePath(getPath(path))
+	if err != nil {
+		return err
+	}
+	switch op {
+	case Set:
+		return root.Set(chain, 0, value)
+	case Append:
+		return root.Append(chain, 0, value)
+	default:
+		return root.Delete(chain, 0)
+	}
+}
+
+func (r *Runtime) replay(file string, undo bool) (*Transaction, error) {
+	r.Lock()
+	h, ok := r.history[file]
+	if !ok {
+		h = &history{}
+	}
+	from, to := &h.undo, &h.redo
+	if !undo {
+		from, to = &h.redo, &h.undo
+	}
+	if len(*from) == 0 {
+		r.Unlock()
+		if undo {
+			return nil, errors.New(fmt.Sprintf("there is nothing to undo in %s", file))
+		}
+		return nil, errors.New(fmt.Sprintf("there is nothing to redo in %s", file))
+	}
+	tx := (*from)[len(*from)-1]
+	*from = (*from)[:len(*from)-1]
+	r.Unlock()
+
+	r.replaying.Store(true)
+	var err error
+	if undo {
+		err = tx.undo()
+	} else {
+		err = tx.redo()
+	}
+	r.replaying.Store(false)
+	if err != nil {
+		return tx, errors.Wrap(err, fmt.Sprintf("failed to replay \"%s\"", tx))
+	}
+
+	r.Lock()
+	*to = append(*to, tx)
+	r.Unlock()
+	return tx, nil
+}
+
+// Undo reverts the most recent transaction recorded for the given file (settings, state or database)
+func (r *Runtime) Undo(file string) (*Transaction, error) {
+	return r.replay(file, true)
+}
+
+// Redo re-applies the most recently undone transaction for the given file
+func (r *Runtime) Redo(file string) (*Transaction, error) {
+	return r.replay(file, false)
+}
+
+// History returns the undoable transactions for the given file, most recent first
+func (r *Runtime) History(file string) []*Transaction {
+	r.Lock()
+	defer r.Unlock()
+	h, ok := r.history[file]
+	if !ok {
+		return nil
+	}
+	var txs []*Transaction
+	for i := len(h.undo) - 1; i >= 0; i-- {
+		txs = append(txs, h.undo[i])
+	}
+	return txs
+}
@@ -0,0 +1,114 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func newHistoryRoot[T any](name string) (*Object[T], *Runtime) {
+	file := &mockFile{runtime: &Runtime{roots: make(map[string]Reactive)}}
+	obj := &Object[T]{}
+	_ = obj.Initialize(name, file)
+	file.runtime.AddRoot(name, obj)
+	return obj, file.runtime
+}
+
+func TestHistory_UndoRedoSet(t *testing.T) {
+	type nested struct {
+		Speed int `yaml:"speed" default:"24"`
+	}
+	type object struct {
+		Val    string          `yaml:"val" default:"a"`
+		Status string          `state:"status" yaml:"-"`
+		Nest   *Object[nested] `yaml:"nest"`
+	}
+
+	obj, runtime := newHistoryRoot[object]("settings")
+	assert.NoError(t, obj.SetPath("val", "b"))
+	assert.NoError(t, obj.SetPath("nest.speed", 30))
+	assert.NoError(t, obj.SetPath("status", "ignored"))
+	assert.Len(t, runtime.History("settings"), 2)
+
+	tx, err := runtime.Undo("settings")
+	assert.NoError(t, err)
+	assert.Equal(t, "settings.nest.speed", tx.Path)
+	val, _ := obj.GetPath("nest.speed")
+	assert.Equal(t, 24, val)
+
+	_, err = runtime.Undo("settings")
+	assert.NoError(t, err)
+	val, _ = obj.GetPath("val")
+	assert.Equal(t, "a", val)
+	_, err = runtime.Undo("settings")
+	assert.Error(t, err)
+
+	_, err = runtime.Redo("settings")
+	assert.NoError(t, err)
+	val, _ = obj.GetPath("val")
+	assert.Equal(t, "b", val)
+	assert.Len(t, runtime.History("settings"), 1)
+
+	// A new change clears the redo stack
+	assert.NoError(t, obj.SetPath("val", "c"))
+	_, err = runtime.Redo("settings")
+	assert.Error(t, err)
+}
+
+func TestHistory_UndoDeleteKeyed(t *testing.T) {
+	type nested struct {
+		Role string `yaml:"role" default:"searcher"`
+	}
+	type preset struct {
+		Name   string          `yaml:"name" key:"true"`
+		Speed  int             `yaml:"speed" default:"24"`
+		VicHop *Object[nested] `yaml:"vicHop"`
+	}
+	type object struct {
+		Presets *List[preset] `yaml:"presets"`
+	}
+
+	obj, runtime := newHistoryRoot[object]("settings")
+	assert.NoError(t, obj.AppendPath("presets[Default]"))
+	assert.NoError(t, obj.AppendPath("presets[Alt]"))
+	assert.NoError(t, obj.SetPath("presets[Alt].vicHop.role", "main"))
+
+	var events []ListenOp
+	assert.NoError(t, obj.ListenPath("presets", func(op ListenOp, _ interface{}) {
+		events = append(events, op)
+	}))
+	assert.NoError(t, obj.DeletePath("presets[Alt]"))
+	assert.Nil(t, Concrete[preset](obj, "presets[Alt]"))
+
+	tx, err := runtime.Undo("settings")
+	assert.NoError(t, err)
+	assert.Equal(t, Delete, tx.Op)
+	assert.Equal(t, 2, obj.LengthPath("presets"))
+	val, err := obj.GetPath("presets[Alt].vicHop.role")
+	assert.NoError(t, err)
+	assert.Equal(t, "main", val)
+	assert.Equal(t, []ListenOp{Delete, Append}, events)
+
+	_, err = runtime.Redo("settings")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, obj.LengthPath("presets"))
+
+	// Undo the redone delete, then the role change and both appends
+	for i := 0; i < 4; i++ {
+		_, err = runtime.Undo("settings")
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 0, obj.LengthPath("presets"))
+}
+
+func TestHistory_Limit(t *testing.T) {
+	type object struct {
+		Count int `yaml:"count"`
+	}
+
+	obj, runtime := newHistoryRoot[object]("state")
+	for i := 1; i <= historyLimit+10; i++ {
+		assert.NoError(t, obj.SetPath("count", i))
+	}
+	assert.Len(t, runtime.History("state"), historyLimit)
+	assert.Equal(t, historyLimit+10, runtime.History("state")[0].NewValue)
+}
@@ -7,6 +7,7 @@ import (
 	"reflect"
 	"regexp"
 	"runtime/debug"
+	"slices"
 	"strconv"
 	"strings"
 )
@@ -78,6 +79,35 @@ func (c chain) TrimRight() chain {
 	return c[:len(c)-1]
 }
 
+// lock write-locks the values of the object's file and returns the function releasing the lock
+func (c *config) lock() func() {
+	if c.file == nil || c.file.Runtime() == nil {
+		return func() {}
+	}
+	mu := &c.file.Runtime().data
+	mu.Lock()
+	return mu.Unlock
+}
+
+// rlock read-locks the values of the object's file and returns the function releasing the lock
+func (c *config) rlock() func() {
+	if c.file == nil || c.file.Runtime() == nil {
+		return func() {}
+	}
+	mu := &c.file.Runtime().data
+	mu.RLock()
+	return mu.RUnlock
+}
+
+// reactive returns the object held by a field. Primitive fields are not read, since they may be written concurrently.
+func reactive(field reflect.Value) (Reactive, bool) {
+	if field.Kind() != reflect.Ptr {
+		return nil, false
+	}
+	obj, ok := field.Interface().(Reactive)
+	return obj, ok
+}
+
 func (c *config) errPath(err string) error {
 	return errors.New(fmt.Sprintf("%s: %s", c.path, err))
 }
@@ -87,12 +117,15 @@ func (c *config) errPanic(err string) {
 }
 
 func (c *config) setField(meta reflect.StructField, field reflect.Value, chain chain, index int, value interface{}) error {
-	if obj, ok := field.Interface().(Reactive); ok {
+	if obj, ok := reactive(field); ok {
 		if index+1 == len(chain) {
 			return errors.New("cannot set an object value")
 		}
 		return obj.Set(chain, index+1, value)
 	}
+	if field.Kind() == reflect.Map {
+		return c.setMapField(meta, field, chain, index, value)
+	}
 	if len(chain)-1 != index {
 		return c.errPath("cannot index a primitive value")
 	}
@@ -102,32 +135,48 @@ func (c *config) setField(meta reflect.StructField, field reflect.Value, chain c
 	} else {
 		path = fmt.Sprintf("%s.%s", c.path, chain[index].val)
 	}
+	old, _ := c.getField(field, chain, index)
+	val, err := convertValue(field.Type(), value)
+	if err != nil {
+		return c.errPath(err.Error())
+	}
 	switch field.Kind() {
-	case reflect.Int:
-		var val int
-		switch v := value.(type) {
-		case int:
-			val = v
-		case float64:
-			val = int(v)
-		}
-		field.SetInt(int64(val))
-		c.file.Runtime().Set(path, val)
-	case reflect.Bool:
-		field.SetBool(value.(bool))
-		c.file.Runtime().Set(path, value.(bool))
-	case reflect.String:
-		field.SetString(value.(string))
-		c.file.Runtime().Set(path, value.(string))
-	case reflect.Float64:
-		field.SetFloat(value.(float64))
-		c.file.Runtime().Set(path, value.(float64))
+	case reflect.Int, reflect.Int64, reflect.Bool, reflect.String, reflect.Float64:
 	default:
-		return errors.New("unsupported value")
+		if field.Type() != timeType {
+			return errors.New("unsupported value")
+		}
+	}
+	if err := validateField(meta, val.Interface()); err != nil {
+		return c.errPath(err.Error())
 	}
+	unlock := c.lock()
+	field.Set(val)
+	value = field.Interface()
+	unlock()
+	c.file.Runtime().Set(path, uiValue(value))
 	if listener, ok := c.listeners[chain.String()]; ok {
 		listener(Set, value)
 	}
+	c.file.Runtime().notify(Set, path, value)
+	if isSecret(meta) {
+		registerSecret(val.String())
+	}
+	if current, _ := c.getField(field, chain, index); persisted(meta) && current != old {
+		runtime := c.file.Runtime()
+		tx := &Transaction{
+			Op:       Set,
+			Path:     path,
+			OldValue: old,
+			NewValue: current,
+			undo:     func() error { return runtime.apply(Set, path, old) },
+			redo:     func() error { return runtime.apply(Set, path, current) },
+		}
+		if isSecret(meta) {
+			tx.OldValue, tx.NewValue = SecretMask, SecretMask
+		}
+		runtime.record(tx)
+	}
 	// TODO: save before runtime update
 	if meta.Tag.Get("yaml") != "" {
 		if err := c.file.Save(); err != nil {
@@ -139,7 +188,7 @@ func (c *config) setField(meta reflect.StructField, field reflect.Value, chain c
 }
 
 func (c *config) getField(field reflect.Value, chain chain, index int) (interface{}, error) {
-	if obj, ok := field.Interface().(Reactive); ok {
+	if obj, ok := reactive(field); ok {
 		if _, ok := obj.(reactiveObject); ok {
 			if index+1 == len(chain) {
 				return nil, c.errPath("cannot get an object value")
@@ -147,13 +196,19 @@ func (c *config) getField(field reflect.Value, chain chain, index int) (interfac
 		}
 		return obj.Get(chain, index+1)
 	}
+	if field.Kind() == reflect.Map {
+		return c.getMapField(field, chain, index, false)
+	}
 	if len(chain)-1 != index {
 		debug.PrintStack()
 		return nil, c.errPath("cannot index a primitive value")
 	}
+	defer c.rlock()()
 	switch field.Kind() {
 	case reflect.Int:
 		return int(field.Int()), nil
+	case reflect.Int64:
+		return field.Interface(), nil
 	case reflect.Bool:
 		return field.Bool(), nil
 	case reflect.String:
@@ -161,12 +216,15 @@ func (c *config) getField(field reflect.Value, chain chain, index int) (interfac
 	case reflect.Float64:
 		return field.Float(), nil
 	default:
-		return errors.New("unsupported value"), nil
+		if field.Type() == timeType {
+			return field.Interface(), nil
+		}
+		return nil, c.errPath("unsupported value")
 	}
 }
 
 func (c *config) getConcreteField(field reflect.Value, chain chain, index int) (interface{}, error) {
-	if obj, ok := field.Interface().(Reactive); ok {
+	if obj, ok := reactive(field); ok {
 		if rObj, ok := obj.(reactiveObject); ok {
 			if index+1 == len(chain) {
 				return rObj.object(), nil
@@ -174,12 +232,188 @@ func (c *config) getConcreteField(field reflect.Value, chain chain, index int) (
 		}
 		return obj.GetConcrete(chain, index+1)
 	}
+	if field.Kind() == reflect.Map {
+		return c.getMapField(field, chain, index, true)
+	}
 	if len(chain)-1 != index {
 		return nil, c.errPath("cannot index a primitive value")
 	}
+	defer c.rlock()()
 	return field.Interface(), nil
 }
 
+func (c *config) mapKey(field reflect.Value, chain chain, index int) (reflect.Value, error) {
+	if field.Type().Key().Kind() != reflect.String {
+		return reflect.Value{}, c.errPath("maps must be keyed by strings")
+	}
+	if len(chain)-2 != index {
+		return reflect.Value{}, c.errPath("cannot index a primitive value")
+	}
+	return reflect.ValueOf(chain[index+1].val).Convert(field.Type().Key()), nil
+}
+
+func (c *config) getMapField(field reflect.Value, chain chain, index int, concrete bool) (interface{}, error) {
+	defer c.rlock()()
+	if len(chain)-1 == index {
+		if concrete {
+			return field.Interface(), nil
+		}
+		return copyMap(field), nil
+	}
+	key, err := c.mapKey(field, chain, index)
+	if err != nil {
+		return nil, err
+	}
+	value := field.MapIndex(key)
+	if !value.IsValid() {
+		if concrete {
+			return nil, nil
+		}
+		return reflect.Zero(field.Type().Elem()).Interface(), nil
+	}
+	return value.Interface(), nil
+}
+
+// setMapField sets a single entry of a string-keyed map, or replaces the entire map when no key is provided
+func (c *config) setMapField(meta reflect.StructField, field reflect.Value, chain chain, index int, value interface{}) error {
+	path := fmt.Sprintf("%s.%s", c.path, chain[index].val)
+	runtime := c.file.Runtime()
+	fieldChain := slices.Clip(chain[:index+1])
+	unlock := c.lock()
+	if field.IsNil() {
+		field.Set(reflect.MakeMap(field.Type()))
+	}
+	unlock()
+
+	if len(chain)-1 == index {
+		val, err := convertValue(field.Type(), value)
+		if err != nil {
+			return c.errPath(err.Error())
+		}
+		unlock := c.lock()
+		old := copyMap(field)
+		var removed []string
+		for _, key := range sortedKeys(field) {
+			if !val.MapIndex(reflect.ValueOf(key).Convert(field.Type().Key())).IsValid() {
+				removed = append(removed, key)
+			}
+		}
+		field.Set(val)
+		current := copyMap(field)
+		unlock()
+		for _, key := range removed {
+			runtime.Delete(fmt.Sprintf("%s[%s]", path, key))
+		}
+		entries := reflect.ValueOf(current)
+		for _, key := range sortedKeys(entries) {
+			runtime.Set(fmt.Sprintf("%s[%s]", path, key), uiValue(entries.MapIndex(reflect.ValueOf(key).Convert(entries.Type().Key())).Interface()))
+		}
+		if listener, ok := c.listeners[fieldChain.String()]; ok {
+			listener(Set, current)
+		}
+		runtime.notify(Set, path, current)
+		if persisted(meta) {
+			runtime.record(&Transaction{
+				Op:       Set,
+				Path:     path,
+				OldValue: old,
+				NewValue: current,
+				undo:     func() error { return runtime.apply(Set, path, old) },
+				redo:     func() error { return runtime.apply(Set, path, current) },
+			})
+		}
+	} else {
+		key, err := c.mapKey(field, chain, index)
+		if err != nil {
+			return err
+		}
+		val, err := convertValue(field.Type().Elem(), value)
+		if err != nil {
+			return c.errPath(err.Error())
+		}
+		var old interface{}
+		unlock := c.lock()
+		if prev := field.MapIndex(key); prev.IsValid() {
+			old = prev.Interface()
+		}
+		field.SetMapIndex(key, val)
+		values := copyMap(field)
+		unlock()
+		entryPath := fmt.Sprintf("%s[%s]", path, key)
+		runtime.Set(entryPath, uiValue(val.Interface()))
+		if listener, ok := c.listeners[append(fieldChain, link{key.String(), true}).String()]; ok {
+			listener(Set, val.Interface())
+		}
+		if listener, ok := c.listeners[fieldChain.String()]; ok {
+			listener(Set, values)
+		}
+		runtime.notify(Set, entryPath, val.Interface())
+		if current := val.Interface(); persisted(meta) && old != current {
+			tx := &Transaction{Op: Set, Path: entryPath, OldValue: old, NewValue: current}
+			tx.undo = func() error {
+				if old == nil {
+					return runtime.apply(Delete, entryPath, nil)
+				}
+				return runtime.apply(Set, entryPath, old)
+			}
+			tx.redo = func() error { return runtime.apply(Set, entryPath, current) }
+			runtime.record(tx)
+		}
+	}
+	if meta.Tag.Get("yaml") != "" {
+		if err := c.file.Save(); err != nil {
+			return errors.Wrap(err, "failed to save to file")
+		}
+	}
+	return nil
+}
+
+func (c *config) deleteMapField(meta reflect.StructField, field reflect.Value, chain chain, index int) error {
+	if len(chain)-1 == index {
+		return c.errPath("a map key must be provided")
+	}
+	key, err := c.mapKey(field, chain, index)
+	if err != nil {
+		return err
+	}
+	unlock := c.lock()
+	removed := field.MapIndex(key)
+	if !removed.IsValid() {
+		unlock()
+		return c.errPath(fmt.Sprintf("invalid key \"%s\"", key))
+	}
+	old := removed.Interface()
+	field.SetMapIndex(key, reflect.Value{})
+	current := copyMap(field)
+	unlock()
+	path := fmt.Sprintf("%s.%s[%s]", c.path, chain[index].val, key)
+	runtime := c.file.Runtime()
+	runtime.Delete(path)
+	fieldChain := slices.Clip(chain[:index+1])
+	if listener, ok := c.listeners[append(fieldChain, link{key.String(), true}).String()]; ok {
+		listener(Delete, old)
+	}
+	if listener, ok := c.listeners[fieldChain.String()]; ok {
+		listener(Delete, current)
+	}
+	runtime.notify(Delete, path, old)
+	if persisted(meta) {
+		runtime.record(&Transaction{
+			Op:       Delete,
+			Path:     path,
+			OldValue: old,
+			undo:     func() error { return runtime.apply(Set, path, old) },
+			redo:     func() error { return runtime.apply(Delete, path, nil) },
+		})
+	}
+	if meta.Tag.Get("yaml") != "" {
+		if err := c.file.Save(); err != nil {
+			return errors.Wrap(err, "failed to save to file")
+		}
+	}
+	return nil
+}
+
 type List[T any] struct {
 	config
 	meta       reflect.StructField
@@ -200,7 +434,7 @@ func (c *List[T]) MarshalYAML() (interface{}, error) {
 func (c *List[T]) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	var zero [0]T
 	tt := reflect.TypeOf(zero).Elem()
-	if tt.Kind() != reflect.Struct {
+	if isPrimitive(tt) {
 		var temp []T
 		if err := unmarshal(&temp); err != nil {
 			return err
@@ -238,43 +472,69 @@ func (c *List[T]) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return nil
 }
 
+// ForEach calls the callback with a copy of each element, since the elements may be written concurrently
 func (c *List[T]) ForEach(callback func(*T)) {
+	var values []*T
+	runlock := c.rlock()
 	if c.prim != nil {
 		for _, v := range c.prim {
-			callback(&v)
+			v := v
+			values = append(values, &v)
 		}
 	} else if c.index != nil {
 		for _, obj := range c.index {
-			callback(obj.obj)
+			v := *obj.obj
+			values = append(values, &v)
 		}
 	} else {
 		for _, obj := range c.obj {
-			callback(obj.obj)
+			v := *obj.obj
+			values = append(values, &v)
 		}
 	}
+	runlock()
+	for _, v := range values {
+		callback(v)
+	}
+}
+
+// Lookup returns the element of a keyed list with the given key, or nil if it does not exist
+func (c *List[T]) Lookup(key string) *Object[T] {
+	if c.index == nil {
+		return nil
+	}
+	defer c.rlock()()
+	return c.index[key]
 }
 
 func (c *List[T]) ForEachObject(callback func(*Object[T])) {
 	if c.prim != nil {
 		panic("cannot call for each object on a primitive list")
-	} else if c.index != nil {
+	}
+	var objs []*Object[T]
+	runlock := c.rlock()
+	if c.index != nil {
 		for _, obj := range c.index {
-			callback(obj)
+			objs = append(objs, obj)
 		}
 	} else {
-		for _, obj := range c.obj {
-			callback(obj)
-		}
+		objs = slices.Clone(c.obj)
+	}
+	runlock()
+	for _, obj := range objs {
+		callback(obj)
 	}
 }
 
 func (c *List[T]) Initialize(path string, file Savable) error {
-	c.listeners = make(map[string]func(ListenOp, interface{}))
+	if c.listeners == nil {
+		c.listeners = make(map[string]func(ListenOp, interface{}))
+	}
 	c.file = file
 	if c.prim == nil && c.obj == nil {
 		var zero [0]T
 		tt := reflect.TypeOf(zero).Elem()
-		if tt.Kind() != reflect.Struct {
+		if isPrimitive(tt) {
 			c.prim = make([]T, 0)
 		} else {
 			c.obj = make([]*Object[T], 0)
@@ -311,13 +571,34 @@ func (c *List[T]) Initialize(path string, file Savable) error {
 	} else if c.prim != nil {
 		c.file.Runtime().Append(fmt.Sprintf("%s[_init]", path), true, c.keySz)
 		for i, value := range c.prim {
-			c.file.Runtime().Set(fmt.Sprintf("%s[%d]", path, i), value)
-
+			c.file.Runtime().Set(fmt.Sprintf("%s[%d]", path, i), uiValue(value))
 		}
 	}
 	return nil
 }
 
+// element returns the reflected element of the list at the given key or index, or nil if it does not exist
+func (c *List[T]) element(key string) (reflect.Value, bool) {
+	defer c.rlock()()
+	if c.index != nil {
+		if val, ok := c.index[key]; ok {
+			return reflect.ValueOf(val), true
+		}
+		return reflect.Value{}, false
+	}
+	idx, err := strconv.Atoi(key)
+	if c.prim != nil {
+		if err != nil || idx < 0 || idx >= len(c.prim) {
+			return reflect.Value{}, false
+		}
+		return reflect.ValueOf(c.prim).Index(idx), true
+	}
+	if err != nil || idx < 0 || idx >= len(c.obj) {
+		return reflect.Value{}, false
+	}
+	return reflect.ValueOf(c.obj[idx]), true
+}
+
 func (c *List[T]) Set(chain chain, index int, value interface{}) error {
 	if len(chain) == index {
 		return c.errPath("a key must be provided")
@@ -332,27 +613,16 @@ func (c *List[T]) Set(chain chain, index int, value interface{}) error {
 		if chain[index+1].val == c.keySz {
 			return c.errPath(fmt.Sprintf("cannot modify object key \"%s\"", chain[index].val))
 		}
-		if val, ok := c.index[chain[index].val]; ok {
-			return c.setField(c.meta, reflect.ValueOf(val), chain, index, value)
+		if val, ok := c.element(chain[index].val); ok {
+			return c.setField(c.meta, val, chain, index, value)
 		} else {
 			return c.errPath(fmt.Sprintf("invalid key \"%s\"", chain[index].val))
 		}
 	}
-	idx, err := strconv.Atoi(chain[index].val)
-	var count int
-	var slice interface{}
-	if c.prim != nil {
-		count = len(c.prim)
-		slice = c.prim
-	} else {
-		count = len(c.obj)
-		slice = c.obj
-	}
-	if err != nil || (idx < 0 || idx >= count) {
+	field, ok := c.element(chain[index].val)
+	if !ok {
 		return c.errPath("invalid index")
 	}
-
-	field := reflect.ValueOf(slice).Index(idx)
 	return c.setField(c.meta, field, chain, index, value)
 }
 
@@ -364,61 +634,49 @@ func (c *List[T]) Get(chain chain, index int) (interface{}, error) {
 		return nil, c.errPath("list values must be indexed with brackets")
 	}
 	if c.index != nil {
-		if val, ok := c.index[chain[index].val]; ok {
-			return c.getField(reflect.ValueOf(val), chain, index)
+		if val, ok := c.element(chain[index].val); ok {
+			return c.getField(val, chain, index)
 		} else {
 			return nil, c.errPath(fmt.Sprintf("invalid key \"%s\"", chain[index].val))
 		}
 	}
-	var count int
-	var slice interface{}
-	if c.prim != nil {
-		count = len(c.prim)
-		slice = c.prim
-	} else {
-		count = len(c.obj)
-		slice = c.obj
-	}
-	idx, err := strconv.Atoi(chain[index].val)
-	if err != nil || (idx < 0 || idx >= count) {
+	field, ok := c.element(chain[index].val)
+	if !ok {
 		return nil, c.errPath("invalid integer index")
 	}
-
-	field := reflect.ValueOf(slice).Index(idx)
 	return c.getField(field, chain, index)
 }
 
 func (c *List[T]) GetConcrete(chain chain, index int) (interface{}, error) {
+	if len(chain) == index {
+		defer c.rlock()()
+		return c.list(), nil
+	}
 	if c.prim != nil {
-		idx, err := strconv.Atoi(chain[index].val)
-		if err != nil || (idx < 0 || idx >= len(c.prim)) {
+		field, ok := c.element(chain[index].val)
+		if !ok {
 			return nil, c.errPath("invalid integer index")
 		}
-		field := reflect.ValueOf(c.prim).Index(idx)
+		defer c.rlock()()
 		return field.Interface(), nil
 	}
-	if len(chain) == index {
-		return c.list(), nil
-	}
 	if !chain[index].brackets {
 		return nil, c.errPath("list values must be indexed with brackets")
 	}
 	if c.index != nil {
-		if val, ok := c.index[chain[index].val]; ok {
+		if val, ok := c.element(chain[index].val); ok {
 			if len(chain) == index+1 {
-				return val, nil
+				return val.Interface(), nil
 			}
-			return c.getConcreteField(reflect.ValueOf(val), chain, index)
+			return c.getConcreteField(val, chain, index)
 		} else {
 			return nil, nil
 		}
 	}
-	idx, err := strconv.Atoi(chain[index].val)
-	if err != nil || (idx < 0 || idx >= len(c.obj)) {
+	field, ok := c.element(chain[index].val)
+	if !ok {
 		return nil, c.errPath("invalid integer index")
 	}
-
-	field := reflect.ValueOf(c.obj).Index(idx)
 	if len(chain) == index+1 {
 		return field.Interface(), nil
 	}
@@ -432,54 +690,74 @@ func (c *List[T]) Append(chain chain, index int, value interface{}) error {
 	if c.index != nil && !chain[index].brackets {
 		return c.errPath("list values must be indexed with brackets")
 	}
-	if len(chain)-1 != index && c.index != nil {
-		if val, ok := c.index[chain[index].val]; ok {
-			return val.Append(chain, index+1, nil)
+	if index < len(chain)-1 && c.index != nil {
+		if val, ok := c.element(chain[index].val); ok {
+			return val.Interface().(*Object[T]).Append(chain, index+1, value)
 		} else {
 			return c.errPath(fmt.Sprintf("invalid key \"%s\"", chain[index].val))
 		}
-	} else if len(chain)-1 != index && c.obj != nil {
-		idx, err := strconv.Atoi(chain[index].val)
-		if err != nil || (idx < 0 || idx >= len(c.obj)) {
+	} else if index < len(chain)-1 && c.obj != nil {
+		val, ok := c.element(chain[index].val)
+		if !ok {
 			return c.errPath("invalid integer index")
 		}
-		return c.obj[idx].Append(chain, index+1, nil)
+		return val.Interface().(*Object[T]).Append(chain, index+1, value)
 	}
 
 	if c.prim != nil {
-		c.prim = append(c.prim, value.(T))
+		var zero [0]T
+		val, err := convertValue(reflect.TypeOf(zero).Elem(), value)
+		if err != nil {
+			return c.errPath(err.Error())
+		}
+		elem := val.Interface().(T)
+		unlock := c.lock()
+		c.prim = append(c.prim, elem)
 		path := fmt.Sprintf("%s[%d]", c.path, len(c.prim)-1)
-		c.file.Runtime().Set(path, value)
+		unlock()
+		c.file.Runtime().Set(path, uiValue(elem))
 		if c.meta.Tag.Get("yaml") != "" {
 			if err := c.file.Save(); err != nil {
 				return errors.Wrap(err, "failed to save to file")
 			}
 		}
 		if listener, ok := c.listeners[chain.TrimRight().String()]; ok {
-			listener(Append, value.(T))
+			listener(Append, elem)
 		}
+		c.file.Runtime().notify(Append, path, elem)
+		c.recordAppend(path, c.path, elem)
 		return nil
 	}
 
 	cfo := &Object[T]{}
+	var path string
 	if c.key != "" {
 		key := chain[index].val
-		if _, ok := c.index[key]; ok {
+		if _, ok := c.element(key); ok {
 			return c.errPath(fmt.Sprintf("key \"%s\" already exists", key))
 		}
-		path := fmt.Sprintf("%s[%s]", c.path, chain[index].val)
+		path = fmt.Sprintf("%s[%s]", c.path, chain[index].val)
 		c.file.Runtime().Append(path, false, c.keySz)
 		_ = cfo.Initialize(path, c.file)
 		ref := reflect.ValueOf(cfo.obj)
 		ref.Elem().FieldByName(c.key).SetString(key)
 		c.file.Runtime().Set(fmt.Sprintf("%s[%s].%s", c.path, key, c.keySz), key)
+		unlock := c.lock()
+		if _, ok := c.index[key]; ok {
+			unlock()
+			return c.errPath(fmt.Sprintf("key \"%s\" already exists", key))
+		}
 		c.index[key] = cfo
+		c.obj = append(c.obj, cfo)
+		unlock()
 	} else {
-		path := fmt.Sprintf("%s[%d]", c.path, len(c.obj))
+		path = fmt.Sprintf("%s[%d]", c.path, c.Length(nil, 0))
 		c.file.Runtime().Append(path, false, c.keySz)
 		_ = cfo.Initialize(path, c.file)
+		unlock := c.lock()
+		c.obj = append(c.obj, cfo)
+		unlock()
 	}
-	c.obj = append(c.obj, cfo)
 	if c.meta.Tag.Get("yaml") != "" {
 		if err := c.file.Save(); err != nil {
 			return errors.Wrap(err, "failed to save to file")
@@ -488,9 +766,85 @@ func (c *List[T]) Append(chain chain, index int, value interface{}) error {
 	if listener, ok := c.listeners[chain.TrimRight().String()]; ok {
 		listener(Append, cfo)
 	}
+	c.file.Runtime().notify(Append, path, cfo)
+	if c.key != "" {
+		c.recordAppend(path, path, nil)
+	} else {
+		c.recordAppend(path, c.path, nil)
+	}
 	return nil
 }
 
+// recordAppend records an append of the element at the given path. The append path is the path which is replayed
+// to recreate the element: the element path itself for keyed lists, or the list path for indexed lists
+func (c *List[T]) recordAppend(path, appendPath string, value interface{}) {
+	if !persisted(c.meta) {
+		return
+	}
+	runtime := c.file.Runtime()
+	tx := &Transaction{Op: Append, Path: path, NewValue: value}
+	tx.undo = func() error { return runtime.apply(Delete, tx.Path, nil) }
+	tx.redo = func() error {
+		if c.key == "" {
+			tx.Path = fmt.Sprintf("%s[%d]", c.path, c.Length(nil, 0))
+		}
+		return runtime.apply(Append, appendPath, value)
+	}
+	runtime.record(tx)
+}
+
+// restore re-inserts a previously deleted element and returns its new path. Primitive values are returned to their
+// original index, while objects are appended to the end of the list.
+func (c *List[T]) restore(idx int, value interface{}) (string, error) {
+	var path string
+	if c.prim != nil {
+		unlock := c.lock()
+		if idx > len(c.prim) {
+			idx = len(c.prim)
+		}
+		c.prim = slices.Insert(c.prim, idx, value.(T))
+		shifted := slices.Clone(c.prim[idx:])
+		unlock()
+		for i, v := range shifted {
+			c.file.Runtime().Set(fmt.Sprintf("%s[%d]", c.path, idx+i), uiValue(v))
+		}
+		path = fmt.Sprintf("%s[%d]", c.path, idx)
+	} else {
+		obj := value.(*Object[T])
+		if c.key != "" {
+			key := reflect.ValueOf(obj.obj).Elem().FieldByName(c.key).String()
+			if _, ok := c.element(key); ok {
+				return "", c.errPath(fmt.Sprintf("key \"%s\" already exists", key))
+			}
+			path = fmt.Sprintf("%s[%s]", c.path, key)
+			c.file.Runtime().Append(path, false, c.keySz)
+			_ = obj.Initialize(path, c.file)
+			c.file.Runtime().Set(fmt.Sprintf("%s.%s", path, c.keySz), key)
+			unlock := c.lock()
+			c.index[key] = obj
+			c.obj = append(c.obj, obj)
+			unlock()
+		} else {
+			path = fmt.Sprintf("%s[%d]", c.path, c.Length(nil, 0))
+			c.file.Runtime().Append(path, false, c.keySz)
+			_ = obj.Initialize(path, c.file)
+			unlock := c.lock()
+			c.obj = append(c.obj, obj)
+			unlock()
+		}
+	}
+	if c.meta.Tag.Get("yaml") != "" {
+		if err := c.file.Save(); err != nil {
+			return "", errors.Wrap(err, "failed to save to file")
+		}
+	}
+	if listener, ok := c.listeners[mustCompilePath(getPath(c.path)).String()]; ok {
+		listener(Append, value)
+	}
+	c.file.Runtime().notify(Append, path, value)
+	return path, nil
+}
+
 func (c *List[T]) Delete(chain chain, index int) error {
 	if c.index != nil && len(chain) == index {
 		return c.errPath("a primary key is required")
@@ -498,26 +852,29 @@ func (c *List[T]) Delete(chain chain, index int) error {
 	if !chain[index].brackets {
 		return errors.New("list values must be indexed with brackets")
 	}
-	var count, idx = 0, -1
 	if len(chain)-1 != index && c.index != nil {
-		if val, ok := c.index[chain[index].val]; ok {
-			return val.Delete(chain, index+1)
+		if val, ok := c.element(chain[index].val); ok {
+			return val.Interface().(*Object[T]).Delete(chain, index+1)
 		} else {
 			return c.errPath(fmt.Sprintf("invalid key \"%s\"", chain[index].val))
 		}
 	} else if len(chain)-1 != index && c.obj != nil {
-		idx, err := strconv.Atoi(chain[index].val)
-		if err != nil || (idx < 0 || idx >= len(c.obj)) {
+		val, ok := c.element(chain[index].val)
+		if !ok {
 			return c.errPath("invalid integer index")
 		}
-		return c.obj[idx].Delete(chain, index+1)
-	} else if c.index != nil {
+		return val.Interface().(*Object[T]).Delete(chain, index+1)
+	}
+	unlock := c.lock()
+	var count, idx = 0, -1
+	if c.index != nil {
 		for i, obj := range c.obj {
 			if reflect.ValueOf(obj.obj).Elem().FieldByName(c.key).String() == chain[index].val {
 				idx = i
 			}
 		}
 		if idx == -1 {
+			unlock()
 			return c.errPath(fmt.Sprintf("invalid key \"%s\"", chain[index].val))
 		}
 	}
@@ -530,40 +887,53 @@ func (c *List[T]) Delete(chain chain, index int) error {
 		var err error
 		idx, err = strconv.Atoi(chain[index].val)
 		if err != nil || (idx < 0 || idx >= count) {
+			unlock()
 			return c.errPath("invalid integer index")
 		}
 	}
 	delete(c.index, chain[index].val)
+	var removed interface{}
 	if c.prim != nil {
-		for i, _ := range c.prim {
-			if listener, ok := c.listeners[chain.TrimRight().String()]; ok {
-				listener(Delete, c.prim[i])
-			}
-			if i == idx {
-				c.prim = append(c.prim[:i], c.prim[i+1:]...)
-			}
-		}
+		removed = c.prim[idx]
+		c.prim = append(c.prim[:idx], c.prim[idx+1:]...)
 	} else {
-		for i, _ := range c.obj {
-			if listener, ok := c.listeners[chain.TrimRight().String()]; ok {
-				listener(Delete, c.obj[i])
-			}
-			if i == idx {
-				c.obj = append(c.obj[:i], c.obj[i+1:]...)
-			}
-		}
+		removed = c.obj[idx]
+		c.obj = append(c.obj[:idx], c.obj[idx+1:]...)
 	}
-	c.file.Runtime().Delete(fmt.Sprintf("%s[%s]", c.path, chain[index].val))
+	unlock()
+	if listener, ok := c.listeners[chain.TrimRight().String()]; ok {
+		listener(Delete, removed)
+	}
+	path := fmt.Sprintf("%s[%s]", c.path, chain[index].val)
+	c.file.Runtime().Delete(path)
+	c.file.Runtime().notify(Delete, path, removed)
 	if c.meta.Tag.Get("yaml") != "" {
 		if err := c.file.Save(); err != nil {
 			return errors.Wrap(err, "failed to save to file")
 		}
 	}
+	if persisted(c.meta) {
+		runtime := c.file.Runtime()
+		tx := &Transaction{Op: Delete, Path: path, OldValue: removed}
+		if obj, ok := removed.(*Object[T]); ok {
+			tx.OldValue = obj.obj
+		}
+		tx.undo = func() error {
+			restored, err := c.restore(idx, removed)
+			if err == nil {
+				tx.Path = restored
+			}
+			return err
+		}
+		tx.redo = func() error { return runtime.apply(Delete, tx.Path, nil) }
+		runtime.record(tx)
+	}
 	return nil
 }
 
 func (c *List[T]) Length(chain chain, index int) int {
 	if len(chain) == index {
+		defer c.rlock()()
 		if c.prim != nil {
 			return len(c.prim)
 		} else {
@@ -573,13 +943,15 @@ func (c *List[T]) Length(chain chain, index int) int {
 	if c.prim != nil {
 		c.errPanic("cannot get the length of a primitive value")
 	}
+	runlock := c.rlock()
 	idx, err := strconv.Atoi(chain[index].val)
 	if err != nil || (idx < 0 || idx >= len(c.obj)) {
+		runlock()
 		c.errPanic("invalid integer index")
 	}
-
-	field := reflect.ValueOf(c.obj).Index(idx)
-	return field.Interface().(Reactive).Length(chain, index+1)
+	obj := c.obj[idx]
+	runlock()
+	return obj.Length(chain, index+1)
 }
 
 func (c *List[T]) Listen(chain chain, index int, callback func(ListenOp, interface{})) error {
@@ -594,8 +966,8 @@ func (c *List[T]) Listen(chain chain, index int, callback func(ListenOp, interfa
 		return c.errPath("list values must be indexed with brackets")
 	}
 	if c.index != nil {
-		if val, ok := c.index[chain[index].val]; ok {
-			return val.Listen(chain, index+1, callback)
+		if val, ok := c.element(chain[index].val); ok {
+			return val.Interface().(*Object[T]).Listen(chain, index+1, callback)
 		} else {
 			return c.errPath(fmt.Sprintf("invalid key \"%s\"", chain[index].val))
 		}
@@ -631,6 +1003,7 @@ func (c *Object[T]) object() interface{} {
 }
 
 func (c *Object[T]) Object() T {
+	defer c.rlock()()
 	return *c.obj
 }
 
@@ -650,7 +1023,9 @@ func (c *Object[T]) UnmarshalYAML(unmarshal func(interface{}) error) error {
 func (c *Object[T]) Initialize(path string, file Savable) error {
 	c.path = path
 	c.file = file
-	c.listeners = make(map[string]func(ListenOp, interface{}))
+	if c.listeners == nil {
+		c.listeners = make(map[string]func(ListenOp, interface{}))
+	}
 	if c.obj == nil {
 		c.obj = new(T)
 		t := reflect.TypeOf(c.obj).Elem()
@@ -662,6 +1037,14 @@ func (c *Object[T]) Initialize(path string, file Savable) error {
 			if def == "" {
 				continue
 			}
+			if field.Type() == durationType || field.Type() == timeType {
+				if v, err := convertValue(field.Type(), def); err == nil {
+					field.Set(v)
+				} else {
+					panic(fmt.Sprintf("invalid %s default", field.Type()))
+				}
+				continue
+			}
 			switch field.Kind() {
 			case reflect.Int:
 				fallthrough
@@ -699,6 +1082,9 @@ func (c *Object[T]) Initialize(path string, file Savable) error {
 	for i := 0; i < val.NumField(); i++ {
 		field := val.Field(i)
 		meta := t.Field(i)
+		if !meta.IsExported() {
+			continue
+		}
 		fieldPath := fmt.Sprintf("%s.%s", path, getFieldTag(meta.Tag))
 		if field.Kind() == reflect.Ptr && meta.Type.Elem().Kind() == reflect.Struct && field.IsNil() {
 			obj := reflect.New(meta.Type.Elem())
@@ -716,11 +1102,19 @@ func (c *Object[T]) Initialize(path string, file Savable) error {
 			if cfg, ok := field.Interface().(reactiveList); ok {
 				cfg.initialize(meta)
 			}
+		} else if field.Kind() == reflect.Map {
+			if field.IsNil() {
+				field.Set(reflect.MakeMap(field.Type()))
+			}
+			for _, key := range sortedKeys(field) {
+				value := field.MapIndex(reflect.ValueOf(key).Convert(field.Type().Key()))
+				file.Runtime().Set(fmt.Sprintf("%s[%s]", fieldPath, key), uiValue(value.Interface()))
+			}
 		} else {
 			if meta.Tag.Get("key") == "true" {
 				continue
 			}
-			file.Runtime().Set(fieldPath, field.Interface())
+			file.Runtime().Set(fieldPath, uiValue(field.Interface()))
 		}
 	}
 	if strings.Count(c.path, ".") == 0 {
@@ -814,6 +1208,9 @@ func (c *Object[T]) Delete(chain chain, index int) error {
 			case Reactive:
 				return obj.Delete(chain, index+1)
 			default:
+				if field.Kind() == reflect.Map {
+					return c.deleteMapField(meta, field, chain, index)
+				}
 				return c.errPath("cannot delete from a primitive value")
 			}
 		}
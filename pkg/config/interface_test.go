@@ -2,9 +2,11 @@ package config
 
 import (
 	"fmt"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/yaml.v3"
 	"testing"
+	"time"
 )
 
 type mockFile struct {
@@ -57,7 +59,7 @@ func TestConfigObject_SetNestedObjectPrimitive(t *testing.T) {
 	assert.Equal(t, val, true)
 
 	var activated = false
-	callback := func(v interface{}) {
+	callback := func(_ ListenOp, v interface{}) {
 		fmt.Println(v)
 		activated = true
 	}
@@ -104,7 +106,7 @@ func TestConfigObject_List(t *testing.T) {
 	assert.Equal(t, val, "test3")
 
 	var activated = false
-	callback := func(v interface{}) {
+	callback := func(_ ListenOp, v interface{}) {
 		fmt.Println(v)
 		activated = true
 	}
@@ -239,8 +241,9 @@ func TestConfigObject_Runtime(t *testing.T) {
 		Key  *List[keyed]    `yaml:"key"`
 	}
 	var obj = Object[test]{}
+	_mockFile.runtime.events = nil
 	obj.Initialize("Root", _mockFile)
-	assert.Len(t, _mockFile.runtime.events, 3)
+	assert.Len(t, _mockFile.runtime.events, 6)
 	assert.Equal(t, _mockFile.runtime.events[0].value, "test")
 	_mockFile.runtime.events = nil
 	assert.NoError(t, obj.AppendPath("objs"))
@@ -255,3 +258,150 @@ func TestConfigObject_Runtime(t *testing.T) {
 	assert.Len(t, _mockFile.runtime.events, 1)
 	fmt.Println(_mockFile.runtime.events)
 }
+
+func TestConfigObject_Types(t *testing.T) {
+	type object struct {
+		Speed    float64              `yaml:"speed" default:"24.5"`
+		Total    int64                `yaml:"total" default:"5000000000"`
+		Interval time.Duration        `yaml:"interval" default:"1m30s"`
+		Updated  time.Time            `yaml:"updated"`
+		Counts   map[string]int       `yaml:"counts"`
+		Names    map[string]string    `yaml:"names"`
+		Delays   *List[time.Duration] `yaml:"delays"`
+		Times    *List[time.Time]     `yaml:"times"`
+		Floats   *List[float64]       `yaml:"floats"`
+	}
+
+	file := &mockFile{runtime: &Runtime{}}
+	var obj = Object[object]{}
+	assert.NoError(t, obj.Initialize("Root", file))
+	assert.Contains(t, file.runtime.events, event{path: "Root.interval", op: "set", value: "1m30s"})
+	assert.Contains(t, file.runtime.events, event{path: "Root.updated", op: "set", value: ""})
+	assert.Contains(t, file.runtime.events, event{path: "Root.times[_init]", op: "append", primitive: true})
+
+	// Values received from the UI arrive as JSON numbers and strings
+	assert.NoError(t, obj.SetPath("speed", 28.25))
+	assert.NoError(t, obj.SetPath("total", float64(6000000000)))
+	assert.NoError(t, obj.SetPath("interval", "2m"))
+	assert.NoError(t, obj.SetPath("updated", "2024-05-01T12:00:00Z"))
+	assert.Error(t, obj.SetPath("interval", "soon"))
+	assert.Error(t, obj.SetPath("speed", "fast"))
+	val, _ := obj.GetPath("speed")
+	assert.Equal(t, 28.25, val)
+	val, _ = obj.GetPath("total")
+	assert.Equal(t, int64(6000000000), val)
+	val, _ = obj.GetPath("interval")
+	assert.Equal(t, 2*time.Minute, val)
+	val, _ = obj.GetPath("updated")
+	assert.Equal(t, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), val)
+	assert.Contains(t, file.runtime.events, event{path: "Root.interval", op: "set", value: "2m0s"})
+	assert.Contains(t, file.runtime.events, event{path: "Root.updated", op: "set", value: "2024-05-01T12:00:00Z"})
+
+	// Maps
+	var changed interface{}
+	assert.NoError(t, obj.ListenPath("counts", func(_ ListenOp, v interface{}) { changed = v }))
+	assert.NoError(t, obj.SetPath("counts[hives]", float64(3)))
+	assert.NoError(t, obj.SetPath("counts.bees", 50))
+	assert.Equal(t, map[string]int{"hives": 3, "bees": 50}, changed)
+	val, _ = obj.GetPath("counts[hives]")
+	assert.Equal(t, 3, val)
+	val, _ = obj.GetPath("counts[missing]")
+	assert.Equal(t, 0, val)
+	assert.Contains(t, file.runtime.events, event{path: "Root.counts[hives]", op: "set", value: 3})
+	assert.NoError(t, obj.DeletePath("counts[hives]"))
+	assert.Error(t, obj.DeletePath("counts[hives]"))
+	assert.Contains(t, file.runtime.events, event{path: "Root.counts[hives]", op: "delete"})
+	assert.Equal(t, map[string]int{"bees": 50}, *Concrete[map[string]int](&obj, "counts"))
+	assert.NoError(t, obj.SetPath("names", map[string]interface{}{"a": "x", "b": "y"}))
+	assert.NoError(t, obj.SetPath("names", map[string]interface{}{"b": "z"}))
+	assert.Equal(t, map[string]string{"b": "z"}, *Concrete[map[string]string](&obj, "names"))
+	assert.Contains(t, file.runtime.events, event{path: "Root.names[a]", op: "delete"})
+	assert.Error(t, obj.SetPath("counts[x].y", 1))
+
+	// Primitive lists
+	assert.NoError(t, obj.AppendPath("delays"))
+	assert.NoError(t, obj.Append(mustCompilePath("delays"), 0, "5s"))
+	assert.NoError(t, obj.Append(mustCompilePath("times"), 0, "2024-05-01T12:00:00Z"))
+	assert.NoError(t, obj.Append(mustCompilePath("floats"), 0, ""))
+	assert.Error(t, obj.Append(mustCompilePath("floats"), 0, true))
+	assert.NoError(t, obj.SetPath("delays[0]", "1s"))
+	assert.NoError(t, obj.SetPath("floats[0]", 1.5))
+	assert.Equal(t, time.Second, *Concrete[time.Duration](&obj, "delays[0]"))
+	assert.Equal(t, 5*time.Second, *Concrete[time.Duration](&obj, "delays[1]"))
+	assert.Equal(t, 1.5, *Concrete[float64](&obj, "floats[0]"))
+	assert.Contains(t, file.runtime.events, event{path: "Root.delays[1]", op: "set", value: "5s"})
+
+	data, err := yaml.Marshal(&obj)
+	assert.NoError(t, err)
+	var res = `speed: 28.25
+total: 6000000000
+interval: 2m0s
+updated: 2024-05-01T12:00:00Z
+counts:
+    bees: 50
+names:
+    b: z
+delays:
+    - 1s
+    - 5s
+times:
+    - 2024-05-01T12:00:00Z
+floats:
+    - 1.5
+`
+	assert.Equal(t, res, string(data))
+
+	var loaded object
+	assert.NoError(t, yaml.Unmarshal(data, &loaded))
+	obj = Object[object]{obj: &loaded}
+	assert.NoError(t, obj.Initialize("Root", file))
+	val, _ = obj.GetPath("interval")
+	assert.Equal(t, 2*time.Minute, val)
+	val, _ = obj.GetPath("counts[bees]")
+	assert.Equal(t, 50, val)
+	assert.Equal(t, 2, obj.LengthPath("delays"))
+	assert.Equal(t, 5*time.Second, *Concrete[time.Duration](&obj, "delays[1]"))
+	assert.Equal(t, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), *Concrete[time.Time](&obj, "times[0]"))
+	data, err = yaml.Marshal(&obj)
+	assert.NoError(t, err)
+	assert.Equal(t, res, string(data))
+}
+
+func TestConfigObject_TypesHistory(t *testing.T) {
+	type object struct {
+		Counts map[string]int `yaml:"counts"`
+	}
+
+	obj, runtime := newHistoryRoot[object]("settings")
+	assert.NoError(t, obj.SetPath("counts[a]", 1))
+	assert.NoError(t, obj.SetPath("counts[a]", 2))
+	assert.NoError(t, obj.DeletePath("counts[a]"))
+	for i := 0; i < 2; i++ {
+		_, err := runtime.Undo("settings")
+		assert.NoError(t, err)
+	}
+	val, _ := obj.GetPath("counts[a]")
+	assert.Equal(t, 1, val)
+	_, err := runtime.Undo("settings")
+	assert.NoError(t, err)
+	assert.Empty(t, *Concrete[map[string]int](obj, "counts"))
+}
+
+func TestConfigObject_Validate(t *testing.T) {
+	type object struct {
+		Val string `yaml:"val" validate:"testEven"`
+	}
+
+	RegisterValidator("testEven", func(value interface{}) error {
+		if len(value.(string))%2 != 0 {
+			return errors.New("odd length")
+		}
+		return nil
+	})
+	var obj = Object[object]{}
+	obj.Initialize("Root", _mockFile)
+	assert.NoError(t, obj.SetPath("val", "ab"))
+	assert.Error(t, obj.SetPath("val", "abc"))
+	val, _ := obj.GetPath("val")
+	assert.Equal(t, "ab", val)
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/sqweek/dialog"
 	"github.com/wailsapp/wails/v2/pkg/runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -30,6 +31,16 @@ type Runtime struct {
 	errorActive bool
 	evtCounter  int
 	activeEvent int
+	history     map[string]*history
+	replaying   atomic.Bool
+
+	// data guards the values held by the objects of the runtime's files, which are read and written from the macro,
+	// network and orchestrator goroutines. It is never held while listeners, subscriptions or saves are called.
+	data sync.RWMutex
+
+	subMu         sync.RWMutex
+	subscriptions map[int]*Subscription
+	subCounter    int
 }
 
 func (r *Runtime) handleError(op string, err error) bool {
@@ -69,9 +80,19 @@ func (r *Runtime) AddRoot(name string, object Reactive) {
 	r.roots[name] = object
 }
 
+// queue holds an event until the frontend is ready. False is returned if the frontend is already ready.
+func (r *Runtime) queue(evt event) bool {
+	r.Lock()
+	defer r.Unlock()
+	if r.ready {
+		return false
+	}
+	r.events = append(r.events, evt)
+	return true
+}
+
 func (r *Runtime) Set(path string, value interface{}) {
-	if !r.ready {
-		r.events = append(r.events, event{path: path, op: "set", value: value})
+	if r.queue(event{path: path, op: "set", value: value}) {
 		return
 	}
 
@@ -79,16 +100,14 @@ func (r *Runtime) Set(path string, value interface{}) {
 }
 
 func (r *Runtime) Append(path string, primitive bool, key string) {
-	if !r.ready {
-		r.events = append(r.events, event{path: path, op: "append", primitive: primitive, key: key})
+	if r.queue(event{path: path, op: "append", primitive: primitive, key: key}) {
 		return
 	}
 	runtime.EventsEmit(AppContext, "append", path, r.activeEvent, primitive, key)
 }
 
 func (r *Runtime) Delete(path string) {
-	if !r.ready {
-		r.events = append(r.events, event{path: path, op: "delete"})
+	if r.queue(event{path: path, op: "delete"}) {
 		return
 	}
 	runtime.EventsEmit(AppContext, "delete", path, r.activeEvent)
@@ -155,6 +174,8 @@ func (r *Runtime) Listen() {
 
 func (r *Runtime) Start() {
 	r.Listen()
+	r.Lock()
+	defer r.Unlock()
 	for _, evt := range r.events {
 		switch evt.op {
 		case "set":
@@ -166,12 +187,19 @@ func (r *Runtime) Start() {
 	r.events = nil
 }
 
+// NewOfflineRuntime creates a runtime which is not connected to the frontend, e.g. for the command line editor
+func NewOfflineRuntime() *Runtime {
+	return &Runtime{roots: make(map[string]Reactive)}
+}
+
 func NewRuntime(ctx context.Context) *Runtime {
 	app := &Runtime{roots: make(map[string]Reactive)}
 	ready := make(chan bool)
 	runtime.EventsOnce(ctx, "ready", func(...interface{}) {
 		fmt.Println("ready")
+		app.Lock()
 		app.ready = true
+		app.Unlock()
 		for len(app.roots) != 3 {
 			<-time.After(100 * time.Millisecond)
 		}
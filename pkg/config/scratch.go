@@ -1,6 +1,9 @@
 package config
 
-import "fmt"
+import (
+	"fmt"
+	"sync"
+)
 
 type variableType int
 
@@ -29,9 +32,40 @@ type Scratch struct {
 	Stack     []string
 	Redirect  bool
 
+	// The stack and variables are written by the macro, and read by the status snapshots of the network client
+	mu        sync.RWMutex
 	variables map[string]*variable
 }
 
+// ResetStack replaces the routine stack with a single routine
+func (s *Scratch) ResetStack(routine string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Stack = []string{routine}
+}
+
+func (s *Scratch) PushRoutine(routine string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Stack = append([]string{routine}, s.Stack...)
+}
+
+func (s *Scratch) PopRoutine() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Stack = s.Stack[1:]
+}
+
+// CurrentRoutine returns the innermost routine being executed, or an empty string if none is
+func (s *Scratch) CurrentRoutine() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.Stack) == 0 {
+		return ""
+	}
+	return s.Stack[0]
+}
+
 func (s *Scratch) ExecutingRoutine(routine string) bool {
 	for _, name := range s.Stack {
 		if name == routine {
@@ -50,6 +84,8 @@ func (s *Scratch) PrintStack() string {
 }
 
 func (s *Scratch) Set(name string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	switch val := value.(type) {
 	case int:
 		s.variables[name] = &variable{Type: intVariableType, value: val}
@@ -61,6 +97,8 @@ func (s *Scratch) Set(name string, value interface{}) {
 }
 
 func (s *Scratch) Get(name string) interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	val, ok := s.variables[name]
 	if !ok {
 		panic(fmt.Sprintf("unknown variable: %s", name))
@@ -68,7 +106,20 @@ func (s *Scratch) Get(name string) interface{} {
 	return val.value
 }
 
+// Lookup returns the value of a variable, if it has been set
+func (s *Scratch) Lookup(name string) (interface{}, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	val, ok := s.variables[name]
+	if !ok {
+		return nil, false
+	}
+	return val.value, true
+}
+
 func (s *Scratch) Increment(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	val, ok := s.variables[name]
 	if !ok {
 		panic(fmt.Sprintf("unknown variable: %s", name))
@@ -84,6 +135,8 @@ func (s *Scratch) Increment(name string) {
 }
 
 func (s *Scratch) Decrement(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	val, ok := s.variables[name]
 	if !ok {
 		panic(fmt.Sprintf("unknown variable: %s", name))
@@ -99,6 +152,8 @@ func (s *Scratch) Decrement(name string) {
 }
 
 func (s *Scratch) Subtract(name string, value int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	val, ok := s.variables[name]
 	if !ok {
 		panic(fmt.Sprintf("unknown variable: %s", name))
@@ -115,6 +170,8 @@ func (s *Scratch) Subtract(name string, value int) {
 }
 
 func (s *Scratch) Add(name string, value int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	val, ok := s.variables[name]
 	if !ok {
 		panic(fmt.Sprintf("unknown variable: %s", name))
@@ -130,6 +187,8 @@ func (s *Scratch) Add(name string, value int) {
 }
 
 func (s *Scratch) Reset(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	val, ok := s.variables[name]
 	if !ok {
 		panic(fmt.Sprintf("unknown variable: %s", name))
@@ -145,6 +204,8 @@ func (s *Scratch) Reset(name string) {
 }
 
 func (s *Scratch) Clear(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	delete(s.variables, name)
 }
 
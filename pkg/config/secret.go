@@ -0,0 +1,417 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+const (
+	secretKeyPath = "secret.key"
+	secretPrefix  = "enc:"
+
+	// SecretMask replaces the value of secret fields in exports and logs
+	SecretMask = "********"
+)
+
+// Secret values shorter than this are never redacted from logs to avoid masking common words
+const minRedactLength = 6
+
+type secretStore struct {
+	sync.Mutex
+	keys   map[string][]byte
+	values map[string]struct{}
+}
+
+var secrets = &secretStore{keys: make(map[string][]byte), values: make(map[string]struct{})}
+
+func isSecret(meta reflect.StructField) bool {
+	return meta.Tag.Get("secret") == "true"
+}
+
+// secretKeyFile returns the path of the key encrypting the secrets of the configuration files in the given directory
+func secretKeyFile(dir string) string {
+	return filepath.Join(dir, secretKeyPath)
+}
+
+// secretKey returns the key used to encrypt secrets in the given directory, generating it on first use
+func secretKey(dir string) ([]byte, error) {
+	path := secretKeyFile(dir)
+	secrets.Lock()
+	defer secrets.Unlock()
+	if key, ok := secrets.keys[path]; ok {
+		return key, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		key, err := generateSecretKey()
+		if err != nil {
+			return nil, err
+		}
+		if err := writeSecretKey(path, key); err != nil {
+			return nil, err
+		}
+		secrets.keys[path] = key
+		return key, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to read secret key")
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != 32 {
+		return nil, errors.New(fmt.Sprintf("secret key %s is corrupt", path))
+	}
+	secrets.keys[path] = key
+	return key, nil
+}
+
+func generateSecretKey() ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, errors.Wrap(err, "failed to generate secret key")
+	}
+	return key, nil
+}
+
+func writeSecretKey(path string, key []byte) error {
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(key)), 0600); err != nil {
+		return errors.Wrap(err, "failed to write secret key")
+	}
+	return nil
+}
+
+func encryptSecret(key []byte, value string) (string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", errors.Wrap(err, "failed to generate nonce")
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(value), nil)
+	return secretPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptSecret decrypts a stored secret. Values without the encryption prefix are stored in plain text by older
+// versions and are returned unchanged so that they are encrypted on the next save.
+func decryptSecret(key []byte, value string) (string, error) {
+	if !strings.HasPrefix(value, secretPrefix) {
+		return value, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, secretPrefix))
+	if err != nil {
+		return "", errors.Wrap(err, "malformed secret")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", errors.New("malformed secret")
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("failed to decrypt secret; the secret key may have been replaced")
+	}
+	return string(plain), nil
+}
+
+func registerSecret(value string) {
+	if len(value) < minRedactLength {
+		return
+	}
+	secrets.Lock()
+	secrets.values[value] = struct{}{}
+	secrets.Unlock()
+}
+
+// Redact replaces every known secret value in the given text with SecretMask
+func Redact(text string) string {
+	secrets.Lock()
+	defer secrets.Unlock()
+	for value := range secrets.values {
+		text = strings.ReplaceAll(text, value, SecretMask)
+	}
+	return text
+}
+
+// reactiveValue unwraps reactive objects and lists into the values they hold
+func reactiveValue(v reflect.Value) reflect.Value {
+	if !v.IsValid() || (v.Kind() == reflect.Ptr && v.IsNil()) {
+		return reflect.Value{}
+	}
+	if v.CanInterface() {
+		switch obj := v.Interface().(type) {
+		case reactiveObject:
+			return reactiveValue(reflect.ValueOf(obj.object()))
+		case interface{ list() interface{} }:
+			return reflect.ValueOf(obj.list())
+		}
+	}
+	if v.Kind() == reflect.Ptr {
+		return reactiveValue(v.Elem())
+	}
+	return v
+}
+
+// walkSecrets calls fn with every non-empty secret string field reachable from the given value
+func walkSecrets(v reflect.Value, fn func(field reflect.Value) error) error {
+	v = reactiveValue(v)
+	if !v.IsValid() {
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			meta := t.Field(i)
+			if !meta.IsExported() {
+				continue
+			}
+			field := v.Field(i)
+			if isSecret(meta) && field.Kind() == reflect.String {
+				if field.String() != "" {
+					if err := fn(field); err != nil {
+						return errors.Wrap(err, getFieldTag(meta.Tag))
+					}
+				}
+				continue
+			}
+			if err := walkSecrets(field, fn); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if err := walkSecrets(v.Index(i), fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// transformSecretNodes rewrites the encoded YAML values of the secret fields of the given value
+func transformSecretNodes(node *yaml.Node, v reflect.Value, fn func(string) (string, error)) error {
+	v = reactiveValue(v)
+	if !v.IsValid() || node == nil {
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			meta := t.Field(i)
+			name := strings.Split(meta.Tag.Get("yaml"), ",")[0]
+			if !meta.IsExported() || name == "" || name == "-" {
+				continue
+			}
+			var valueNode *yaml.Node
+			for j := 0; j+1 < len(node.Content); j += 2 {
+				if node.Content[j].Value == name {
+					valueNode = node.Content[j+1]
+				}
+			}
+			if valueNode == nil {
+				continue
+			}
+			if isSecret(meta) && valueNode.Kind == yaml.ScalarNode {
+				if valueNode.Value == "" {
+					continue
+				}
+				value, err := fn(valueNode.Value)
+				if err != nil {
+					return errors.Wrap(err, name)
+				}
+				valueNode.Value = value
+				valueNode.Tag = "!!str"
+				continue
+			}
+			if err := transformSecretNodes(valueNode, v.Field(i), fn); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return nil
+		}
+		for i := 0; i < v.Len() && i < len(node.Content); i++ {
+			if err := transformSecretNodes(node.Content[i], v.Index(i), fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// decryptSecrets decrypts the secret fields of a freshly loaded object in place
+func decryptSecrets(obj interface{}, dir string) error {
+	var key []byte
+	return walkSecrets(reflect.ValueOf(obj), func(field reflect.Value) error {
+		if !strings.HasPrefix(field.String(), secretPrefix) {
+			registerSecret(field.String())
+			return nil
+		}
+		if key == nil {
+			var err error
+			if key, err = secretKey(dir); err != nil {
+				return err
+			}
+		}
+		plain, err := decryptSecret(key, field.String())
+		if err != nil {
+			return err
+		}
+		field.SetString(plain)
+		registerSecret(plain)
+		return nil
+	})
+}
+
+// marshalSecrets marshals an object to YAML with its secret fields encrypted
+func marshalSecrets(obj interface{}, dir string) ([]byte, error) {
+	var node yaml.Node
+	if err := node.Encode(obj); err != nil {
+		return nil, err
+	}
+	var key []byte
+	err := transformSecretNodes(&node, reflect.ValueOf(obj), func(value string) (string, error) {
+		if key == nil {
+			var err error
+			if key, err = secretKey(dir); err != nil {
+				return "", err
+			}
+		}
+		return encryptSecret(key, value)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encrypt secrets")
+	}
+	return yaml.Marshal(&node)
+}
+
+// marshalJSONSecrets marshals an object to JSON with its secret fields encrypted. The secrets are encrypted in a copy
+// of the object, since the object itself must keep holding the plain values.
+func marshalJSONSecrets[T any](obj *T, dir string) ([]byte, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	var sealed T
+	if err := json.Unmarshal(data, &sealed); err != nil {
+		return nil, err
+	}
+	var key []byte
+	err = walkSecrets(reflect.ValueOf(&sealed), func(field reflect.Value) error {
+		if key == nil {
+			var err error
+			if key, err = secretKey(dir); err != nil {
+				return err
+			}
+		}
+		value, err := encryptSecret(key, field.String())
+		if err != nil {
+			return err
+		}
+		field.SetString(value)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encrypt secrets")
+	}
+	return json.Marshal(&sealed)
+}
+
+// maskSecrets converts a concrete configuration value into generic YAML values with its secret fields masked
+func maskSecrets(value interface{}) (interface{}, error) {
+	var node yaml.Node
+	if err := node.Encode(value); err != nil {
+		return nil, errors.Wrap(err, "failed to marshal value")
+	}
+	_ = transformSecretNodes(&node, reflect.ValueOf(value), func(string) (string, error) {
+		return SecretMask, nil
+	})
+	var result interface{}
+	if err := node.Decode(&result); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal value")
+	}
+	return result, nil
+}
+
+// RotateSecretKey generates a new secret key and re-encrypts the secrets of the given files with it. The files must
+// share a directory, and therefore a key. The previous key is restored if any of the files cannot be saved.
+func RotateSecretKey(files ...Savable) error {
+	var dir string
+	for i, file := range files {
+		keyed, ok := file.(interface{ Dir() string })
+		if !ok {
+			return errors.New("file does not support secrets")
+		}
+		if i > 0 && keyed.Dir() != dir {
+			return errors.New("files with different secret keys cannot be rotated together")
+		}
+		dir = keyed.Dir()
+	}
+	if len(files) == 0 {
+		return errors.New("no files to rotate")
+	}
+	path := secretKeyFile(dir)
+	oldKey, err := secretKey(dir)
+	if err != nil {
+		return err
+	}
+	newKey, err := generateSecretKey()
+	if err != nil {
+		return err
+	}
+	pending := path + ".new"
+	if err := writeSecretKey(pending, newKey); err != nil {
+		return err
+	}
+
+	restore := func() {
+		secrets.Lock()
+		secrets.keys[path] = oldKey
+		secrets.Unlock()
+		for _, file := range files {
+			_ = file.Save()
+		}
+		_ = os.Remove(pending)
+	}
+	secrets.Lock()
+	secrets.keys[path] = newKey
+	secrets.Unlock()
+	for _, file := range files {
+		if err := file.Save(); err != nil {
+			restore()
+			return errors.Wrap(err, "failed to re-encrypt secrets")
+		}
+	}
+	if err := os.Rename(pending, path); err != nil {
+		restore()
+		return errors.Wrap(err, "failed to replace secret key")
+	}
+	return nil
+}
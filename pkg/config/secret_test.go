@@ -0,0 +1,125 @@
+package config
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSecret_EncryptDecrypt(t *testing.T) {
+	key, err := generateSecretKey()
+	assert.NoError(t, err)
+	enc, err := encryptSecret(key, "https://discord.com/api/webhooks/1/abc")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(enc, secretPrefix))
+	dec, err := decryptSecret(key, enc)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://discord.com/api/webhooks/1/abc", dec)
+
+	// Plain text values written by older versions are passed through
+	dec, err = decryptSecret(key, "plain")
+	assert.NoError(t, err)
+	assert.Equal(t, "plain", dec)
+
+	other, _ := generateSecretKey()
+	_, err = decryptSecret(other, enc)
+	assert.Error(t, err)
+}
+
+func TestSecret_File(t *testing.T) {
+	cwd, _ := os.Getwd()
+	assert.NoError(t, os.Chdir(t.TempDir()))
+	defer os.Chdir(cwd)
+
+	const webhook = "https://discord.com/api/webhooks/123/secret-token"
+	assert.NoError(t, os.WriteFile("settings.yaml", []byte(`presets:
+    - name: Default
+      discord:
+        enabled: true
+        webhookUrl: `+webhook+`
+`), 0644))
+
+	// Plain text secrets are encrypted as soon as the file is loaded
+	obj, err := NewConfig(&Runtime{roots: make(map[string]Reactive)})
+	assert.NoError(t, err)
+	assert.Equal(t, webhook, *Concrete[string](obj, "presets[Default].discord.webhookUrl"))
+	data, _ := os.ReadFile("settings.yaml")
+	assert.NotContains(t, string(data), webhook)
+	assert.Contains(t, string(data), "webhookUrl: "+secretPrefix)
+	_, err = os.Stat(secretKeyPath)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "posting to "+SecretMask, Redact("posting to "+webhook))
+
+	var stdout bytes.Buffer
+	assert.Equal(t, 0, Command([]string{"get", "presets[Default].discord.webhookUrl"}, &stdout, &stdout))
+	assert.Equal(t, SecretMask+"\n", stdout.String())
+	stdout.Reset()
+	assert.Equal(t, 0, Command([]string{"-json", "get", "presets[Default].discord"}, &stdout, &stdout))
+	assert.Equal(t, `{"enabled":true,"webhookUrl":"`+SecretMask+`"}`+"\n", stdout.String())
+	stdout.Reset()
+	assert.Equal(t, 0, Command([]string{"-reveal", "get", "presets[Default].discord.webhookUrl"}, &stdout, &stdout))
+	assert.Equal(t, webhook+"\n", stdout.String())
+
+	// Rotating the key re-encrypts every secret
+	oldKey, _ := os.ReadFile(secretKeyPath)
+	assert.NoError(t, RotateSecretKey(obj.File()))
+	newKey, _ := os.ReadFile(secretKeyPath)
+	assert.NotEqual(t, oldKey, newKey)
+	rotated, _ := os.ReadFile("settings.yaml")
+	assert.NotEqual(t, data, rotated)
+	_, err = os.Stat(secretKeyPath + ".new")
+	assert.True(t, os.IsNotExist(err))
+
+	// Reload with the key read back from disk
+	dir, _ := os.Getwd()
+	path := secretKeyFile(dir)
+	secrets.Lock()
+	delete(secrets.keys, path)
+	secrets.Unlock()
+	obj, err = NewConfig(&Runtime{roots: make(map[string]Reactive)})
+	assert.NoError(t, err)
+	assert.Equal(t, webhook, *Concrete[string](obj, "presets[Default].discord.webhookUrl"))
+
+	// A secret change is masked in the history
+	assert.NoError(t, obj.SetPath("presets[Default].discord.webhookUrl", webhook+"2"))
+	assert.Equal(t, SecretMask, obj.File().Runtime().History("settings")[0].NewValue)
+}
+
+func TestSecret_JSONFile(t *testing.T) {
+	cwd, _ := os.Getwd()
+	dir := t.TempDir()
+	assert.NoError(t, os.Chdir(dir))
+	defer os.Chdir(cwd)
+
+	type tokenFile struct {
+		Token string `yaml:"token" secret:"true"`
+	}
+	assert.NoError(t, os.WriteFile("token.json", []byte("{}"), 0600))
+	file := File[tokenFile]{name: "token", path: "token.json", format: JSON, runtime: &Runtime{roots: make(map[string]Reactive)}}
+	assert.NoError(t, file.load())
+	defer file.Close()
+
+	// The key is kept next to the file rather than in the working directory
+	assert.NoError(t, os.Chdir(t.TempDir()))
+	file.obj.Token = "json-secret-token"
+	assert.NoError(t, file.Save())
+	assert.Equal(t, "json-secret-token", file.obj.Token)
+	_, err := os.Stat(filepath.Join(dir, secretKeyPath))
+	assert.NoError(t, err)
+	_, err = os.Stat(secretKeyPath)
+	assert.True(t, os.IsNotExist(err))
+
+	data, _ := os.ReadFile(filepath.Join(dir, "token.json"))
+	assert.NotContains(t, string(data), "json-secret-token")
+	assert.Contains(t, string(data), secretPrefix)
+
+	assert.NoError(t, os.Chdir(dir))
+	reloaded := File[tokenFile]{name: "token", path: "token.json", format: JSON, runtime: file.runtime}
+	assert.NoError(t, reloaded.load())
+	defer reloaded.Close()
+	assert.Equal(t, "json-secret-token", reloaded.obj.Token)
+}
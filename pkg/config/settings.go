@@ -2,6 +2,7 @@ package config
 
 import (
 	"github.com/pkg/errors"
+	"time"
 )
 
 type WindowAlignment string
@@ -24,21 +25,63 @@ const (
 
 type DiscordSettings struct {
 	Enabled    bool   `yaml:"enabled"`
-	WebhookUrl string `yaml:"webhookUrl,omitempty"`
+	WebhookUrl string `yaml:"webhookUrl,omitempty" secret:"true"`
 	PingID     int    `yaml:"pingID,omitempty"`
+	// SessionReport posts a session report to the webhook whenever the macro stops
+	SessionReport bool `yaml:"sessionReport,omitempty"`
+}
+
+type NotifierKind string
+
+const (
+	WebhookNotifierKind NotifierKind = "webhook"
+	NtfyNotifierKind    NotifierKind = "ntfy"
+	SMTPNotifierKind    NotifierKind = "smtp"
+	DesktopNotifierKind NotifierKind = "desktop"
+)
+
+type SMTPSettings struct {
+	Host     string        `yaml:"host,omitempty"`
+	Port     int           `yaml:"port" default:"587"`
+	Username string        `yaml:"username,omitempty"`
+	Password string        `yaml:"password,omitempty" secret:"true"`
+	From     string        `yaml:"from,omitempty"`
+	To       *List[string] `yaml:"to"`
+}
+
+// NotifierSettings configures a notification sink other than Discord. Levels lists the log levels which are sent to
+// the sink; every level is sent if it is empty.
+type NotifierSettings struct {
+	Name        string                `yaml:"name" key:"true"`
+	Kind        NotifierKind          `yaml:"kind" default:"webhook"`
+	Disabled    bool                  `yaml:"disabled,omitempty"`
+	Levels      *List[string]         `yaml:"levels"`
+	Url         string                `yaml:"url,omitempty" secret:"true"`
+	Token       string                `yaml:"token,omitempty" secret:"true"`
+	Screenshots bool                  `yaml:"screenshots"`
+	SMTP        *Object[SMTPSettings] `yaml:"smtp"`
+}
+
+// Rotation cycles the accounts assigned to a window configuration through its slot, either on a timer or after the
+// given routine completes
+type Rotation struct {
+	Enabled      bool          `yaml:"enabled"`
+	Interval     time.Duration `yaml:"interval" default:"1h"`
+	AfterRoutine string        `yaml:"afterRoutine,omitempty"`
 }
 
 type WindowConfig struct {
-	ID        string          `yaml:"id" key:"true" lock:"default"`
-	Alignment WindowAlignment `yaml:"alignment" default:"top-left"`
-	FullWidth bool            `yaml:"fullWidth" default:"true"`
-	Screen    int             `yaml:"screen"`
+	ID        string            `yaml:"id" key:"true" lock:"default"`
+	Alignment WindowAlignment   `yaml:"alignment" default:"top-left"`
+	FullWidth bool              `yaml:"fullWidth" default:"true"`
+	Screen    int               `yaml:"screen"`
+	Rotation  *Object[Rotation] `yaml:"rotation"`
 }
 
 type WindowSettings struct {
 	WindowConfigID         string     `yaml:"windowConfigId"`
 	WindowSize             WindowSize `yaml:"windowSize" default:"full"`
-	PrivateServerLink      string     `yaml:"privateServerLink,omitempty"`
+	PrivateServerLink      string     `yaml:"privateServerLink,omitempty" secret:"true" validate:"privateServerLink"`
 	FallbackToPublicServer bool       `yaml:"fallbackToPublicServer" default:"true"`
 }
 
@@ -62,6 +105,7 @@ type Settings struct {
 	Name         string                   `yaml:"name" key:"true"`
 	LogVerbosity int                      `yaml:"logVerbosity"`
 	Discord      *Object[DiscordSettings] `yaml:"discord"`
+	Notifiers    *List[NotifierSettings]  `yaml:"notifiers"`
 	Window       *Object[WindowSettings]  `yaml:"window"`
 	Player       *Object[PlayerSettings]  `yaml:"player"`
 	Patterns     *Object[PatternSettings] `yaml:"patterns"`
@@ -71,18 +115,62 @@ type Settings struct {
 
 type Tools struct {
 	JellyTool *Object[JellyTool] `yaml:"jellyTool"`
+	AltSync   *Object[AltSync]   `yaml:"altSync"`
 }
 
 type Networking struct {
 	AutoConnect bool `yaml:"autoConnect"`
 }
 
+// Orchestration controls how accounts are started when starting all macros at once
+type Orchestration struct {
+	Order          *List[string] `yaml:"order"`
+	StartDelay     time.Duration `yaml:"startDelay" default:"10s"`
+	LoadTimeout    time.Duration `yaml:"loadTimeout" default:"5m"`
+	RestartBackoff time.Duration `yaml:"restartBackoff" default:"30s"`
+	MaxBackoff     time.Duration `yaml:"maxBackoff" default:"10m"`
+	MaxRestarts    int           `yaml:"maxRestarts" default:"5"`
+}
+
+// LogSettings controls the rotation and retention of account log files
+type LogSettings struct {
+	MaxSize   int           `yaml:"maxSize" default:"10"` // Megabytes
+	MaxAge    time.Duration `yaml:"maxAge" default:"24h"`
+	MaxFiles  int           `yaml:"maxFiles" default:"10"`
+	Retention time.Duration `yaml:"retention" default:"168h"`
+
+	Recorder *Object[FlightRecorder] `yaml:"recorder"`
+}
+
+// FlightRecorder keeps the last frames captured from each window in memory and saves them alongside a trace of the
+// executed actions whenever an error is logged
+type FlightRecorder struct {
+	Enabled       bool          `yaml:"enabled" default:"true"`
+	Duration      time.Duration `yaml:"duration" default:"30s"`
+	FrameRate     int           `yaml:"frameRate" default:"4"`
+	MaxWidth      int           `yaml:"maxWidth" default:"480"`
+	MaxMemory     int           `yaml:"maxMemory" default:"32"` // Megabytes
+	Format        string        `yaml:"format" default:"gif"`   // gif or png
+	MaxRecordings int           `yaml:"maxRecordings" default:"20"`
+}
+
+// APISettings configures the local control API. The token is generated when the API is first started.
+type APISettings struct {
+	Enabled bool   `yaml:"enabled"`
+	Address string `yaml:"address" default:"127.0.0.1"`
+	Port    int    `yaml:"port" default:"7842"`
+	Token   string `yaml:"token,omitempty" secret:"true"`
+}
+
 type Config struct {
-	Presets    *List[Settings]     `yaml:"presets"`
-	Windows    *List[WindowConfig] `yaml:"windows"`
-	Tools      *Object[Tools]      `yaml:"tools"`
-	Networking *Object[Networking] `yaml:"networking"`
-	DevMode    bool                `yaml:"devMode"`
+	Presets       *List[Settings]        `yaml:"presets"`
+	Windows       *List[WindowConfig]    `yaml:"windows"`
+	Tools         *Object[Tools]         `yaml:"tools"`
+	Networking    *Object[Networking]    `yaml:"networking"`
+	Orchestration *Object[Orchestration] `yaml:"orchestration"`
+	Logging       *Object[LogSettings]   `yaml:"logging"`
+	API           *Object[APISettings]   `yaml:"api"`
+	DevMode       bool                   `yaml:"devMode"`
 }
 
 func NewConfig(runtime *Runtime) (*Object[Config], error) {
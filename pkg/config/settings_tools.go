@@ -1,5 +1,7 @@
 package config
 
+import "time"
+
 type JellyTool struct {
 	Enabled         bool          `yaml:"enabled"`
 	BeeTypes        *List[string] `yaml:"beeTypes"`
@@ -10,8 +12,10 @@ type JellyTool struct {
 	StopMythic      bool          `yaml:"stopMythic"`
 }
 
+// AltSync elects a relay among the accounts discovered on the network when none is running
 type AltSync struct {
-	AutoStartRelay bool `yaml:"autoStartRelay"`
+	AutoStartRelay  bool          `yaml:"autoStartRelay"`
+	ElectionTimeout time.Duration `yaml:"electionTimeout" default:"15s"`
 }
 
 type VicHop struct {
@@ -2,6 +2,7 @@ package config
 
 import (
 	"github.com/pkg/errors"
+	"time"
 )
 
 type UnwindLoop struct {
@@ -15,21 +16,53 @@ type LoopState struct {
 }
 
 type NetworkIdentity struct {
-	Address  string `yaml:"address" key:"true"`
-	Identity string `yaml:"identity"`
-	Role     string `yaml:"role,omitempty"`
+	Address     string `yaml:"address" key:"true"`
+	Identity    string `yaml:"identity"`
+	Role        string `yaml:"role,omitempty"`
+	Fingerprint string `yaml:"fingerprint,omitempty"`
+
+	// Presence of identities connected to a relay, as measured by the relay's heartbeats
+	LastSeen time.Time     `state:"lastSeen" yaml:"-"`
+	Latency  time.Duration `state:"latency" yaml:"-"`
+	Stale    bool          `state:"stale" yaml:"-"`
+}
+
+// FleetStatus is the last status snapshot published by another account on the network
+type FleetStatus struct {
+	Identity string    `yaml:"identity" key:"true"`
+	Account  string    `yaml:"account"`
+	Status   string    `yaml:"status"`
+	Running  bool      `yaml:"running"`
+	Paused   bool      `yaml:"paused"`
+	Role     string    `yaml:"role,omitempty"`
+	Routine  string    `yaml:"routine,omitempty"`
+	Field    string    `yaml:"field,omitempty"`
+	Server   string    `yaml:"server,omitempty"`
+	Updated  time.Time `yaml:"updated"`
 }
 
 type MacroNetworkingConfig struct {
 	SavedRelays *List[NetworkIdentity] `yaml:"savedRelays"`
+	NetworkKey  string                 `yaml:"networkKey,omitempty" secret:"true"`
+
+	Certificate    string `yaml:"certificate,omitempty"`
+	CertificateKey string `yaml:"certificateKey,omitempty" secret:"true"`
+
+	// The relay on another network which this account's relay bridges its network to
+	Upstream            string `yaml:"upstream,omitempty"`
+	UpstreamFingerprint string `yaml:"upstreamFingerprint,omitempty"`
+	UpstreamKey         string `yaml:"upstreamKey,omitempty" secret:"true"`
+	UpstreamConnected   bool   `state:"upstreamConnected" yaml:"-"`
 
 	AvailableRelays     *List[NetworkIdentity] `state:"availableRelays" yaml:"-"`
 	ConnectedIdentities *List[NetworkIdentity] `state:"connectedIdentities" yaml:"-"`
+	Fleet               *List[FleetStatus]     `state:"fleet" yaml:"-"`
 	ConnectingAddress   string                 `state:"connectingAddress" yaml:"-"`
 	ConnectedAddress    string                 `state:"connectedAddress" yaml:"-"`
 	RelayStarting       bool                   `state:"relayStarting" yaml:"-"`
 	RelayActive         bool                   `state:"relayActive" yaml:"-"`
 	RoleRegisterError   string                 `state:"roleRegisterError" yaml:"-"`
+	PairingCode         string                 `state:"pairingCode" yaml:"-"`
 
 	Identity string `state:"identity" yaml:"-"`
 }
@@ -41,15 +74,26 @@ type MacroCounters struct {
 	ClaimedHive int `state:"claimedHive" default:"-1" yaml:"-"`
 }
 
+// MacroHealth is maintained by the orchestrator for accounts started through it
+type MacroHealth struct {
+	Status      string    `state:"status" default:"idle" yaml:"-"`
+	Restarts    int       `state:"restarts" yaml:"-"`
+	LastError   string    `state:"lastError" yaml:"-"`
+	LoadedAt    time.Time `state:"loadedAt" yaml:"-"`
+	NextRestart time.Time `state:"nextRestart" yaml:"-"`
+}
+
 type MacroState struct {
 	AccountName string `yaml:"accountName" key:"true"`
 
 	Running bool `state:"running" yaml:"-"`
 	Paused  bool `state:"paused" yaml:"-"`
 
-	Status string `state:"status" default:"Ready" yaml:"-"`
+	Status      string `state:"status" default:"Ready" yaml:"-"`
+	LastRoutine string `state:"lastRoutine" yaml:"-"`
 
 	Counters *Object[MacroCounters] `state:"counters" default:"true" yaml:"-"`
+	Health   *Object[MacroHealth]   `state:"health" default:"true" yaml:"-"`
 
 	HoneyOriginX int `state:"honeyOriginX" yaml:"-"`
 	HoneyOriginY int `state:"honeyOriginY" yaml:"-"`
@@ -78,9 +122,16 @@ type VicHopVersion struct {
 	UpToDate           bool   `state:"upToDate"`
 }
 
+// RotationState records the account currently occupying a rotating window slot
+type RotationState struct {
+	WindowConfigID string `yaml:"windowConfigId" key:"true"`
+	Account        string `yaml:"account"`
+}
+
 type State struct {
-	Config *Object[StateConfig] `yaml:"config"`
-	Macros *List[MacroState]    `yaml:"macros"`
+	Config    *Object[StateConfig] `yaml:"config"`
+	Macros    *List[MacroState]    `yaml:"macros"`
+	Rotations *List[RotationState] `yaml:"rotations"`
 
 	VicHop *Object[VicHopVersion] `state:"vicHop" yaml:"-"`
 }
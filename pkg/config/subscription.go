@@ -0,0 +1,206 @@
+package config
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Change describes a single set, append or delete operation delivered to a subscription
+type Change struct {
+	Op    ListenOp
+	Path  string
+	Value interface{}
+
+	// Wildcards holds the path segments matched by each * in the subscription pattern, in order
+	Wildcards []string
+}
+
+// Subscription is a handle to a callback registered with Subscribe or SubscribeBatch. Subscriptions remain active
+// until they are closed.
+type Subscription struct {
+	runtime  *Runtime
+	id       int
+	pattern  chain
+	callback func(Change)
+
+	// Batched subscriptions only
+	mu      sync.Mutex
+	window  time.Duration
+	batch   func([]Change)
+	pending []Change
+	timer   *time.Timer
+	closed  bool
+}
+
+// Close unregisters the subscription. Batched changes which have not yet been delivered are discarded.
+func (s *Subscription) Close() {
+	s.runtime.subMu.Lock()
+	delete(s.runtime.subscriptions, s.id)
+	s.runtime.subMu.Unlock()
+
+	s.mu.Lock()
+	s.closed = true
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.pending = nil
+	s.mu.Unlock()
+}
+
+func (s *Subscription) deliver(change Change) {
+	if s.batch == nil {
+		s.callback(change)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.pending = append(s.pending, change)
+	if s.timer == nil {
+		s.timer = time.AfterFunc(s.window, s.flush)
+	}
+}
+
+func (s *Subscription) flush() {
+	s.mu.Lock()
+	changes := s.pending
+	s.pending = nil
+	s.timer = nil
+	closed := s.closed
+	s.mu.Unlock()
+	if !closed && len(changes) > 0 {
+		s.batch(changes)
+	}
+}
+
+var patternRegex = regexp.MustCompile(`(\w+|\*\*|\*)|\[((?:\[[^\]]*\]|[^\]])*)\]`)
+
+// compilePattern compiles a subscription pattern. A * matches any single path segment, and a ** matches any number of
+// trailing segments.
+func compilePattern(pattern string) (chain, error) {
+	var chains chain
+	matches := patternRegex.FindAllStringSubmatch(pattern, -1)
+	if len(matches) == 0 {
+		return nil, errors.New(fmt.Sprintf("invalid pattern \"%s\"", pattern))
+	}
+	for i, match := range matches {
+		if match[1] == "**" && i != len(matches)-1 {
+			return nil, errors.New(fmt.Sprintf("invalid pattern \"%s\": ** must be the last segment", pattern))
+		}
+		if match[1] != "" {
+			chains = append(chains, link{match[1], false})
+		}
+		if match[2] != "" {
+			chains = append(chains, link{match[2], true})
+		}
+	}
+	return chains, nil
+}
+
+// match matches a path against a pattern and returns the segments matched by wildcards
+func (c chain) match(path chain) ([]string, bool) {
+	var wildcards []string
+	for i, segment := range c {
+		if segment.val == "**" && !segment.brackets {
+			for _, rest := range path[i:] {
+				wildcards = append(wildcards, rest.val)
+			}
+			return wildcards, true
+		}
+		if i >= len(path) {
+			return nil, false
+		}
+		if segment.val == "*" {
+			wildcards = append(wildcards, path[i].val)
+			continue
+		}
+		if segment.val != path[i].val || segment.brackets != path[i].brackets {
+			return nil, false
+		}
+	}
+	return wildcards, len(c) == len(path)
+}
+
+func (r *Runtime) subscribe(pattern string, sub *Subscription) (*Subscription, error) {
+	compiled, err := compilePattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+	sub.runtime = r
+	sub.pattern = compiled
+	r.subMu.Lock()
+	defer r.subMu.Unlock()
+	if r.subscriptions == nil {
+		r.subscriptions = make(map[int]*Subscription)
+	}
+	r.subCounter++
+	sub.id = r.subCounter
+	r.subscriptions[sub.id] = sub
+	return sub, nil
+}
+
+// Subscribe registers a callback which is invoked synchronously for every change matching the pattern. Patterns are
+// rooted at the file name, e.g. settings.presets[*].vicHop.*. Appends and deletes of list elements are also delivered
+// to subscriptions on the list itself.
+func (r *Runtime) Subscribe(pattern string, callback func(Change)) (*Subscription, error) {
+	return r.subscribe(pattern, &Subscription{callback: callback})
+}
+
+// SubscribeBatch registers a callback which receives the changes matching the pattern in batches. A batch is delivered
+// once the given window has elapsed since the first change it contains.
+func (r *Runtime) SubscribeBatch(pattern string, window time.Duration, callback func([]Change)) (*Subscription, error) {
+	return r.subscribe(pattern, &Subscription{window: window, batch: callback})
+}
+
+// notify delivers a change at the given full path to all matching subscriptions
+func (r *Runtime) notify(op ListenOp, path string, value interface{}) {
+	if r == nil {
+		return
+	}
+	r.subMu.RLock()
+	if len(r.subscriptions) == 0 {
+		r.subMu.RUnlock()
+		return
+	}
+	var subs []*Subscription
+	for _, sub := range r.subscriptions {
+		subs = append(subs, sub)
+	}
+	r.subMu.RUnlock()
+
+	compiled, err := compilePath(path)
+	if err != nil {
+		return
+	}
+	for _, sub := range subs {
+		wildcards, ok := sub.pattern.match(compiled)
+		if !ok && op != Set && compiled[len(compiled)-1].brackets {
+			wildcards, ok = sub.pattern.match(compiled.TrimRight())
+		}
+		if ok {
+			sub.deliver(Change{Op: op, Path: path, Value: value, Wildcards: wildcards})
+		}
+	}
+}
+
+func (c *Object[T]) subscriptionPattern(pattern string) string {
+	if len(pattern) > 0 && pattern[0] == '[' {
+		return c.path + pattern
+	}
+	return fmt.Sprintf("%s.%s", c.path, pattern)
+}
+
+// Subscribe registers a callback for changes matching a pattern relative to the object
+func (c *Object[T]) Subscribe(pattern string, callback func(Change)) (*Subscription, error) {
+	return c.file.Runtime().Subscribe(c.subscriptionPattern(pattern), callback)
+}
+
+// SubscribeBatch registers a batched callback for changes matching a pattern relative to the object
+func (c *Object[T]) SubscribeBatch(pattern string, window time.Duration, callback func([]Change)) (*Subscription, error) {
+	return c.file.Runtime().SubscribeBatch(c.subscriptionPattern(pattern), window, callback)
+}
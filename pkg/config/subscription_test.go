@@ -0,0 +1,168 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSubscription_Wildcards(t *testing.T) {
+	type vicHop struct {
+		Enabled bool   `yaml:"enabled"`
+		Role    string `yaml:"role"`
+	}
+	type preset struct {
+		Name   string          `yaml:"name" key:"true"`
+		Speed  int             `yaml:"speed"`
+		VicHop *Object[vicHop] `yaml:"vicHop"`
+	}
+	type object struct {
+		Presets *List[preset] `yaml:"presets"`
+	}
+
+	obj, runtime := newHistoryRoot[object]("settings")
+	assert.NoError(t, obj.AppendPath("presets[Default]"))
+
+	var vicHopChanges, presetChanges, allChanges []Change
+	sub, err := runtime.Subscribe("settings.presets[*].vicHop.*", func(change Change) {
+		vicHopChanges = append(vicHopChanges, change)
+	})
+	assert.NoError(t, err)
+	_, err = obj.Subscribe("presets", func(change Change) {
+		presetChanges = append(presetChanges, change)
+	})
+	assert.NoError(t, err)
+	_, err = obj.Subscribe("**", func(change Change) {
+		allChanges = append(allChanges, change)
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, obj.SetPath("presets[Default].vicHop.role", "main"))
+	assert.NoError(t, obj.SetPath("presets[Default].speed", 30))
+	assert.NoError(t, obj.AppendPath("presets[Alt]"))
+	assert.NoError(t, obj.SetPath("presets[Alt].vicHop.enabled", true))
+	assert.NoError(t, obj.DeletePath("presets[Alt]"))
+
+	assert.Len(t, vicHopChanges, 2)
+	assert.Equal(t, Change{Op: Set, Path: "settings.presets[Default].vicHop.role", Value: "main",
+		Wildcards: []string{"Default", "role"}}, vicHopChanges[0])
+	assert.Equal(t, []string{"Alt", "enabled"}, vicHopChanges[1].Wildcards)
+	assert.Len(t, presetChanges, 2)
+	assert.Equal(t, Append, presetChanges[0].Op)
+	assert.Equal(t, "settings.presets[Alt]", presetChanges[0].Path)
+	assert.Equal(t, Delete, presetChanges[1].Op)
+	assert.Len(t, allChanges, 5)
+
+	// Closed subscriptions no longer receive changes
+	sub.Close()
+	assert.NoError(t, obj.SetPath("presets[Default].vicHop.role", "searcher"))
+	assert.Len(t, vicHopChanges, 2)
+	assert.Len(t, allChanges, 6)
+
+	_, err = runtime.Subscribe("settings.**.role", func(Change) {})
+	assert.Error(t, err)
+}
+
+func TestSubscription_Concurrent(t *testing.T) {
+	type preset struct {
+		Name  string `yaml:"name" key:"true"`
+		Speed int    `yaml:"speed"`
+	}
+	type object struct {
+		Speed   int            `yaml:"speed"`
+		Counts  map[string]int `yaml:"counts"`
+		Floats  *List[float64] `yaml:"floats"`
+		Presets *List[preset]  `yaml:"presets"`
+	}
+
+	obj, runtime := newHistoryRoot[object]("settings")
+	assert.NoError(t, obj.AppendPath("presets[Default]"))
+	var received atomic.Int64
+	_, err := runtime.Subscribe("settings.presets[*].speed", func(Change) { received.Add(1) })
+	assert.NoError(t, err)
+
+	// The macro, the UI and the control API read, write and subscribe to the same values at once
+	var wg sync.WaitGroup
+	run := func(step func(i int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				step(i)
+			}
+		}()
+	}
+	run(func(i int) {
+		assert.NoError(t, obj.SetPath("speed", i))
+		assert.NoError(t, obj.SetPathf(i, "counts[c%d]", i%10))
+		assert.NoError(t, obj.SetPath("presets[Default].speed", i))
+	})
+	run(func(i int) {
+		_ = obj.AppendPathf("presets[Alt%d]", i%5)
+		_ = obj.DeletePathf("presets[Alt%d]", (i+2)%5)
+		assert.NoError(t, obj.Append(mustCompilePath("floats"), 0, ""))
+		_ = obj.DeletePathf("counts[c%d]", (i+5)%10)
+	})
+	run(func(i int) {
+		_, _ = obj.GetPath("speed")
+		_, _ = obj.GetPathf("counts[c%d]", i%10)
+		_, _ = obj.GetPath("presets[Default].speed")
+		_ = obj.LengthPath("presets")
+		_ = obj.LengthPath("floats")
+		obj.Object().Presets.ForEach(func(p *preset) { _ = p.Speed })
+	})
+	run(func(i int) {
+		sub, err := obj.Subscribe("presets[*].speed", func(Change) {})
+		assert.NoError(t, err)
+		batch, err := runtime.SubscribeBatch("settings.**", time.Millisecond, func([]Change) {})
+		assert.NoError(t, err)
+		sub.Close()
+		batch.Close()
+	})
+	wg.Wait()
+
+	assert.Positive(t, received.Load())
+	val, err := obj.GetPath("speed")
+	assert.NoError(t, err)
+	assert.Equal(t, 199, val)
+	assert.Equal(t, 200, obj.LengthPath("floats"))
+}
+
+func TestSubscription_Batch(t *testing.T) {
+	type object struct {
+		Count int            `yaml:"count"`
+		Names map[string]int `yaml:"names"`
+	}
+
+	obj, _ := newHistoryRoot[object]("state")
+	var mu sync.Mutex
+	var batches [][]Change
+	sub, err := obj.SubscribeBatch("*", 20*time.Millisecond, func(changes []Change) {
+		mu.Lock()
+		batches = append(batches, changes)
+		mu.Unlock()
+	})
+	assert.NoError(t, err)
+	for i := 1; i <= 5; i++ {
+		assert.NoError(t, obj.SetPath("count", i))
+	}
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(batches) == 1
+	}, time.Second, 5*time.Millisecond)
+	mu.Lock()
+	assert.Len(t, batches[0], 5)
+	assert.Equal(t, 5, batches[0][4].Value)
+	mu.Unlock()
+
+	// Setting a map entry does not match a pattern on the map itself
+	assert.NoError(t, obj.SetPath("names[a]", 1))
+	sub.Close()
+	time.Sleep(40 * time.Millisecond)
+	mu.Lock()
+	assert.Len(t, batches, 1)
+	mu.Unlock()
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"reflect"
+	"sync"
+)
+
+// Validator checks a value before it is assigned to a field tagged with `validate:"<name>"`
+type Validator func(value interface{}) error
+
+var validators = struct {
+	sync.RWMutex
+	fns map[string]Validator
+}{fns: make(map[string]Validator)}
+
+// RegisterValidator registers a named validator. Packages which cannot be imported by config (e.g. window) use this to
+// validate the fields whose format they own.
+func RegisterValidator(name string, validator Validator) {
+	validators.Lock()
+	validators.fns[name] = validator
+	validators.Unlock()
+}
+
+// validateField runs the validator registered for a field, if any
+func validateField(meta reflect.StructField, value interface{}) error {
+	return validate(meta.Tag.Get("validate"), value)
+}
+
+// validate runs the named validator, if it has been registered
+func validate(name string, value interface{}) error {
+	if name == "" {
+		return nil
+	}
+	validators.RLock()
+	validator, ok := validators.fns[name]
+	validators.RUnlock()
+	if !ok {
+		return nil
+	}
+	return validator(value)
+}
@@ -0,0 +1,129 @@
+package config
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"reflect"
+	"sort"
+	"time"
+)
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	timeType     = reflect.TypeOf(time.Time{})
+)
+
+// isPrimitive reports whether values of the given type are stored directly rather than wrapped in an Object
+func isPrimitive(t reflect.Type) bool {
+	return t.Kind() != reflect.Struct || t == timeType
+}
+
+// convertValue converts a value received from the UI, the command line or Go code into the given field type.
+// JSON numbers arrive as float64 and durations and timestamps arrive as strings.
+func convertValue(t reflect.Type, value interface{}) (reflect.Value, error) {
+	if value == nil {
+		return reflect.Zero(t), nil
+	}
+	rv := reflect.ValueOf(value)
+	if rv.Type() == t {
+		return rv, nil
+	}
+	// The frontend sends an empty string in place of undefined values
+	if str, ok := value.(string); ok && str == "" && t.Kind() != reflect.String {
+		return reflect.Zero(t), nil
+	}
+	invalid := errors.New(fmt.Sprintf("cannot use %v (%T) as %s", value, value, t))
+
+	switch t {
+	case durationType:
+		if str, ok := value.(string); ok {
+			d, err := time.ParseDuration(str)
+			if err != nil {
+				return reflect.Value{}, errors.New(fmt.Sprintf("invalid duration \"%s\"", str))
+			}
+			return reflect.ValueOf(d), nil
+		}
+	case timeType:
+		if str, ok := value.(string); ok {
+			tm, err := time.Parse(time.RFC3339, str)
+			if err != nil {
+				return reflect.Value{}, errors.New(fmt.Sprintf("invalid timestamp \"%s\"", str))
+			}
+			return reflect.ValueOf(tm), nil
+		}
+		return reflect.Value{}, invalid
+	}
+
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return reflect.ValueOf(rv.Int()).Convert(t), nil
+		case reflect.Float32, reflect.Float64:
+			return reflect.ValueOf(int64(rv.Float())).Convert(t), nil
+		}
+	case reflect.Float32, reflect.Float64:
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return reflect.ValueOf(float64(rv.Int())).Convert(t), nil
+		case reflect.Float32, reflect.Float64:
+			return reflect.ValueOf(rv.Float()).Convert(t), nil
+		}
+	case reflect.Bool:
+		if rv.Kind() == reflect.Bool {
+			return rv.Convert(t), nil
+		}
+	case reflect.String:
+		if rv.Kind() == reflect.String {
+			return rv.Convert(t), nil
+		}
+	case reflect.Map:
+		if rv.Kind() != reflect.Map || rv.Type().Key().Kind() != reflect.String {
+			break
+		}
+		result := reflect.MakeMapWithSize(t, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			elem, err := convertValue(t.Elem(), iter.Value().Interface())
+			if err != nil {
+				return reflect.Value{}, errors.Wrap(err, iter.Key().String())
+			}
+			result.SetMapIndex(iter.Key().Convert(t.Key()), elem)
+		}
+		return result, nil
+	}
+	return reflect.Value{}, invalid
+}
+
+// uiValue converts a field value into a representation which can be emitted to the frontend
+func uiValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case time.Duration:
+		return v.String()
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.Format(time.RFC3339)
+	}
+	return value
+}
+
+// copyMap returns a shallow copy of a map value so that it can be retained after the original is mutated
+func copyMap(m reflect.Value) interface{} {
+	result := reflect.MakeMapWithSize(m.Type(), m.Len())
+	iter := m.MapRange()
+	for iter.Next() {
+		result.SetMapIndex(iter.Key(), iter.Value())
+	}
+	return result.Interface()
+}
+
+func sortedKeys(m reflect.Value) []string {
+	var keys []string
+	for _, key := range m.MapKeys() {
+		keys = append(keys, key.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -58,6 +58,15 @@ func (a *LogAction) Execute(macro *common.Macro) error {
 	if a.status {
 		macro.Status(msg)
 	}
+	if a.level == logging.Error && macro.History != nil {
+		macro.History.Error(msg)
+	}
+	if macro.Recorder != nil {
+		macro.Recorder.Trace(macro.Scratch.Stack, fmt.Sprintf("%s: %s", a.level, msg))
+		if a.level == logging.Error {
+			go dumpRecording(macro, msg)
+		}
+	}
 	if err := macro.Logger.Log(a.verbosity, a.level, msg); err != nil {
 		return errors.Wrap(err, "failed to log")
 	}
@@ -121,3 +130,12 @@ func Warning(log string, args ...interface{}) func(...LogModifier) *LogAction {
 		return (&LogAction{level: logging.Warning, log: log, args: args}).applyLogModifiers(mods)
 	}
 }
+
+func dumpRecording(macro *common.Macro, reason string) {
+	path, err := macro.Recorder.Dump(reason)
+	if err != nil {
+		_ = macro.Logger.Log(0, logging.Warning, errors.Wrap(err, "Failed to save flight recording").Error())
+	} else if path != "" {
+		_ = macro.Logger.Log(1, logging.Info, fmt.Sprintf("Saved flight recording to %s", path))
+	}
+}
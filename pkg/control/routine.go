@@ -26,6 +26,9 @@ func (r *Routine) Copy(routine *Routine) {
 func (r *Routine) Execute() {
 	for {
 		for i := 0; i < len(r.actions); i++ {
+			if r.macro.Recorder != nil {
+				r.macro.Recorder.Trace(r.macro.Scratch.Stack, fmt.Sprintf("%T", r.actions[i]))
+			}
 			if err := r.actions[i].Execute(r.macro); err != nil {
 				if redirect, ok := err.(*common.RedirectExecution); ok {
 					if r.parent != nil {
@@ -112,7 +115,7 @@ func ExecuteRoutine(
 		err:     err,
 		kind:    MainRoutineKind,
 	}
-	macro.Scratch.Stack = []string{"Main"}
+	macro.Scratch.ResetStack("Main")
 	var execSub func(routine *Routine, macro *common.Macro) common.SubroutineExecutor
 	var exec func(routine *Routine, macro *common.Macro) common.RoutineExecutor
 	execSub = func(routine *Routine, macro *common.Macro) common.SubroutineExecutor {
@@ -136,7 +139,7 @@ func ExecuteRoutine(
 			if !ok {
 				panic(fmt.Sprintf("unknown subroutine %s", string(kind)))
 			}
-			macro.Scratch.Stack = append([]string{string(kind)}, macro.Scratch.Stack...)
+			macro.Scratch.PushRoutine(string(kind))
 			macro.Scratch.Redirect = false
 			subMacro := macro.Copy()
 			subMacro.Logger = subMacro.Logger.Child(string(kind))
@@ -148,8 +151,19 @@ func ExecuteRoutine(
 			}
 			subRoutine := &Routine{macro: subMacro, actions: subActions, depth: routine.depth + 1, parent: routine, kind: kind}
 			subRoutine.Copy(routine)
+			if macro.History != nil {
+				macro.History.EnterRoutine(string(kind))
+			}
 			subRoutine.Execute()
-			macro.Scratch.Stack = macro.Scratch.Stack[1:]
+			macro.Scratch.PopRoutine()
+			// Routines which were neither redirected nor stopped have completed
+			completed := !macro.Scratch.Redirect && len(macro.Stop) == 0
+			if completed && macro.MacroState != nil {
+				_ = macro.MacroState.SetPath("lastRoutine", string(kind))
+			}
+			if macro.History != nil {
+				macro.History.ExitRoutine(string(kind), completed)
+			}
 		}
 	}
 	routine.macro.Routine = exec(routine, routine.macro)
@@ -4,9 +4,11 @@ import (
 	"context"
 	"github.com/fatih/color"
 	"github.com/wailsapp/wails/v2/pkg/runtime"
+	"time"
 )
 
 func Console(ctx context.Context, level LogLevel, text string) {
+	publish(Entry{Time: time.Now(), Level: level, Message: text, Console: true})
 	switch level {
 	case Info:
 		runtime.EventsEmit(ctx, "console", text)
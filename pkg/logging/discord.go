@@ -1,22 +1,366 @@
 package logging
 
 import (
+	"bytes"
+	"container/list"
+	"encoding/json"
+	"fmt"
 	"github.com/nosyliam/revolution/pkg/config"
+	"github.com/pkg/errors"
 	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"sync"
+	"time"
 )
 
-var LogColors = map[LogLevel]int{}
+var LogColors = map[LogLevel]int{
+	Info:    0x3498db,
+	Warning: 0xf1c40f,
+	Success: 0x2ecc71,
+	Error:   0xe74c3c,
+}
 
-func LogDiscord(settings *config.Settings, level LogLevel, message string) error {
-	if settings.Discord == nil {
+const (
+	discordQueueSize   = 64
+	discordMaxAttempts = 5
+	screenshotName     = "screenshot.png"
+	// The number of sent messages whose Discord IDs are kept so that they can be edited
+	editableMessages = 256
+)
+
+var discord = NewDiscordClient(&http.Client{Timeout: 30 * time.Second})
+
+type discordEmbedImage struct {
+	Url string `json:"url"`
+}
+
+type discordEmbed struct {
+	Description string             `json:"description"`
+	Color       int                `json:"color"`
+	Timestamp   string             `json:"timestamp"`
+	Image       *discordEmbedImage `json:"image,omitempty"`
+}
+
+type discordAllowedMentions struct {
+	Parse []string `json:"parse"`
+	Users []string `json:"users,omitempty"`
+}
+
+type discordAttachment struct {
+	ID       int    `json:"id"`
+	Filename string `json:"filename"`
+}
+
+type discordPayload struct {
+	Content         string                  `json:"content,omitempty"`
+	Embeds          []discordEmbed          `json:"embeds"`
+	AllowedMentions *discordAllowedMentions `json:"allowed_mentions,omitempty"`
+	Attachments     []discordAttachment     `json:"attachments,omitempty"`
+}
+
+type discordRequest struct {
+	id         int
+	edit       int
+	payload    discordPayload
+	screenshot []byte
+}
+
+type webhookQueue struct {
+	url      string
+	requests chan *discordRequest
+	pending  sync.WaitGroup
+	resetAt  time.Time
+}
+
+// DiscordClient sends log messages to Discord webhooks. Messages are queued and sent in order by one worker per
+// webhook, which waits out rate limits. Each message is assigned a local ID immediately so that it can be edited
+// before it has been sent. Only the most recently used messages can be edited; editing an older message sends it again.
+type DiscordClient struct {
+	mu       sync.Mutex
+	client   *http.Client
+	queues   map[string]*webhookQueue
+	messages map[int]*list.Element
+	recent   *list.List
+	limit    int
+	nextID   int
+}
+
+type sentMessage struct {
+	id        int
+	messageID string
+}
+
+func NewDiscordClient(client *http.Client) *DiscordClient {
+	return &DiscordClient{
+		client:   client,
+		queues:   make(map[string]*webhookQueue),
+		messages: make(map[int]*list.Element),
+		recent:   list.New(),
+		limit:    editableMessages,
+	}
+}
+
+// remember records the Discord ID of a sent message, forgetting the least recently used message if the limit is reached
+func (d *DiscordClient) remember(id int, messageID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if elem, ok := d.messages[id]; ok {
+		elem.Value.(*sentMessage).messageID = messageID
+		d.recent.MoveToFront(elem)
+		return
+	}
+	d.messages[id] = d.recent.PushFront(&sentMessage{id: id, messageID: messageID})
+	for d.recent.Len() > d.limit {
+		oldest := d.recent.Remove(d.recent.Back()).(*sentMessage)
+		delete(d.messages, oldest.id)
+	}
+}
+
+func (d *DiscordClient) lookup(id int) (string, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	elem, ok := d.messages[id]
+	if !ok {
+		return "", false
+	}
+	d.recent.MoveToFront(elem)
+	return elem.Value.(*sentMessage).messageID, true
+}
+
+// Send queues a message with an optional screenshot and returns its local ID. If edit is non-zero, the message with
+// that local ID is edited instead and its ID is returned.
+func (d *DiscordClient) Send(webhook string, level LogLevel, message string, pingID int, edit int, screenshot *image.RGBA) (int, error) {
+	req := &discordRequest{
+		edit: edit,
+		payload: discordPayload{
+			Embeds: []discordEmbed{{
+				Description: message,
+				Color:       LogColors[level],
+				Timestamp:   time.Now().UTC().Format(time.RFC3339),
+			}},
+			AllowedMentions: &discordAllowedMentions{Parse: []string{}},
+		},
+	}
+	if level == Error && pingID != 0 {
+		req.payload.Content = fmt.Sprintf("<@%d>", pingID)
+		req.payload.AllowedMentions.Users = []string{strconv.Itoa(pingID)}
+	}
+	if screenshot != nil {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, screenshot); err != nil {
+			return 0, errors.Wrap(err, "failed to encode screenshot")
+		}
+		req.screenshot = buf.Bytes()
+		req.payload.Embeds[0].Image = &discordEmbedImage{Url: "attachment://" + screenshotName}
+		req.payload.Attachments = []discordAttachment{{ID: 0, Filename: screenshotName}}
+	}
+
+	d.mu.Lock()
+	queue, ok := d.queues[webhook]
+	if !ok {
+		queue = &webhookQueue{url: webhook, requests: make(chan *discordRequest, discordQueueSize)}
+		d.queues[webhook] = queue
+		go d.work(queue)
+	}
+	if edit != 0 {
+		req.id = edit
+	} else {
+		d.nextID++
+		req.id = d.nextID
+	}
+	queue.pending.Add(1)
+	d.mu.Unlock()
+
+	select {
+	case queue.requests <- req:
+		return req.id, nil
+	default:
+		queue.pending.Done()
+		return 0, errors.New("discord queue is full")
+	}
+}
+
+// Flush waits until every queued message has been sent or the timeout has elapsed
+func (d *DiscordClient) Flush(timeout time.Duration) bool {
+	d.mu.Lock()
+	var queues []*webhookQueue
+	for _, queue := range d.queues {
+		queues = append(queues, queue)
+	}
+	d.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		for _, queue := range queues {
+			queue.pending.Wait()
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func (d *DiscordClient) work(queue *webhookQueue) {
+	for req := range queue.requests {
+		if err := d.process(queue, req); err != nil {
+			fmt.Printf("[%s] %s: failed to send discord message: %s\n", time.Now().Format("15:04:05"), Error, config.Redact(err.Error()))
+		}
+		queue.pending.Done()
+	}
+}
+
+func (d *DiscordClient) process(queue *webhookQueue, req *discordRequest) error {
+	method, url := http.MethodPost, queue.url+"?wait=true"
+	if req.edit != 0 {
+		messageID, ok := d.lookup(req.edit)
+		// Messages which failed to send are sent again rather than edited
+		if ok {
+			method, url = http.MethodPatch, fmt.Sprintf("%s/messages/%s", queue.url, messageID)
+		}
+	}
+	var lastErr error
+	for attempt := 1; attempt <= discordMaxAttempts; attempt++ {
+		if wait := time.Until(queue.resetAt); wait > 0 {
+			time.Sleep(wait)
+		}
+		retry, err := d.do(queue, req, method, url)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if retry < 0 {
+			return err
+		}
+		time.Sleep(retry)
+	}
+	return errors.Wrap(lastErr, fmt.Sprintf("giving up after %d attempts", discordMaxAttempts))
+}
+
+// do performs a single request. A negative retry delay is returned for errors which should not be retried.
+func (d *DiscordClient) do(queue *webhookQueue, req *discordRequest, method, url string) (time.Duration, error) {
+	body, contentType, err := encodeDiscordRequest(req)
+	if err != nil {
+		return -1, err
+	}
+	httpReq, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return -1, errors.Wrap(err, "failed to create request")
+	}
+	httpReq.Header.Set("Content-Type", contentType)
+	resp, err := d.client.Do(httpReq)
+	if err != nil {
+		return time.Second, errors.Wrap(err, "request failed")
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		if after, err := strconv.ParseFloat(resp.Header.Get("X-RateLimit-Reset-After"), 64); err == nil {
+			queue.resetAt = time.Now().Add(time.Duration(after * float64(time.Second)))
+		}
+	}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		var limit struct {
+			RetryAfter float64 `json:"retry_after"`
+		}
+		if json.Unmarshal(data, &limit) != nil || limit.RetryAfter <= 0 {
+			limit.RetryAfter, _ = strconv.ParseFloat(resp.Header.Get("Retry-After"), 64)
+		}
+		if limit.RetryAfter <= 0 {
+			limit.RetryAfter = 1
+		}
+		return time.Duration(limit.RetryAfter * float64(time.Second)), errors.New("rate limited")
+	case resp.StatusCode >= 500:
+		return time.Second, errors.New(fmt.Sprintf("server error: %s", resp.Status))
+	case resp.StatusCode >= 300:
+		return -1, errors.New(fmt.Sprintf("unexpected response: %s: %s", resp.Status, string(data)))
+	}
+
+	if method == http.MethodPost {
+		var message struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(data, &message); err != nil || message.ID == "" {
+			return -1, errors.New("response did not contain a message id")
+		}
+		d.remember(req.id, message.ID)
+	}
+	return 0, nil
+}
+
+func encodeDiscordRequest(req *discordRequest) (io.Reader, string, error) {
+	payload, err := json.Marshal(req.payload)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "failed to encode payload")
+	}
+	if req.screenshot == nil {
+		return bytes.NewReader(payload), "application/json", nil
+	}
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	if err := writer.WriteField("payload_json", string(payload)); err != nil {
+		return nil, "", err
+	}
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="files[0]"; filename="%s"`, screenshotName))
+	header.Set("Content-Type", "image/png")
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := part.Write(req.screenshot); err != nil {
+		return nil, "", err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, "", err
+	}
+	return &body, writer.FormDataContentType(), nil
+}
+
+func discordSettings(settings *config.Settings) *config.DiscordSettings {
+	if settings == nil || settings.Discord == nil {
+		return nil
+	}
+	discord := settings.Discord.Object()
+	if !discord.Enabled || discord.WebhookUrl == "" {
 		return nil
 	}
-	return nil
+	return &discord
+}
+
+func LogDiscord(settings *config.Settings, level LogLevel, message string) error {
+	_, err := LogDiscordUpdate(settings, level, message, nil, nil)
+	return err
 }
 
+// LogDiscordUpdate sends a message to the preset's webhook, or edits the message with the given ID if it is non-nil.
+// The ID of the message is returned, or nil if Discord logging is disabled.
 func LogDiscordUpdate(settings *config.Settings, level LogLevel, message string, id *int, screenshot *image.RGBA) (*int, error) {
-	if settings.Discord == nil {
+	discordSettings := discordSettings(settings)
+	if discordSettings == nil {
 		return nil, nil
 	}
-	return nil, nil
+	var edit int
+	if id != nil {
+		edit = *id
+	}
+	newID, err := discord.Send(discordSettings.WebhookUrl, level, config.Redact(message), discordSettings.PingID, edit, screenshot)
+	if err != nil {
+		return nil, err
+	}
+	return &newID, nil
+}
+
+// FlushDiscord waits for queued Discord messages to be sent
+func FlushDiscord(timeout time.Duration) bool {
+	return discord.Flush(timeout)
 }
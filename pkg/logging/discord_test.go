@@ -0,0 +1,186 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type webhookRequest struct {
+	method     string
+	path       string
+	payload    discordPayload
+	screenshot image.Image
+}
+
+type fakeWebhook struct {
+	sync.Mutex
+	requests []webhookRequest
+	// Responses returned before succeeding, e.g. rate limits
+	failures []func(w http.ResponseWriter)
+	nextID   int
+}
+
+func (f *fakeWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	req := webhookRequest{method: r.Method, path: r.URL.Path}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_ = json.Unmarshal([]byte(r.FormValue("payload_json")), &req.payload)
+		if file, _, err := r.FormFile("files[0]"); err == nil {
+			req.screenshot, _ = png.Decode(file)
+		}
+	} else {
+		data, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(data, &req.payload)
+	}
+	f.requests = append(f.requests, req)
+	if len(f.failures) > 0 {
+		failure := f.failures[0]
+		f.failures = f.failures[1:]
+		failure(w)
+		return
+	}
+	f.nextID++
+	_ = json.NewEncoder(w).Encode(map[string]string{"id": fmt.Sprintf("%d", 1000+f.nextID)})
+}
+
+func newFakeWebhook(t *testing.T) (*fakeWebhook, string) {
+	webhook := &fakeWebhook{}
+	server := httptest.NewServer(webhook)
+	t.Cleanup(server.Close)
+	return webhook, server.URL + "/api/webhooks/1/token"
+}
+
+func TestDiscordClient_Send(t *testing.T) {
+	webhook, url := newFakeWebhook(t)
+	client := NewDiscordClient(http.DefaultClient)
+
+	screenshot := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	id, err := client.Send(url, Info, "Game Loaded", 42, 0, screenshot)
+	assert.NoError(t, err)
+	_, err = client.Send(url, Error, "Failed to capture Roblox!", 42, 0, nil)
+	assert.NoError(t, err)
+	edited, err := client.Send(url, Success, "Game Loaded (edited)", 42, id, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, id, edited)
+	assert.True(t, client.Flush(time.Second))
+
+	assert.Len(t, webhook.requests, 3)
+	first := webhook.requests[0]
+	assert.Equal(t, http.MethodPost, first.method)
+	assert.Equal(t, "/api/webhooks/1/token", first.path)
+	assert.Equal(t, "Game Loaded", first.payload.Embeds[0].Description)
+	assert.Equal(t, LogColors[Info], first.payload.Embeds[0].Color)
+	assert.Equal(t, "attachment://screenshot.png", first.payload.Embeds[0].Image.Url)
+	assert.Empty(t, first.payload.Content, "only errors ping")
+	assert.NotNil(t, first.screenshot)
+	assert.Equal(t, 4, first.screenshot.Bounds().Dx())
+
+	ping := webhook.requests[1]
+	assert.Equal(t, "<@42>", ping.payload.Content)
+	assert.Equal(t, []string{"42"}, ping.payload.AllowedMentions.Users)
+	assert.Equal(t, LogColors[Error], ping.payload.Embeds[0].Color)
+
+	edit := webhook.requests[2]
+	assert.Equal(t, http.MethodPatch, edit.method)
+	assert.Equal(t, "/api/webhooks/1/token/messages/1001", edit.path)
+	assert.Equal(t, LogColors[Success], edit.payload.Embeds[0].Color)
+}
+
+func TestDiscordClient_EditLimit(t *testing.T) {
+	webhook, url := newFakeWebhook(t)
+	client := NewDiscordClient(http.DefaultClient)
+	client.limit = 2
+
+	var ids []int
+	for _, message := range []string{"first", "second", "third"} {
+		id, err := client.Send(url, Info, message, 0, 0, nil)
+		assert.NoError(t, err)
+		ids = append(ids, id)
+	}
+	assert.True(t, client.Flush(time.Second))
+	assert.Len(t, client.messages, 2)
+
+	// The oldest message was forgotten, so it is sent again instead of being edited
+	_, err := client.Send(url, Info, "first (edited)", 0, ids[0], nil)
+	assert.NoError(t, err)
+	_, err = client.Send(url, Info, "third (edited)", 0, ids[2], nil)
+	assert.NoError(t, err)
+	assert.True(t, client.Flush(time.Second))
+
+	assert.Len(t, webhook.requests, 5)
+	assert.Equal(t, http.MethodPost, webhook.requests[3].method)
+	assert.Equal(t, http.MethodPatch, webhook.requests[4].method)
+	assert.Equal(t, "/api/webhooks/1/token/messages/1003", webhook.requests[4].path)
+	assert.Len(t, client.messages, 2)
+}
+
+func TestDiscordClient_RateLimit(t *testing.T) {
+	webhook, url := newFakeWebhook(t)
+	webhook.failures = []func(w http.ResponseWriter){
+		func(w http.ResponseWriter) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"message": "You are being rate limited.", "retry_after": 0.05, "global": false}`))
+		},
+		func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+	client := NewDiscordClient(http.DefaultClient)
+
+	begin := time.Now()
+	_, err := client.Send(url, Info, "first", 0, 0, nil)
+	assert.NoError(t, err)
+	_, err = client.Send(url, Info, "second", 0, 0, nil)
+	assert.NoError(t, err)
+	assert.True(t, client.Flush(5*time.Second))
+	assert.GreaterOrEqual(t, time.Since(begin), 50*time.Millisecond)
+
+	// Rate limited requests are retried in order
+	var messages []string
+	for _, req := range webhook.requests {
+		messages = append(messages, req.payload.Embeds[0].Description)
+	}
+	assert.Equal(t, []string{"first", "first", "first", "second"}, messages)
+}
+
+func TestDiscordClient_Bucket(t *testing.T) {
+	var mu sync.Mutex
+	var times []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		times = append(times, time.Now())
+		mu.Unlock()
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset-After", "0.05")
+		_, _ = w.Write([]byte(`{"id": "1"}`))
+	}))
+	defer server.Close()
+	client := NewDiscordClient(http.DefaultClient)
+
+	for i := 0; i < 3; i++ {
+		_, err := client.Send(server.URL, Info, "message", 0, 0, nil)
+		assert.NoError(t, err)
+	}
+	assert.True(t, client.Flush(5*time.Second))
+	assert.Len(t, times, 3)
+	// Requests wait for the exhausted bucket to reset
+	for i := 1; i < len(times); i++ {
+		assert.GreaterOrEqual(t, times[i].Sub(times[i-1]), 45*time.Millisecond)
+	}
+}
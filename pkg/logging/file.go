@@ -0,0 +1,218 @@
+package logging
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/nosyliam/revolution/pkg/config"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const rotatedTimeFormat = "20060102T150405"
+
+// Entry is a single line of an account log file
+type Entry struct {
+	Time      time.Time `json:"time"`
+	Level     LogLevel  `json:"level"`
+	Account   string    `json:"account"`
+	Stack     []string  `json:"stack,omitempty"`
+	Verbosity int       `json:"verbosity"`
+	Message   string    `json:"message"`
+	Console   bool      `json:"console,omitempty"`
+}
+
+type logLimits struct {
+	maxSize   int64
+	maxAge    time.Duration
+	maxFiles  int
+	retention time.Duration
+}
+
+type logFile struct {
+	file    *os.File
+	size    int64
+	created time.Time
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9_\-]+`)
+
+func logFileName(account string) string {
+	name := unsafeFileChars.ReplaceAllString(account, "_")
+	if name == "" {
+		name = "_"
+	}
+	return name
+}
+
+// Initialize enables file logging to the given directory using the rotation and retention limits of the given settings
+func Initialize(dir string, settings *config.Object[config.LogSettings]) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrap(err, "failed to create log directory")
+	}
+	writer.Lock()
+	defer writer.Unlock()
+	writer.closeFiles()
+	writer.dir = dir
+	writer.limits = func() logLimits {
+		s := settings.Object()
+		return logLimits{
+			maxSize:   int64(s.MaxSize) * 1024 * 1024,
+			maxAge:    s.MaxAge,
+			maxFiles:  s.MaxFiles,
+			retention: s.Retention,
+		}
+	}
+	writer.recorder = settings.Object().Recorder
+	return nil
+}
+
+// Close closes every open log file
+func Close() {
+	writer.Lock()
+	defer writer.Unlock()
+	writer.closeFiles()
+}
+
+func (l *logWriter) closeFiles() {
+	for account, file := range l.files {
+		_ = file.file.Close()
+		delete(l.files, account)
+	}
+}
+
+// Write appends an entry to the log file of its account, rotating the file if it exceeds the size or age limit
+func (l *logWriter) Write(entry Entry) error {
+	l.Lock()
+	defer l.Unlock()
+	if l.dir == "" {
+		return nil
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode log entry")
+	}
+	data = append(data, '\n')
+
+	name := logFileName(entry.Account)
+	limits := l.limits()
+	file, ok := l.files[name]
+	if !ok {
+		if file, err = l.open(name); err != nil {
+			return err
+		}
+	}
+	if file.size > 0 && ((limits.maxSize > 0 && file.size+int64(len(data)) > limits.maxSize) ||
+		(limits.maxAge > 0 && l.now().Sub(file.created) > limits.maxAge)) {
+		if file, err = l.rotate(name, file, limits); err != nil {
+			return err
+		}
+	}
+	n, err := file.file.Write(data)
+	file.size += int64(n)
+	if err != nil {
+		return errors.Wrap(err, "failed to write log entry")
+	}
+	return nil
+}
+
+func (l *logWriter) open(name string) (*logFile, error) {
+	path := filepath.Join(l.dir, name+".log")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open log file")
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, errors.Wrap(err, "failed to open log file")
+	}
+	file := &logFile{file: f, size: info.Size(), created: l.now()}
+	if info.Size() > 0 {
+		file.created = firstEntryTime(path, info.ModTime())
+	}
+	if l.files == nil {
+		l.files = make(map[string]*logFile)
+	}
+	l.files[name] = file
+	return file, nil
+}
+
+// firstEntryTime returns the time of the first entry of an existing log file, which is when the file was started
+func firstEntryTime(path string, fallback time.Time) time.Time {
+	f, err := os.Open(path)
+	if err != nil {
+		return fallback
+	}
+	defer f.Close()
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil {
+		return fallback
+	}
+	var entry Entry
+	if err := json.Unmarshal(line, &entry); err != nil || entry.Time.IsZero() {
+		return fallback
+	}
+	return entry.Time
+}
+
+func (l *logWriter) rotate(name string, file *logFile, limits logLimits) (*logFile, error) {
+	_ = file.file.Close()
+	delete(l.files, name)
+	current := filepath.Join(l.dir, name+".log")
+	rotated := filepath.Join(l.dir, fmt.Sprintf("%s.%s.log", name, l.now().Format(rotatedTimeFormat)))
+	for i := 1; ; i++ {
+		if _, err := os.Stat(rotated); os.IsNotExist(err) {
+			break
+		}
+		rotated = filepath.Join(l.dir, fmt.Sprintf("%s.%s-%d.log", name, l.now().Format(rotatedTimeFormat), i))
+	}
+	if err := os.Rename(current, rotated); err != nil {
+		return nil, errors.Wrap(err, "failed to rotate log file")
+	}
+	l.prune(name, limits)
+	return l.open(name)
+}
+
+// prune removes the rotated logs of an account which exceed the file count or retention limit
+func (l *logWriter) prune(name string, limits logLimits) {
+	matches, err := filepath.Glob(filepath.Join(l.dir, name+".*.log"))
+	if err != nil {
+		return
+	}
+	type rotatedFile struct {
+		path string
+		time time.Time
+	}
+	var files []rotatedFile
+	prefix := name + "."
+	for _, path := range matches {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), prefix), ".log")
+		if i := strings.Index(stamp, "-"); i >= 0 {
+			stamp = stamp[:i]
+		}
+		t, err := time.ParseInLocation(rotatedTimeFormat, stamp, time.Local)
+		if err != nil {
+			continue
+		}
+		files = append(files, rotatedFile{path, t})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].time.Equal(files[j].time) {
+			return files[i].path > files[j].path
+		}
+		return files[i].time.After(files[j].time)
+	})
+	for i, file := range files {
+		// The current log file counts towards the file limit
+		if (limits.maxFiles > 0 && i+1 >= limits.maxFiles) ||
+			(limits.retention > 0 && l.now().Sub(file.time) > limits.retention) {
+			_ = os.Remove(file.path)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package logging
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/nosyliam/revolution/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func useTestWriter(t *testing.T, limits logLimits) *logWriter {
+	old := writer
+	writer = newLogWriter(t.TempDir(), func() logLimits { return limits })
+	t.Cleanup(func() {
+		writer.closeFiles()
+		writer = old
+	})
+	return writer
+}
+
+func readEntries(t *testing.T, path string) []Entry {
+	f, err := os.Open(path)
+	assert.NoError(t, err)
+	defer f.Close()
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry Entry
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func logFiles(t *testing.T, dir string) []string {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.log"))
+	assert.NoError(t, err)
+	var names []string
+	for _, match := range matches {
+		names = append(names, filepath.Base(match))
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestLogger_File(t *testing.T) {
+	cwd, _ := os.Getwd()
+	assert.NoError(t, os.Chdir(t.TempDir()))
+	defer os.Chdir(cwd)
+	w := useTestWriter(t, logLimits{})
+
+	cfg, err := config.NewConfig(config.NewOfflineRuntime())
+	assert.NoError(t, err)
+	preset := *config.Concrete[*config.Object[config.Settings]](cfg, "presets[Default]")
+	assert.NoError(t, preset.SetPath("logVerbosity", 1))
+
+	logger := NewLogger("Main Alt", preset)
+	routine := logger.Child("Main").Child("KillVic")
+	assert.NoError(t, logger.Log(0, Info, "started"))
+	assert.NoError(t, routine.Log(1, Warning, "verbose"))
+	assert.NoError(t, routine.Log(2, Info, "too verbose"))
+	_, err = routine.LogDiscord(Error, "failed", nil, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, NewLogger("Root", nil).Log(1, Info, "discarded"))
+
+	assert.Equal(t, []string{"Main_Alt.log"}, logFiles(t, w.dir))
+	entries := readEntries(t, filepath.Join(w.dir, "Main_Alt.log"))
+	assert.Len(t, entries, 3)
+	assert.Equal(t, "started", entries[0].Message)
+	assert.Empty(t, entries[0].Stack)
+	assert.Equal(t, Warning, entries[1].Level)
+	assert.Equal(t, 1, entries[1].Verbosity)
+	assert.Equal(t, []string{"Main", "KillVic"}, entries[1].Stack)
+	assert.Equal(t, "Main Alt", entries[2].Account)
+	assert.False(t, entries[2].Time.IsZero())
+
+	// Sibling loggers do not share their stacks
+	a, b := logger.Child("Main").Child("A"), logger.Child("Main").Child("B")
+	assert.Equal(t, []string{"Main Alt", "Main", "A"}, a.stack)
+	assert.Equal(t, []string{"Main Alt", "Main", "B"}, b.stack)
+}
+
+func TestLogWriter_Rotation(t *testing.T) {
+	w := useTestWriter(t, logLimits{maxSize: 200, maxFiles: 3})
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local)
+	w.now = func() time.Time { return now }
+
+	for i := 0; i < 10; i++ {
+		now = now.Add(time.Second)
+		assert.NoError(t, w.Write(Entry{Time: now, Level: Info, Account: "Alt", Message: "a message which is fairly long"}))
+	}
+	// The current log and two rotated logs are retained
+	files := logFiles(t, w.dir)
+	assert.Len(t, files, 3)
+	assert.Contains(t, files, "Alt.log")
+	for _, file := range files {
+		info, err := os.Stat(filepath.Join(w.dir, file))
+		assert.NoError(t, err)
+		assert.LessOrEqual(t, info.Size(), int64(200))
+	}
+	assert.Equal(t, "Alt.20240101T120010.log", files[1])
+}
+
+func TestLogWriter_AgeRetention(t *testing.T) {
+	w := useTestWriter(t, logLimits{maxAge: time.Hour, retention: 2 * time.Hour})
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local)
+	w.now = func() time.Time { return now }
+
+	for i := 0; i < 6; i++ {
+		assert.NoError(t, w.Write(Entry{Time: now, Level: Info, Account: "Alt", Message: "hourly"}))
+		now = now.Add(90 * time.Minute)
+	}
+	// Rotated logs older than the retention period are removed
+	assert.Equal(t, []string{"Alt.20240101T180000.log", "Alt.20240101T193000.log", "Alt.log"}, logFiles(t, w.dir))
+
+	// The age of an existing log is taken from its first entry after a restart
+	w.closeFiles()
+	now = now.Add(30 * time.Minute)
+	assert.NoError(t, w.Write(Entry{Time: now, Level: Info, Account: "Alt", Message: "restarted"}))
+	assert.Len(t, readEntries(t, filepath.Join(w.dir, "Alt.log")), 1)
+}
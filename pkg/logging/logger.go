@@ -3,8 +3,11 @@ package logging
 import (
 	"fmt"
 	"github.com/nosyliam/revolution/pkg/config"
+	"github.com/pkg/errors"
 	"image"
 	"os"
+	"slices"
+	"sync"
 	"time"
 )
 
@@ -17,19 +20,20 @@ const (
 	Error   LogLevel = "ERROR"
 )
 
-var writer = &logWriter{}
+var writer = newLogWriter("", func() logLimits { return logLimits{} })
 
 type logWriter struct {
-	file *os.File
-}
+	sync.Mutex
+	dir    string
+	files  map[string]*logFile
+	limits func() logLimits
+	now    func() time.Time
 
-func (l *logWriter) Initialize() error {
-	return nil
+	recorder *config.Object[config.FlightRecorder]
 }
 
-func (l *logWriter) Write(level LogLevel, line string) error {
-	line = fmt.Sprintf("[%s] %s", level, line)
-	return nil
+func newLogWriter(dir string, limits func() logLimits) *logWriter {
+	return &logWriter{dir: dir, files: make(map[string]*logFile), limits: limits, now: time.Now}
 }
 
 type Logger struct {
@@ -39,17 +43,62 @@ type Logger struct {
 }
 
 func (s *Logger) Child(name string) *Logger {
-	return &Logger{stack: append(s.stack, name), settings: s.settings}
+	return &Logger{stack: append(slices.Clip(s.stack), name), verbosity: s.verbosity, settings: s.settings}
+}
+
+// Verbosity returns the log verbosity of the logger's preset. Messages with a higher verbosity are discarded.
+func (s *Logger) Verbosity() int {
+	if s.settings == nil {
+		return s.verbosity
+	}
+	return s.settings.Object().LogVerbosity
+}
+
+func (s *Logger) write(verbosity int, level LogLevel, message string) {
+	entry := Entry{
+		Time:      time.Now(),
+		Level:     level,
+		Account:   s.stack[0],
+		Stack:     s.stack[1:],
+		Verbosity: verbosity,
+		Message:   message,
+	}
+	fmt.Printf("[%s] %s: %s\n", entry.Time.Format("15:04:05"), level, message)
+	publish(entry)
+	// A failure to write the log file should never interrupt the macro
+	if err := writer.Write(entry); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write log for %s: %v\n", entry.Account, err)
+	}
 }
 
 func (s *Logger) Log(verbosity int, level LogLevel, message string) error {
-	fmt.Printf("[%s] %s: %s\n", time.Now().Format("15:04:05"), level, message)
+	if verbosity > s.Verbosity() {
+		return nil
+	}
+	s.write(verbosity, level, config.Redact(message))
 	return nil
 }
 
+// LogDiscord logs a message and sends it to the preset's Discord webhook and notifiers. If id is non-nil, the previously
+// sent message with that ID is edited instead and the notifiers, which cannot edit messages, are skipped. The ID of the
+// message is returned.
 func (s *Logger) LogDiscord(level LogLevel, message string, id *int, screenshot *image.RGBA) (int, error) {
-	fmt.Printf("[%s] %s: %s\n", time.Now().Format("15:04:05"), level, message)
-	return 0, nil
+	s.write(0, level, config.Redact(message))
+	if s.settings == nil {
+		return 0, nil
+	}
+	settings := s.settings.Object()
+	if id == nil {
+		notification := Notification{Account: s.stack[0], Level: level, Message: message, Screenshot: screenshot}
+		if err := Notify(&settings, notification); err != nil {
+			s.write(0, Warning, config.Redact(errors.Wrap(err, "failed to send notifications").Error()))
+		}
+	}
+	newID, err := LogDiscordUpdate(&settings, level, message, id, screenshot)
+	if err != nil || newID == nil {
+		return 0, err
+	}
+	return *newID, nil
 }
 
 func NewLogger(name string, settings *config.Object[config.Settings]) *Logger {
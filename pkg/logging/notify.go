@@ -0,0 +1,385 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/nosyliam/revolution/pkg/config"
+	"github.com/pkg/errors"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const notifyQueueSize = 64
+
+// Notification is a log message delivered to a notification sink
+type Notification struct {
+	Account    string
+	Level      LogLevel
+	Message    string
+	Time       time.Time
+	Screenshot *image.RGBA
+}
+
+func (n Notification) Title() string {
+	return fmt.Sprintf("Revolution: %s", n.Account)
+}
+
+// Notifier delivers notifications to a sink other than Discord
+type Notifier interface {
+	Notify(n Notification) error
+}
+
+func encodePNG(img *image.RGBA) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, errors.Wrap(err, "failed to encode screenshot")
+	}
+	return buf.Bytes(), nil
+}
+
+func checkResponse(resp *http.Response) error {
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return errors.New(fmt.Sprintf("unexpected response: %s: %s", resp.Status, strings.TrimSpace(string(data))))
+	}
+	return nil
+}
+
+// WebhookNotifier posts notifications as JSON to an arbitrary URL
+type WebhookNotifier struct {
+	Client      *http.Client
+	Url         string
+	Token       string
+	Screenshots bool
+}
+
+type webhookPayload struct {
+	Account    string    `json:"account"`
+	Level      LogLevel  `json:"level"`
+	Message    string    `json:"message"`
+	Time       time.Time `json:"time"`
+	Screenshot string    `json:"screenshot,omitempty"` // Base64 encoded PNG
+}
+
+func (w *WebhookNotifier) Notify(n Notification) error {
+	payload := webhookPayload{Account: n.Account, Level: n.Level, Message: n.Message, Time: n.Time}
+	if w.Screenshots && n.Screenshot != nil {
+		data, err := encodePNG(n.Screenshot)
+		if err != nil {
+			return err
+		}
+		payload.Screenshot = base64.StdEncoding.EncodeToString(data)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode payload")
+	}
+	req, err := http.NewRequest(http.MethodPost, w.Url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "failed to create request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+w.Token)
+	}
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "request failed")
+	}
+	return checkResponse(resp)
+}
+
+// NtfyNotifier publishes notifications to an ntfy topic URL. Screenshots are sent as attachments, in which case the
+// message is sent in a header.
+type NtfyNotifier struct {
+	Client      *http.Client
+	Url         string
+	Token       string
+	Screenshots bool
+}
+
+var ntfyPriorities = map[LogLevel]string{
+	Info:    "low",
+	Success: "default",
+	Warning: "default",
+	Error:   "high",
+}
+
+var ntfyTags = map[LogLevel]string{
+	Info:    "information_source",
+	Success: "white_check_mark",
+	Warning: "warning",
+	Error:   "rotating_light",
+}
+
+func (t *NtfyNotifier) Notify(n Notification) error {
+	var req *http.Request
+	var err error
+	if t.Screenshots && n.Screenshot != nil {
+		data, encErr := encodePNG(n.Screenshot)
+		if encErr != nil {
+			return encErr
+		}
+		if req, err = http.NewRequest(http.MethodPut, t.Url, bytes.NewReader(data)); err == nil {
+			req.Header.Set("Filename", screenshotName)
+			// Header values cannot contain line breaks
+			req.Header.Set("Message", strings.ReplaceAll(n.Message, "\n", " "))
+		}
+	} else {
+		req, err = http.NewRequest(http.MethodPost, t.Url, strings.NewReader(n.Message))
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to create request")
+	}
+	req.Header.Set("Title", n.Title())
+	req.Header.Set("Priority", ntfyPriorities[n.Level])
+	req.Header.Set("Tags", ntfyTags[n.Level])
+	if t.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+t.Token)
+	}
+	resp, err := t.Client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "request failed")
+	}
+	return checkResponse(resp)
+}
+
+// SMTPNotifier emails notifications. Authentication is only used when a username is set.
+type SMTPNotifier struct {
+	Host        string
+	Port        int
+	Username    string
+	Password    string
+	From        string
+	To          []string
+	Screenshots bool
+}
+
+func (s *SMTPNotifier) Notify(n Notification) error {
+	if len(s.To) == 0 {
+		return errors.New("no recipients")
+	}
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+	msg, err := s.message(n)
+	if err != nil {
+		return err
+	}
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+	if err := smtp.SendMail(addr, auth, s.From, s.To, msg); err != nil {
+		return errors.Wrap(err, "failed to send email")
+	}
+	return nil
+}
+
+func (s *SMTPNotifier) message(n Notification) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", s.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(&buf, "Subject: [%s] %s\r\n", n.Level, n.Title())
+	fmt.Fprintf(&buf, "Date: %s\r\n", n.Time.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	body := strings.ReplaceAll(n.Message, "\n", "\r\n") + "\r\n"
+	if !s.Screenshots || n.Screenshot == nil {
+		buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+		buf.WriteString(body)
+		return buf.Bytes(), nil
+	}
+
+	data, err := encodePNG(n.Screenshot)
+	if err != nil {
+		return nil, err
+	}
+	var parts bytes.Buffer
+	writer := multipart.NewWriter(&parts)
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", writer.Boundary())
+	text, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+	if err != nil {
+		return nil, err
+	}
+	_, _ = text.Write([]byte(body))
+	attachment, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"image/png"},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Disposition":       {fmt.Sprintf(`attachment; filename="%s"`, screenshotName)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		_, _ = attachment.Write([]byte(encoded[:76] + "\r\n"))
+		encoded = encoded[76:]
+	}
+	_, _ = attachment.Write([]byte(encoded + "\r\n"))
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	buf.Write(parts.Bytes())
+	return buf.Bytes(), nil
+}
+
+// DesktopNotifier shows notifications on the local desktop. Native notifications are not implemented yet, so they are
+// printed to the terminal.
+type DesktopNotifier struct{}
+
+var desktopNotify = func(title, message string) error {
+	fmt.Printf("[%s] %s: %s\n", time.Now().Format("15:04:05"), title, message)
+	return nil
+}
+
+func (d *DesktopNotifier) Notify(n Notification) error {
+	return desktopNotify(n.Title(), fmt.Sprintf("%s: %s", n.Level, n.Message))
+}
+
+var notifyClient = &http.Client{Timeout: 30 * time.Second}
+
+// NewNotifier creates the notifier described by the given settings
+func NewNotifier(settings config.NotifierSettings) (Notifier, error) {
+	switch settings.Kind {
+	case config.WebhookNotifierKind, config.NtfyNotifierKind, "":
+		if settings.Url == "" {
+			return nil, errors.New(fmt.Sprintf("notifier %s has no url", settings.Name))
+		}
+		if settings.Kind == config.NtfyNotifierKind {
+			return &NtfyNotifier{Client: notifyClient, Url: settings.Url, Token: settings.Token, Screenshots: settings.Screenshots}, nil
+		}
+		return &WebhookNotifier{Client: notifyClient, Url: settings.Url, Token: settings.Token, Screenshots: settings.Screenshots}, nil
+	case config.SMTPNotifierKind:
+		if settings.SMTP == nil || settings.SMTP.Object().Host == "" {
+			return nil, errors.New(fmt.Sprintf("notifier %s has no smtp host", settings.Name))
+		}
+		s := settings.SMTP.Object()
+		if s.Port == 0 {
+			s.Port = 587
+		}
+		notifier := &SMTPNotifier{
+			Host:        s.Host,
+			Port:        s.Port,
+			Username:    s.Username,
+			Password:    s.Password,
+			From:        s.From,
+			Screenshots: settings.Screenshots,
+		}
+		if s.To != nil {
+			s.To.ForEach(func(to *string) {
+				notifier.To = append(notifier.To, *to)
+			})
+		}
+		return notifier, nil
+	case config.DesktopNotifierKind:
+		return &DesktopNotifier{}, nil
+	}
+	return nil, errors.New(fmt.Sprintf("notifier %s has unknown kind \"%s\"", settings.Name, settings.Kind))
+}
+
+// routesLevel returns whether a notifier receives messages of the given level
+func routesLevel(settings config.NotifierSettings, level LogLevel) bool {
+	if settings.Levels == nil {
+		return true
+	}
+	var levels, routed bool
+	settings.Levels.ForEach(func(l *string) {
+		levels = true
+		if strings.EqualFold(*l, string(level)) {
+			routed = true
+		}
+	})
+	return routed || !levels
+}
+
+type notifyRequest struct {
+	name         string
+	notification Notification
+	notifier     Notifier
+}
+
+type notifyQueue struct {
+	once     sync.Once
+	requests chan *notifyRequest
+	pending  sync.WaitGroup
+}
+
+var notifications = &notifyQueue{}
+
+func (q *notifyQueue) work() {
+	for req := range q.requests {
+		if err := req.notifier.Notify(req.notification); err != nil {
+			fmt.Printf("[%s] %s: failed to send notification to %s: %s\n", time.Now().Format("15:04:05"), Error, req.name, config.Redact(err.Error()))
+		}
+		q.pending.Done()
+	}
+}
+
+func (q *notifyQueue) push(req *notifyRequest) error {
+	q.once.Do(func() {
+		q.requests = make(chan *notifyRequest, notifyQueueSize)
+		go q.work()
+	})
+	q.pending.Add(1)
+	select {
+	case q.requests <- req:
+		return nil
+	default:
+		q.pending.Done()
+		return errors.New("notification queue is full")
+	}
+}
+
+// Notify queues a notification for every enabled notifier of the preset which receives its level
+func Notify(settings *config.Settings, n Notification) error {
+	if settings == nil || settings.Notifiers == nil {
+		return nil
+	}
+	n.Message = config.Redact(n.Message)
+	if n.Time.IsZero() {
+		n.Time = time.Now()
+	}
+	var errs []string
+	settings.Notifiers.ForEach(func(sink *config.NotifierSettings) {
+		if sink.Disabled || !routesLevel(*sink, n.Level) {
+			return
+		}
+		notifier, err := NewNotifier(*sink)
+		if err == nil {
+			err = notifications.push(&notifyRequest{name: sink.Name, notification: n, notifier: notifier})
+		}
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	})
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// FlushNotifications waits until every queued notification has been sent or the timeout has elapsed
+func FlushNotifications(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		notifications.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
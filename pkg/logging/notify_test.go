@@ -0,0 +1,238 @@
+package logging
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/nosyliam/revolution/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"image"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type capturedRequest struct {
+	method string
+	header http.Header
+	body   []byte
+}
+
+func newCaptureServer(t *testing.T) (*[]capturedRequest, *sync.Mutex, string) {
+	var mu sync.Mutex
+	var requests []capturedRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		requests = append(requests, capturedRequest{method: r.Method, header: r.Header.Clone(), body: body})
+		mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+	return &requests, &mu, server.URL
+}
+
+// fakeSMTP accepts a single connection and records the commands and message it receives
+type fakeSMTP struct {
+	addr     string
+	commands []string
+	message  string
+	done     chan struct{}
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+	server := &fakeSMTP{addr: listener.Addr().String(), done: make(chan struct{})}
+	go func() {
+		defer close(server.done)
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { _, _ = conn.Write([]byte(line + "\r\n")) }
+		reply("220 localhost ESMTP")
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			server.commands = append(server.commands, line)
+			switch cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); cmd {
+			case "EHLO", "HELO":
+				reply("250 localhost")
+			case "DATA":
+				reply("354 go ahead")
+				var data strings.Builder
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				server.message = data.String()
+				reply("250 queued")
+			case "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+	return server
+}
+
+func testNotification() Notification {
+	return Notification{
+		Account:    "Main Alt",
+		Level:      Error,
+		Message:    "Failed to claim hive!",
+		Time:       time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+		Screenshot: image.NewRGBA(image.Rect(0, 0, 4, 4)),
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	requests, _, url := newCaptureServer(t)
+	notifier := &WebhookNotifier{Client: http.DefaultClient, Url: url, Token: "token", Screenshots: true}
+	assert.NoError(t, notifier.Notify(testNotification()))
+
+	assert.Len(t, *requests, 1)
+	req := (*requests)[0]
+	assert.Equal(t, "Bearer token", req.header.Get("Authorization"))
+	assert.Equal(t, "application/json", req.header.Get("Content-Type"))
+	var payload webhookPayload
+	assert.NoError(t, json.Unmarshal(req.body, &payload))
+	assert.Equal(t, "Main Alt", payload.Account)
+	assert.Equal(t, Error, payload.Level)
+	assert.Equal(t, "Failed to claim hive!", payload.Message)
+	assert.NotEmpty(t, payload.Screenshot)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad token", http.StatusUnauthorized)
+	}))
+	defer server.Close()
+	notifier.Url = server.URL
+	assert.ErrorContains(t, notifier.Notify(testNotification()), "bad token")
+}
+
+func TestNtfyNotifier(t *testing.T) {
+	requests, _, url := newCaptureServer(t)
+	notifier := &NtfyNotifier{Client: http.DefaultClient, Url: url + "/revolution"}
+	n := testNotification()
+	assert.NoError(t, notifier.Notify(n))
+	notifier.Screenshots = true
+	n.Level = Info
+	assert.NoError(t, notifier.Notify(n))
+
+	assert.Len(t, *requests, 2)
+	text := (*requests)[0]
+	assert.Equal(t, http.MethodPost, text.method)
+	assert.Equal(t, "Failed to claim hive!", string(text.body))
+	assert.Equal(t, "Revolution: Main Alt", text.header.Get("Title"))
+	assert.Equal(t, "high", text.header.Get("Priority"))
+	assert.Equal(t, "rotating_light", text.header.Get("Tags"))
+	assert.Empty(t, text.header.Get("Authorization"))
+
+	attachment := (*requests)[1]
+	assert.Equal(t, http.MethodPut, attachment.method)
+	assert.Equal(t, "screenshot.png", attachment.header.Get("Filename"))
+	assert.Equal(t, "Failed to claim hive!", attachment.header.Get("Message"))
+	assert.Equal(t, "low", attachment.header.Get("Priority"))
+	assert.Equal(t, "\x89PNG", string(attachment.body[:4]))
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	server := newFakeSMTP(t)
+	host, port, _ := net.SplitHostPort(server.addr)
+	notifier, err := NewNotifier(config.NotifierSettings{Name: "email", Kind: config.SMTPNotifierKind})
+	assert.Nil(t, notifier)
+	assert.ErrorContains(t, err, "no smtp host")
+
+	portNum, _ := strconv.Atoi(port)
+	smtpNotifier := &SMTPNotifier{Host: host, Port: portNum, From: "macro@example.com", To: []string{"me@example.com"}, Screenshots: true}
+	assert.NoError(t, smtpNotifier.Notify(testNotification()))
+	<-server.done
+
+	assert.Contains(t, server.commands, "MAIL FROM:<macro@example.com>")
+	assert.Contains(t, server.commands, "RCPT TO:<me@example.com>")
+	assert.Contains(t, server.message, "Subject: [ERROR] Revolution: Main Alt\r\n")
+	assert.Contains(t, server.message, "Content-Type: multipart/mixed; boundary=")
+	assert.Contains(t, server.message, "Failed to claim hive!\r\n")
+	assert.Contains(t, server.message, `filename="screenshot.png"`)
+}
+
+func TestNotify_Routing(t *testing.T) {
+	cwd, _ := os.Getwd()
+	assert.NoError(t, os.Chdir(t.TempDir()))
+	defer os.Chdir(cwd)
+
+	requests, mu, url := newCaptureServer(t)
+	assert.NoError(t, os.WriteFile("settings.yaml", []byte(`presets:
+    - name: Default
+      notifiers:
+        - name: errors
+          kind: ntfy
+          url: `+url+`/errors
+          levels: [error, warning]
+        - name: everything
+          kind: webhook
+          url: `+url+`/all
+        - name: disabled
+          kind: webhook
+          disabled: true
+          url: `+url+`/disabled
+        - name: desktop
+          kind: desktop
+          levels: [success]
+`), 0644))
+	cfg, err := config.NewConfig(config.NewOfflineRuntime())
+	assert.NoError(t, err)
+	preset := *config.Concrete[*config.Object[config.Settings]](cfg, "presets[Default]")
+
+	var desktop []string
+	old := desktopNotify
+	desktopNotify = func(title, message string) error {
+		desktop = append(desktop, message)
+		return nil
+	}
+	defer func() { desktopNotify = old }()
+
+	logger := NewLogger("Main Alt", preset)
+	for _, level := range []LogLevel{Info, Warning, Success, Error} {
+		_, err := logger.LogDiscord(level, string(level), nil, nil)
+		assert.NoError(t, err)
+	}
+	// Edits are not sent to notifiers
+	id := 1
+	_, err = logger.LogDiscord(Error, "edited", &id, nil)
+	assert.NoError(t, err)
+	assert.True(t, FlushNotifications(time.Second))
+
+	mu.Lock()
+	defer mu.Unlock()
+	var errorsTopic, all []string
+	for _, req := range *requests {
+		if req.method == http.MethodPost && req.header.Get("Title") != "" {
+			errorsTopic = append(errorsTopic, string(req.body))
+		} else {
+			var payload webhookPayload
+			assert.NoError(t, json.Unmarshal(req.body, &payload))
+			all = append(all, payload.Message)
+		}
+	}
+	assert.Equal(t, []string{"WARNING", "ERROR"}, errorsTopic)
+	assert.Equal(t, []string{"INFO", "WARNING", "SUCCESS", "ERROR"}, all)
+	assert.Equal(t, []string{"SUCCESS: SUCCESS"}, desktop)
+}
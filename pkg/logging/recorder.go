@@ -0,0 +1,285 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/nosyliam/revolution/pkg/config"
+	"github.com/pkg/errors"
+	"image"
+	"image/color/palette"
+	"image/gif"
+	"image/png"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	maxTraceEntries  = 500
+	dumpCooldown     = 10 * time.Second
+	recordingsDir    = "recordings"
+	traceFileName    = "trace.json"
+	gifRecordingName = "recording.gif"
+)
+
+// TraceEntry is an action executed by a routine. Stack lists the routines being executed, innermost first.
+type TraceEntry struct {
+	Time   time.Time `json:"time"`
+	Stack  []string  `json:"stack,omitempty"`
+	Action string    `json:"action"`
+}
+
+type recordedFrame struct {
+	time  time.Time
+	image *image.Paletted
+}
+
+type recorderLimits struct {
+	enabled   bool
+	duration  time.Duration
+	interval  time.Duration
+	maxWidth  int
+	maxMemory int
+	format    string
+	maxDumps  int
+}
+
+// FlightRecorder holds the most recent frames of a window, downscaled and reduced to the web safe palette, along with
+// a trace of the most recent actions. The frames are bounded by both age and memory usage.
+type FlightRecorder struct {
+	mu       sync.Mutex
+	account  string
+	limits   func() recorderLimits
+	frames   []recordedFrame
+	memory   int
+	trace    []TraceEntry
+	lastDump time.Time
+
+	now func() time.Time
+}
+
+// NewFlightRecorder creates a recorder which uses the recorder settings passed to Initialize. It is disabled until
+// logging has been initialized.
+func NewFlightRecorder(account string) *FlightRecorder {
+	return &FlightRecorder{
+		account: account,
+		limits: func() recorderLimits {
+			writer.Lock()
+			settings := writer.recorder
+			writer.Unlock()
+			if settings == nil {
+				return recorderLimits{}
+			}
+			s := settings.Object()
+			limits := recorderLimits{
+				enabled:   s.Enabled,
+				duration:  s.Duration,
+				maxWidth:  s.MaxWidth,
+				maxMemory: s.MaxMemory * 1024 * 1024,
+				format:    s.Format,
+				maxDumps:  s.MaxRecordings,
+			}
+			if s.FrameRate > 0 {
+				limits.interval = time.Second / time.Duration(s.FrameRate)
+			}
+			return limits
+		},
+		now: time.Now,
+	}
+}
+
+// AddFrame records a frame if enough time has passed since the last recorded frame
+func (r *FlightRecorder) AddFrame(img *image.RGBA) {
+	if img == nil {
+		return
+	}
+	limits := r.limits()
+	if !limits.enabled {
+		return
+	}
+	now := r.now()
+	r.mu.Lock()
+	if len(r.frames) > 0 && now.Sub(r.frames[len(r.frames)-1].time) < limits.interval {
+		r.mu.Unlock()
+		return
+	}
+	r.mu.Unlock()
+
+	// Frames are reduced outside the lock as they arrive on the capture goroutine
+	frame := recordedFrame{time: now, image: reduceFrame(img, limits.maxWidth)}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.frames = append(r.frames, frame)
+	r.memory += len(frame.image.Pix)
+	r.evict(limits, now)
+}
+
+func (r *FlightRecorder) evict(limits recorderLimits, now time.Time) {
+	var n int
+	for n < len(r.frames) {
+		expired := limits.duration > 0 && now.Sub(r.frames[n].time) > limits.duration
+		if !expired && (limits.maxMemory <= 0 || r.memory <= limits.maxMemory) {
+			break
+		}
+		r.memory -= len(r.frames[n].image.Pix)
+		r.frames[n] = recordedFrame{}
+		n++
+	}
+	r.frames = r.frames[n:]
+}
+
+// Trace records an action
+func (r *FlightRecorder) Trace(stack []string, action string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.trace = append(r.trace, TraceEntry{Time: r.now(), Stack: slices.Clone(stack), Action: action})
+	if len(r.trace) > maxTraceEntries {
+		r.trace = slices.Delete(r.trace, 0, len(r.trace)-maxTraceEntries)
+	}
+}
+
+// Reset discards every recorded frame and action
+func (r *FlightRecorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.frames, r.trace, r.memory = nil, nil, 0
+}
+
+// Dump writes the recorded frames and action trace to a new directory within the log directory and returns its path.
+// The frames are written as an animated GIF or as a PNG sequence depending on the recorder's format. An empty path is
+// returned if the recorder is disabled, file logging is disabled or a recording was made too recently.
+func (r *FlightRecorder) Dump(reason string) (string, error) {
+	limits := r.limits()
+	if !limits.enabled {
+		return "", nil
+	}
+	writer.Lock()
+	logDir := writer.dir
+	writer.Unlock()
+	if logDir == "" {
+		return "", nil
+	}
+
+	r.mu.Lock()
+	now := r.now()
+	if !r.lastDump.IsZero() && now.Sub(r.lastDump) < dumpCooldown {
+		r.mu.Unlock()
+		return "", nil
+	}
+	r.lastDump = now
+	r.evict(limits, now)
+	frames := slices.Clone(r.frames)
+	trace := slices.Clone(r.trace)
+	r.mu.Unlock()
+
+	dir := filepath.Join(logDir, recordingsDir)
+	path := filepath.Join(dir, fmt.Sprintf("%s.%s", logFileName(r.account), now.Format(rotatedTimeFormat)))
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return "", errors.Wrap(err, "failed to create recording directory")
+	}
+	if len(frames) > 0 {
+		var err error
+		if limits.format == "png" {
+			err = writeFrameSequence(path, frames)
+		} else {
+			err = writeGif(filepath.Join(path, gifRecordingName), frames)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	data, err := json.MarshalIndent(struct {
+		Account string       `json:"account"`
+		Time    time.Time    `json:"time"`
+		Reason  string       `json:"reason"`
+		Frames  int          `json:"frames"`
+		Trace   []TraceEntry `json:"trace"`
+	}{r.account, now, config.Redact(reason), len(frames), trace}, "", "  ")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode trace")
+	}
+	if err := os.WriteFile(filepath.Join(path, traceFileName), data, 0644); err != nil {
+		return "", errors.Wrap(err, "failed to write trace")
+	}
+	pruneRecordings(dir, logFileName(r.account), limits.maxDumps)
+	return path, nil
+}
+
+func writeGif(path string, frames []recordedFrame) error {
+	anim := &gif.GIF{}
+	for i, frame := range frames {
+		delay := 0
+		if i+1 < len(frames) {
+			delay = int(frames[i+1].time.Sub(frame.time) / (10 * time.Millisecond))
+		}
+		anim.Image = append(anim.Image, frame.image)
+		anim.Delay = append(anim.Delay, max(delay, 1))
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to create recording")
+	}
+	defer f.Close()
+	if err := gif.EncodeAll(f, anim); err != nil {
+		return errors.Wrap(err, "failed to encode recording")
+	}
+	return nil
+}
+
+func writeFrameSequence(dir string, frames []recordedFrame) error {
+	start := frames[0].time
+	for i, frame := range frames {
+		name := fmt.Sprintf("frame_%03d_%06dms.png", i, frame.time.Sub(start).Milliseconds())
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			return errors.Wrap(err, "failed to create frame")
+		}
+		err = png.Encode(f, frame.image)
+		_ = f.Close()
+		if err != nil {
+			return errors.Wrap(err, "failed to encode frame")
+		}
+	}
+	return nil
+}
+
+// pruneRecordings removes the oldest recordings of an account beyond the given limit
+func pruneRecordings(dir, name string, limit int) {
+	if limit <= 0 {
+		return
+	}
+	matches, err := filepath.Glob(filepath.Join(dir, name+".*"))
+	if err != nil || len(matches) <= limit {
+		return
+	}
+	// Recordings are named by their timestamp, so they sort chronologically
+	sort.Strings(matches)
+	for _, path := range matches[:len(matches)-limit] {
+		_ = os.RemoveAll(path)
+	}
+}
+
+// reduceFrame downscales an image to the given width using nearest-neighbour sampling and maps it onto the web safe
+// palette, which can be indexed directly from the color components
+func reduceFrame(img *image.RGBA, maxWidth int) *image.Paletted {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if maxWidth > 0 && width > maxWidth {
+		height = max(height*maxWidth/width, 1)
+		width = maxWidth
+	}
+	out := image.NewPaletted(image.Rect(0, 0, width, height), palette.WebSafe)
+	for y := 0; y < height; y++ {
+		sy := bounds.Min.Y + y*bounds.Dy()/height
+		for x := 0; x < width; x++ {
+			sx := bounds.Min.X + x*bounds.Dx()/width
+			i := img.PixOffset(sx, sy)
+			r, g, b := img.Pix[i], img.Pix[i+1], img.Pix[i+2]
+			out.Pix[y*out.Stride+x] = uint8(((int(r)+25)/51)*36 + ((int(g)+25)/51)*6 + (int(b)+25)/51)
+		}
+	}
+	return out
+}
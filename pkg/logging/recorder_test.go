@@ -0,0 +1,129 @@
+package logging
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestRecorder(t *testing.T, limits recorderLimits) (*FlightRecorder, *time.Time) {
+	useTestWriter(t, logLimits{})
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local)
+	recorder := NewFlightRecorder("Main Alt")
+	recorder.limits = func() recorderLimits { return limits }
+	recorder.now = func() time.Time { return now }
+	return recorder, &now
+}
+
+func testFrame(width, height int, c color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	return img
+}
+
+func TestFlightRecorder_Frames(t *testing.T) {
+	recorder, now := newTestRecorder(t, recorderLimits{
+		enabled:   true,
+		duration:  4500 * time.Millisecond,
+		interval:  500 * time.Millisecond,
+		maxWidth:  100,
+		maxMemory: 100 * 50 * 20,
+	})
+	frame := testFrame(400, 200, color.RGBA{R: 255, A: 255})
+	for i := 0; i < 40; i++ {
+		recorder.AddFrame(frame)
+		*now = now.Add(250 * time.Millisecond)
+	}
+	// Frames are sampled at the frame rate and limited to the duration
+	assert.Len(t, recorder.frames, 10)
+	assert.Equal(t, 100*50*10, recorder.memory)
+	reduced := recorder.frames[0].image
+	assert.Equal(t, image.Rect(0, 0, 100, 50), reduced.Bounds())
+	assert.Equal(t, palette.WebSafe[5*36], reduced.At(10, 10))
+
+	// Memory is bounded regardless of age
+	recorder.limits = func() recorderLimits {
+		return recorderLimits{enabled: true, duration: time.Minute, maxWidth: 100, maxMemory: 100 * 50 * 4}
+	}
+	recorder.AddFrame(frame)
+	assert.Len(t, recorder.frames, 4)
+	assert.Equal(t, 100*50*4, recorder.memory)
+
+	recorder.Reset()
+	assert.Empty(t, recorder.frames)
+}
+
+func TestFlightRecorder_Dump(t *testing.T) {
+	limits := recorderLimits{enabled: true, duration: 10 * time.Second, interval: time.Second, maxWidth: 64, maxDumps: 2}
+	recorder, now := newTestRecorder(t, limits)
+	colors := []color.RGBA{{R: 255, A: 255}, {G: 255, A: 255}, {B: 255, A: 255}}
+	for _, c := range colors {
+		recorder.AddFrame(testFrame(128, 128, c))
+		recorder.Trace([]string{"ClaimHive", "Main"}, "*actions.LogicAction")
+		*now = now.Add(time.Second)
+	}
+	recorder.Trace([]string{"ClaimHive", "Main"}, "error: Failed to claim hive!")
+
+	path, err := recorder.Dump("Failed to claim hive!")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(writer.dir, "recordings", "Main_Alt.20240101T120003"), path)
+
+	f, err := os.Open(filepath.Join(path, "recording.gif"))
+	assert.NoError(t, err)
+	anim, err := gif.DecodeAll(f)
+	_ = f.Close()
+	assert.NoError(t, err)
+	assert.Len(t, anim.Image, 3)
+	assert.Equal(t, []int{100, 100, 1}, anim.Delay)
+	assert.Equal(t, 64, anim.Image[0].Bounds().Dx())
+
+	var trace struct {
+		Reason string       `json:"reason"`
+		Frames int          `json:"frames"`
+		Trace  []TraceEntry `json:"trace"`
+	}
+	data, err := os.ReadFile(filepath.Join(path, "trace.json"))
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(data, &trace))
+	assert.Equal(t, "Failed to claim hive!", trace.Reason)
+	assert.Equal(t, 3, trace.Frames)
+	assert.Len(t, trace.Trace, 4)
+	assert.Equal(t, []string{"ClaimHive", "Main"}, trace.Trace[3].Stack)
+
+	// Errors logged in quick succession share a recording
+	path, err = recorder.Dump("Failed to claim hive!")
+	assert.NoError(t, err)
+	assert.Empty(t, path)
+
+	// Recordings beyond the limit are removed, and the png format writes a frame sequence
+	limits.format = "png"
+	recorder.limits = func() recorderLimits { return limits }
+	for i := 0; i < 2; i++ {
+		*now = now.Add(time.Minute)
+		recorder.AddFrame(testFrame(128, 128, colors[i]))
+		path, err = recorder.Dump("BSS load timeout exceeded!")
+		assert.NoError(t, err)
+	}
+	matches, _ := filepath.Glob(filepath.Join(writer.dir, "recordings", "*"))
+	assert.Len(t, matches, 2)
+	frames, _ := filepath.Glob(filepath.Join(path, "frame_*.png"))
+	assert.Len(t, frames, 1)
+}
+
+func TestFlightRecorder_Disabled(t *testing.T) {
+	recorder, _ := newTestRecorder(t, recorderLimits{})
+	recorder.AddFrame(testFrame(16, 16, color.RGBA{A: 255}))
+	assert.Empty(t, recorder.frames)
+	path, err := recorder.Dump("error")
+	assert.NoError(t, err)
+	assert.Empty(t, path)
+}
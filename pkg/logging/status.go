@@ -1 +1,267 @@
 package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/nosyliam/revolution/pkg/config"
+	"github.com/pkg/errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Statuses which are counted in session reports
+const vicKillStatus = "Vicious Bee defeated"
+
+const (
+	maxStatusHistory = 500
+	maxReportErrors  = 20
+	maxEmbedLength   = 4096
+)
+
+// StatusEvent is a status transition
+type StatusEvent struct {
+	Time   time.Time `json:"time"`
+	Status string    `json:"status"`
+}
+
+// ErrorEvent is an error reported during a session
+type ErrorEvent struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// RoutineStats is the time spent in a routine and the number of times it was entered and completed
+type RoutineStats struct {
+	Name      string        `json:"name"`
+	Duration  time.Duration `json:"duration"`
+	Entered   int           `json:"entered"`
+	Completed int           `json:"completed"`
+}
+
+type routineFrame struct {
+	name    string
+	entered time.Time
+}
+
+// StatusHistory records the status transitions, routines and errors of a macro session
+type StatusHistory struct {
+	mu       sync.Mutex
+	account  string
+	started  time.Time
+	stopped  time.Time
+	statuses []StatusEvent
+	changes  int
+	routines map[string]*RoutineStats
+	stack    []routineFrame
+	errors   []ErrorEvent
+	errCount int
+
+	reconnects int
+	vicKills   int
+
+	now func() time.Time
+}
+
+func NewStatusHistory(account string) *StatusHistory {
+	h := &StatusHistory{account: account, routines: make(map[string]*RoutineStats), now: time.Now}
+	h.started = h.now()
+	return h
+}
+
+// Record records a status transition. Repeated statuses are ignored.
+func (h *StatusHistory) Record(status string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.statuses) > 0 && h.statuses[len(h.statuses)-1].Status == status {
+		return
+	}
+	h.changes++
+	h.statuses = append(h.statuses, StatusEvent{Time: h.now(), Status: status})
+	if len(h.statuses) > maxStatusHistory {
+		h.statuses = h.statuses[len(h.statuses)-maxStatusHistory:]
+	}
+	if status == vicKillStatus {
+		h.vicKills++
+	}
+}
+
+// Error records an error
+func (h *StatusHistory) Error(message string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.errCount++
+	h.errors = append(h.errors, ErrorEvent{Time: h.now(), Message: message})
+	if len(h.errors) > maxReportErrors {
+		h.errors = h.errors[len(h.errors)-maxReportErrors:]
+	}
+}
+
+func (h *StatusHistory) routine(name string) *RoutineStats {
+	stats, ok := h.routines[name]
+	if !ok {
+		stats = &RoutineStats{Name: name}
+		h.routines[name] = stats
+	}
+	return stats
+}
+
+// Reconnect records that Roblox is being re-opened after the window was lost. Server hops open Roblox as well, but
+// are not reconnects.
+func (h *StatusHistory) Reconnect() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.reconnects++
+}
+
+// EnterRoutine records the start of a routine
+func (h *StatusHistory) EnterRoutine(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.routine(name).Entered++
+	h.stack = append(h.stack, routineFrame{name: name, entered: h.now()})
+}
+
+// ExitRoutine records the end of the innermost routine with the given name
+func (h *StatusHistory) ExitRoutine(name string, completed bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i := len(h.stack) - 1; i >= 0; i-- {
+		if h.stack[i].name != name {
+			continue
+		}
+		stats := h.routine(name)
+		stats.Duration += h.now().Sub(h.stack[i].entered)
+		if completed {
+			stats.Completed++
+		}
+		h.stack = append(h.stack[:i], h.stack[i+1:]...)
+		return
+	}
+}
+
+// Stop marks the end of the session. Routines which are still running are closed.
+func (h *StatusHistory) Stop() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.stopped.IsZero() {
+		return
+	}
+	h.stopped = h.now()
+	for _, frame := range h.stack {
+		h.routine(frame.name).Duration += h.stopped.Sub(frame.entered)
+	}
+	h.stack = nil
+}
+
+// Statuses returns the recorded status transitions, oldest first
+func (h *StatusHistory) Statuses() []StatusEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]StatusEvent(nil), h.statuses...)
+}
+
+// SessionReport summarizes a macro session
+type SessionReport struct {
+	Account       string         `json:"account"`
+	Started       time.Time      `json:"started"`
+	Ended         time.Time      `json:"ended,omitempty"`
+	Uptime        time.Duration  `json:"uptime"`
+	StatusChanges int            `json:"statusChanges"`
+	LastStatus    string         `json:"lastStatus,omitempty"`
+	Reconnects    int            `json:"reconnects"`
+	VicKills      int            `json:"vicKills"`
+	ErrorCount    int            `json:"errorCount"`
+	Errors        []ErrorEvent   `json:"errors,omitempty"`
+	Routines      []RoutineStats `json:"routines,omitempty"`
+}
+
+// Report produces a report of the session so far, or of the whole session if it has stopped
+func (h *StatusHistory) Report() *SessionReport {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	end := h.stopped
+	now := end
+	if now.IsZero() {
+		now = h.now()
+	}
+	report := &SessionReport{
+		Account:       h.account,
+		Started:       h.started,
+		Ended:         end,
+		Uptime:        now.Sub(h.started),
+		StatusChanges: h.changes,
+		Reconnects:    h.reconnects,
+		VicKills:      h.vicKills,
+		ErrorCount:    h.errCount,
+		Errors:        append([]ErrorEvent(nil), h.errors...),
+	}
+	if len(h.statuses) > 0 {
+		report.LastStatus = h.statuses[len(h.statuses)-1].Status
+	}
+	for _, stats := range h.routines {
+		routine := *stats
+		// Include the time spent in routines which are still running
+		for _, frame := range h.stack {
+			if frame.name == routine.Name {
+				routine.Duration += now.Sub(frame.entered)
+			}
+		}
+		report.Routines = append(report.Routines, routine)
+	}
+	sort.Slice(report.Routines, func(i, j int) bool {
+		if report.Routines[i].Duration == report.Routines[j].Duration {
+			return report.Routines[i].Name < report.Routines[j].Name
+		}
+		return report.Routines[i].Duration > report.Routines[j].Duration
+	})
+	return report
+}
+
+// JSON encodes the report as indented JSON. Durations are encoded in nanoseconds.
+func (r *SessionReport) JSON() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+
+// Markdown formats the report for display in the console or on Discord
+func (r *SessionReport) Markdown() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "**Session report: %s**\n", r.Account)
+	fmt.Fprintf(&sb, "Started: %s\n", r.Started.Format(time.DateTime))
+	if !r.Ended.IsZero() {
+		fmt.Fprintf(&sb, "Ended: %s\n", r.Ended.Format(time.DateTime))
+	}
+	fmt.Fprintf(&sb, "Uptime: %s\n", r.Uptime.Round(time.Second))
+	fmt.Fprintf(&sb, "Reconnects: %d\n", r.Reconnects)
+	fmt.Fprintf(&sb, "Vicious bees killed: %d\n", r.VicKills)
+	fmt.Fprintf(&sb, "Errors: %d\n", r.ErrorCount)
+	if r.LastStatus != "" {
+		fmt.Fprintf(&sb, "Last status: %s\n", r.LastStatus)
+	}
+	if len(r.Routines) > 0 {
+		sb.WriteString("\n**Routines**\n")
+		for _, routine := range r.Routines {
+			fmt.Fprintf(&sb, "- %s: %s (%d runs, %d completed)\n", routine.Name, routine.Duration.Round(time.Second), routine.Entered, routine.Completed)
+		}
+	}
+	if len(r.Errors) > 0 {
+		sb.WriteString("\n**Recent errors**\n")
+		for _, err := range r.Errors {
+			fmt.Fprintf(&sb, "- %s: %s\n", err.Time.Format(time.TimeOnly), err.Message)
+		}
+	}
+	return sb.String()
+}
+
+// PostReport sends the report to the preset's webhook
+func PostReport(settings *config.Settings, report *SessionReport) error {
+	if discordSettings(settings) == nil {
+		return errors.New("discord logging is not enabled")
+	}
+	message := report.Markdown()
+	if len(message) > maxEmbedLength {
+		message = message[:maxEmbedLength-3] + "..."
+	}
+	return LogDiscord(settings, Success, message)
+}
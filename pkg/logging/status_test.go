@@ -0,0 +1,152 @@
+package logging
+
+import (
+	"encoding/json"
+	"github.com/nosyliam/revolution/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestHistory() (*StatusHistory, *time.Time) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local)
+	history := NewStatusHistory("Main Alt")
+	history.now = func() time.Time { return now }
+	history.started = now
+	return history, &now
+}
+
+func TestStatusHistory_Report(t *testing.T) {
+	history, now := newTestHistory()
+	advance := func(d time.Duration) { *now = now.Add(d) }
+
+	history.EnterRoutine("Main")
+	history.EnterRoutine("OpenRoblox")
+	history.Record("Opening Roblox")
+	history.ExitRoutine("OpenRoblox", true)
+	history.EnterRoutine("KillVic")
+	advance(5 * time.Minute)
+	history.Record("Vicious Bee defeated")
+	history.Record("Vicious Bee defeated")
+	history.ExitRoutine("KillVic", false)
+	history.Reconnect()
+	history.EnterRoutine("OpenRoblox")
+	history.Record("Opening Roblox")
+	history.Record("Disconnected during reconnect")
+	history.Record("Disconnected during reconnect!")
+	advance(time.Minute)
+	history.Record("Game Loaded")
+	history.ExitRoutine("OpenRoblox", true)
+	history.Error("Failed to capture Roblox!")
+	history.EnterRoutine("KillVic")
+	advance(2 * time.Minute)
+
+	// Running routines are included in reports taken on demand
+	report := history.Report()
+	assert.Equal(t, 8*time.Minute, report.Uptime)
+	assert.True(t, report.Ended.IsZero())
+	assert.Equal(t, 6, report.StatusChanges, "repeated statuses are ignored")
+	assert.Equal(t, "Game Loaded", report.LastStatus)
+	assert.Equal(t, 1, report.VicKills)
+	assert.Equal(t, 1, report.Reconnects, "failed reconnect attempts are not reconnects")
+	assert.Equal(t, 1, report.ErrorCount)
+	assert.Equal(t, []RoutineStats{
+		{Name: "Main", Duration: 8 * time.Minute, Entered: 1},
+		{Name: "KillVic", Duration: 7 * time.Minute, Entered: 2},
+		{Name: "OpenRoblox", Duration: time.Minute, Entered: 2, Completed: 2},
+	}, report.Routines)
+
+	advance(time.Minute)
+	history.Stop()
+	advance(time.Hour)
+	report = history.Report()
+	assert.Equal(t, 9*time.Minute, report.Uptime, "stopped sessions do not accumulate time")
+	assert.Equal(t, 9*time.Minute, report.Routines[0].Duration)
+	assert.False(t, report.Ended.IsZero())
+
+	data, err := report.JSON()
+	assert.NoError(t, err)
+	var decoded SessionReport
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, report.Routines, decoded.Routines)
+	assert.Equal(t, report.Errors[0].Message, decoded.Errors[0].Message)
+
+	markdown := report.Markdown()
+	assert.Contains(t, markdown, "**Session report: Main Alt**")
+	assert.Contains(t, markdown, "Uptime: 9m0s")
+	assert.Contains(t, markdown, "Vicious bees killed: 1")
+	assert.Contains(t, markdown, "- KillVic: 8m0s (2 runs, 0 completed)")
+	assert.Contains(t, markdown, "Failed to capture Roblox!")
+}
+
+func TestStatusHistory_ServerHops(t *testing.T) {
+	history, _ := newTestHistory()
+	history.EnterRoutine("Main")
+	history.EnterRoutine("OpenRoblox")
+	history.ExitRoutine("OpenRoblox", true)
+	// Vic hop redirects into OpenRoblox to join a new server
+	for i := 0; i < 3; i++ {
+		history.EnterRoutine("VicSearch")
+		history.ExitRoutine("VicSearch", false)
+		history.EnterRoutine("OpenRoblox")
+		history.Record("Opening Roblox")
+		history.Record("Game Loaded")
+		history.ExitRoutine("OpenRoblox", true)
+	}
+	report := history.Report()
+	assert.Zero(t, report.Reconnects, "server hops are not reconnects")
+	assert.Equal(t, 4, report.Routines[1].Entered)
+
+	history.Reconnect()
+	assert.Equal(t, 1, history.Report().Reconnects)
+}
+
+func TestStatusHistory_Bounded(t *testing.T) {
+	history, now := newTestHistory()
+	for i := 0; i < maxStatusHistory+10; i++ {
+		*now = now.Add(time.Second)
+		history.Record(strings.Repeat("a", i%2+1))
+		history.Error("error")
+	}
+	assert.Len(t, history.Statuses(), maxStatusHistory)
+	report := history.Report()
+	assert.Equal(t, maxStatusHistory+10, report.StatusChanges)
+	assert.Equal(t, maxStatusHistory+10, report.ErrorCount)
+	assert.Len(t, report.Errors, maxReportErrors)
+}
+
+func TestPostReport(t *testing.T) {
+	cwd, _ := os.Getwd()
+	assert.NoError(t, os.Chdir(t.TempDir()))
+	defer os.Chdir(cwd)
+	webhook, url := newFakeWebhook(t)
+	old := discord
+	discord = NewDiscordClient(http.DefaultClient)
+	defer func() { discord = old }()
+
+	history, _ := newTestHistory()
+	for i := 0; i < maxReportErrors; i++ {
+		history.Error(strings.Repeat("x", 500))
+	}
+	report := history.Report()
+	settings := config.Settings{}
+	assert.Error(t, PostReport(&settings, report), "discord is disabled")
+
+	cfg, err := config.NewConfig(config.NewOfflineRuntime())
+	assert.NoError(t, err)
+	preset := *config.Concrete[*config.Object[config.Settings]](cfg, "presets[Default]")
+	assert.NoError(t, preset.SetPath("discord.enabled", true))
+	assert.NoError(t, preset.SetPath("discord.webhookUrl", url))
+	settings = preset.Object()
+	assert.NoError(t, PostReport(&settings, report))
+	assert.True(t, FlushDiscord(time.Second))
+
+	assert.Len(t, webhook.requests, 1)
+	description := webhook.requests[0].payload.Embeds[0].Description
+	assert.Len(t, description, maxEmbedLength)
+	assert.True(t, strings.HasPrefix(description, "**Session report: Main Alt**"))
+	assert.Equal(t, LogColors[Success], webhook.requests[0].payload.Embeds[0].Color)
+}
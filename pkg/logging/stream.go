@@ -0,0 +1,39 @@
+package logging
+
+import "sync"
+
+type entryStream struct {
+	sync.Mutex
+	listeners map[chan Entry]struct{}
+}
+
+var stream = &entryStream{listeners: make(map[chan Entry]struct{})}
+
+// Listen returns a channel which receives every log and console entry, along with a function which stops listening.
+// Entries are dropped if the listener falls more than size entries behind.
+func Listen(size int) (<-chan Entry, func()) {
+	ch := make(chan Entry, size)
+	stream.Lock()
+	stream.listeners[ch] = struct{}{}
+	stream.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			stream.Lock()
+			delete(stream.listeners, ch)
+			stream.Unlock()
+			close(ch)
+		})
+	}
+}
+
+func publish(entry Entry) {
+	stream.Lock()
+	defer stream.Unlock()
+	for ch := range stream.listeners {
+		select {
+		case ch <- entry:
+		default:
+		}
+	}
+}
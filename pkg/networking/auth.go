@@ -0,0 +1,170 @@
+package networking
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	. "github.com/nosyliam/revolution/pkg/common"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/argon2"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Clients register with a relay using either the network key shared by every paired account, or a one-time pairing
+// code displayed by the relay:
+//
+//  1. The relay sends a challenge containing a random nonce
+//  2. The client replies with a registration containing its own nonce
+//  3. The relay acknowledges the registration if it is signed correctly. Clients which registered with a pairing code
+//     receive the network key, sealed with the session key.
+//
+// Every message exchanged after the challenge, including the registration, is signed with a session key derived from
+// the secret and both nonces. The secret itself is never sent over the network.
+//
+// Pairing codes are short enough to be typed, so the secret used to pair is not the code itself but a key derived from
+// it with a memory-hard function and a salt sent in the challenge. Otherwise the code, and with it the network key,
+// could be guessed offline from a captured handshake.
+
+const (
+	networkKeySize     = 32
+	nonceSize          = 16
+	pairingCodeLength  = 8
+	maxPairingAttempts = 5
+
+	// Ambiguous characters are excluded so that codes can be read off the screen of another computer
+	pairingAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+	// Argon2id parameters of the pairing key, as recommended by OWASP
+	pairingKeyTime    = 2
+	pairingKeyMemory  = 19 * 1024
+	pairingKeyThreads = 1
+)
+
+var (
+	InvalidSignatureError = errors.New("invalid message signature")
+	ReplayedMessageError  = errors.New("replayed message")
+)
+
+func randomHex(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", errors.Wrap(err, "failed to generate random bytes")
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func generateNetworkKey() (string, error) {
+	return randomHex(networkKeySize)
+}
+
+func generatePairingCode() (string, error) {
+	var code strings.Builder
+	max := big.NewInt(int64(len(pairingAlphabet)))
+	for i := 0; i < pairingCodeLength; i++ {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to generate pairing code")
+		}
+		code.WriteByte(pairingAlphabet[n.Int64()])
+	}
+	return code.String(), nil
+}
+
+// derivePairingKey derives the secret used to pair from a pairing code and the salt chosen by the relay
+func derivePairingKey(code, salt string) string {
+	key := argon2.IDKey([]byte(code), []byte(salt), pairingKeyTime, pairingKeyMemory, pairingKeyThreads, networkKeySize)
+	return hex.EncodeToString(key)
+}
+
+// pairingKey is the secret of a pairing code. The relay derives it once, when it is first needed, and without being
+// locked so that forwarding is not delayed.
+type pairingKey struct {
+	salt string
+	code string
+	once sync.Once
+	key  string
+}
+
+func newPairingKey(code string) (*pairingKey, error) {
+	salt, err := randomHex(nonceSize)
+	if err != nil {
+		return nil, err
+	}
+	return &pairingKey{salt: salt, code: code}, nil
+}
+
+func (p *pairingKey) derive() string {
+	p.once.Do(func() { p.key = derivePairingKey(p.code, p.salt) })
+	return p.key
+}
+
+// normalizePairingCode strips the separators and case a user may have added when typing a code
+func normalizePairingCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer(" ", "", "-", "").Replace(code))
+}
+
+func keyedHash(secret []byte, parts ...string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strings.Join(parts, "\x00")))
+	return mac.Sum(nil)
+}
+
+// session signs and verifies the messages sent over a single connection. Sequence numbers must increase
+// monotonically, which prevents captured messages from being replayed.
+type session struct {
+	key      []byte
+	sent     uint64
+	received uint64
+}
+
+func newSession(secret, relayNonce, clientNonce string) *session {
+	return &session{key: keyedHash([]byte(secret), "session", relayNonce, clientNonce)}
+}
+
+func (s *session) signature(message *Message) string {
+	mac := hmac.New(sha256.New, s.key)
+	_, _ = fmt.Fprintf(mac, "%d\x00%d\x00%s\x00%s\x00%s\x00%s\x00%s\x00", message.Kind, message.Sequence, message.Sender,
+		message.Receiver, message.ID, message.Time.UTC().Format(time.RFC3339Nano), strings.Join(message.Route, "\x01"))
+	mac.Write([]byte(message.Content))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *session) sign(message *Message) {
+	s.sent++
+	message.Sequence = s.sent
+	message.Signature = s.signature(message)
+}
+
+func (s *session) verify(message *Message) error {
+	if message.Signature == "" || !hmac.Equal([]byte(message.Signature), []byte(s.signature(message))) {
+		return InvalidSignatureError
+	}
+	if message.Sequence <= s.received {
+		return ReplayedMessageError
+	}
+	s.received = message.Sequence
+	return nil
+}
+
+// seal encrypts the network key with a keystream derived from the session key
+func (s *session) seal(key string) (string, error) {
+	data, err := hex.DecodeString(key)
+	if err != nil || len(data) != networkKeySize {
+		return "", errors.New("invalid network key")
+	}
+	stream := keyedHash(s.key, "seal")
+	for i := range data {
+		data[i] ^= stream[i]
+	}
+	return hex.EncodeToString(data), nil
+}
+
+func (s *session) open(sealed string) (string, error) {
+	// The keystream is symmetric
+	return s.seal(sealed)
+}
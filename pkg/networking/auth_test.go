@@ -0,0 +1,220 @@
+package networking
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	. "github.com/nosyliam/revolution/pkg/common"
+	"github.com/nosyliam/revolution/pkg/config"
+	"github.com/nosyliam/revolution/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSession(t *testing.T) {
+	sender, receiver := newSession("secret", "a", "b"), newSession("secret", "a", "b")
+	message := Message{Kind: VicDetectMessageKind, Sender: "alt", Receiver: MainReceiver, Content: `{"Field":"Rose"}`}
+	assert.ErrorIs(t, receiver.verify(&message), InvalidSignatureError, "unsigned messages are rejected")
+
+	sender.sign(&message)
+	replayed := message
+	assert.NoError(t, receiver.verify(&message))
+	assert.ErrorIs(t, receiver.verify(&replayed), ReplayedMessageError)
+
+	sender.sign(&message)
+	message.Content = `{"Field":"Pepper"}`
+	assert.ErrorIs(t, receiver.verify(&message), InvalidSignatureError, "modified messages are rejected")
+	sender.sign(&message)
+	assert.ErrorIs(t, newSession("secret", "a", "c").verify(&message), InvalidSignatureError, "sessions are bound to their nonces")
+
+	key, err := generateNetworkKey()
+	assert.NoError(t, err)
+	sealed, err := sender.seal(key)
+	assert.NoError(t, err)
+	assert.NotEqual(t, key, sealed)
+	opened, err := receiver.open(sealed)
+	assert.NoError(t, err)
+	assert.Equal(t, key, opened)
+
+	code, err := generatePairingCode()
+	assert.NoError(t, err)
+	assert.Len(t, code, pairingCodeLength)
+	assert.Equal(t, "ABCD2345", normalizePairingCode("abcd-2345 "))
+
+	pairingKey := derivePairingKey(code, "salt")
+	assert.Len(t, pairingKey, networkKeySize*2)
+	assert.Equal(t, pairingKey, derivePairingKey(code, "salt"))
+	assert.NotEqual(t, pairingKey, derivePairingKey(code, "pepper"), "pairing keys are salted")
+}
+
+type testNetwork struct {
+	t       *testing.T
+	state   *config.Object[config.State]
+	relay   *Relay
+	address string
+}
+
+// newTestNetwork starts a relay without advertising it over zeroconf
+
+func newTestNetwork(t *testing.T) *testNetwork {
+	cwd, _ := os.Getwd()
+	assert.NoError(t, os.Chdir(t.TempDir()))
+	t.Cleanup(func() { _ = os.Chdir(cwd) })
+
+	state, err := config.NewState(config.NewOfflineRuntime())
+	assert.NoError(t, err)
+	network := &testNetwork{t: t, state: state}
+	network.relay, network.address = network.startRelay("Relay")
+	return network
+}
+
+// startRelay starts another relay sharing the network's state, returning its address
+func (n *testNetwork) startRelay(name string) (*Relay, string) {
+	relay := NewRelay(nil, n.account(name), logging.NewLogger(name, nil))
+	_, err := relay.networkKey()
+	assert.NoError(n.t, err)
+	assert.NoError(n.t, relay.RefreshPairingCode())
+	cert, err := loadCertificate(relay.state)
+	assert.NoError(n.t, err)
+	relay.fingerprint = Fingerprint(cert.Certificate[0])
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", serverTLSConfig(cert))
+	assert.NoError(n.t, err)
+	n.t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go relay.handleConnection(conn)
+		}
+	}()
+	return relay, listener.Addr().String()
+}
+
+func (n *testNetwork) account(name string) *config.Object[config.MacroState] {
+	assert.NoError(n.t, n.state.AppendPath(fmt.Sprintf("macros[%s]", name)))
+	return n.state.Object().Macros.Lookup(name)
+}
+
+// pairedAccount creates an account which already holds the network key, so that it connects without the delay of
+// deriving a pairing key
+func (n *testNetwork) pairedAccount(name string) *config.Object[config.MacroState] {
+	state := n.account(name)
+	assert.NoError(n.t, state.SetPath("networking.networkKey", n.relay.state.Object().Networking.Object().NetworkKey))
+	return state
+}
+
+// connect dials the relay and registers the client, returning the codec for the following messages
+func (n *testNetwork) connect(client *Client, code string) (*codec, error) {
+	return n.connectTo(n.relay, n.address, client, code)
+}
+
+func (n *testNetwork) connectTo(relay *Relay, address string, client *Client, code string) (*codec, error) {
+	client.mu.Lock()
+	client.pairingCode = code
+	client.mu.Unlock()
+	assert.NoError(n.t, client.connect(address, relay.fingerprint))
+	n.t.Cleanup(client.Disconnect)
+	stream, err := client.register()
+	if err != nil {
+		client.Disconnect()
+	}
+	return stream, err
+}
+
+func (n *testNetwork) connected(identity string) bool {
+	n.relay.mu.Lock()
+	defer n.relay.mu.Unlock()
+	_, ok := n.relay.identities[identity]
+	return ok
+}
+
+func TestRelay_Pairing(t *testing.T) {
+	network := newTestNetwork(t)
+	state := network.account("Alt")
+	client := NewClient(state, logging.NewLogger("Alt", nil))
+
+	_, err := network.connect(client, "")
+	assert.ErrorContains(t, err, "has not been paired")
+	_, err = network.connect(client, "WRONGCODE")
+	assert.ErrorContains(t, err, "Invalid pairing code")
+
+	code := network.relay.pairingCode
+	assert.Equal(t, code, *config.Concrete[string](network.relay.state, "networking.pairingCode"), "the code is shown in the UI")
+	_, err = network.connect(client, code)
+	assert.NoError(t, err)
+	assert.Equal(t, network.relay.state.Object().Networking.Object().NetworkKey, state.Object().Networking.Object().NetworkKey)
+	assert.NotEqual(t, code, network.relay.pairingCode, "pairing codes may only be used once")
+	assert.Eventually(t, func() bool { return network.connected(client.Identity()) }, time.Second, 10*time.Millisecond)
+
+	// Once paired, the network key is used to register
+	client.Disconnect()
+	assert.Eventually(t, func() bool { return !network.connected(client.Identity()) }, time.Second, 10*time.Millisecond)
+	_, err = network.connect(client, "")
+	assert.NoError(t, err)
+
+	other := NewClient(network.account("Other"), logging.NewLogger("Other", nil))
+	_, err = network.connect(other, code)
+	assert.ErrorContains(t, err, "Invalid pairing code", "used codes are rejected")
+}
+
+func TestRelay_PairingAttempts(t *testing.T) {
+	network := newTestNetwork(t)
+	client := NewClient(network.account("Alt"), logging.NewLogger("Alt", nil))
+	code := network.relay.pairingCode
+	for i := 0; i < maxPairingAttempts; i++ {
+		_, err := network.connect(client, "WRONGCODE")
+		assert.Error(t, err)
+	}
+	assert.NotEqual(t, code, network.relay.pairingCode, "the code is replaced after too many failed attempts")
+}
+
+func TestRelay_RejectsUnsignedClients(t *testing.T) {
+	network := newTestNetwork(t)
+
+	conn, err := tls.Dial("tcp", network.address, clientTLSConfig(network.relay.fingerprint, nil))
+	assert.NoError(t, err)
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	_, err = readMessage(reader, ChallengeMessageKind)
+	assert.NoError(t, err)
+	data, _ := json.Marshal(RegistrationMessage{Identity: "Intruder", Nonce: "nonce"})
+	assert.NoError(t, writeMessage(conn, &Message{Kind: RegistrationMessageKind, Sender: "Intruder", Receiver: RelayReceiver, Content: string(data)}))
+	message, err := readMessage(reader, AckRegistrationMessageKind)
+	assert.NoError(t, err)
+	var ack AckRegistrationMessage
+	assert.NoError(t, json.Unmarshal([]byte(message.Content), &ack))
+	assert.Contains(t, ack.Error, "Invalid network key")
+	assert.False(t, network.connected("Intruder"))
+
+	// Registered clients are disconnected when they send an unsigned or forged message
+	for i, forged := range []struct {
+		signed bool
+		sender string
+	}{
+		{signed: false},
+		{signed: true, sender: getIdentity() + "/Relay"},
+	} {
+		client := NewClient(network.account(fmt.Sprintf("Alt%d", i)), logging.NewLogger("Alt", nil))
+		_, err = network.connect(client, network.relay.pairingCode)
+		assert.NoError(t, err)
+		assert.True(t, network.connected(client.Identity()))
+		sender := client.Identity()
+		if forged.sender != "" {
+			sender = forged.sender
+		}
+		data, _ = json.Marshal(VicDetectMessage{Field: "Rose"})
+		message := &Message{Kind: VicDetectMessageKind, Sender: sender, Receiver: BroadcastReceiver, Content: string(data)}
+		if forged.signed {
+			client.session.sign(message)
+		}
+		assert.NoError(t, client.codec.write(message))
+		assert.Eventually(t, func() bool { return !network.connected(client.Identity()) }, time.Second, 10*time.Millisecond)
+		client.Disconnect()
+	}
+}
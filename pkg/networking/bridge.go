@@ -0,0 +1,382 @@
+package networking
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	. "github.com/nosyliam/revolution/pkg/common"
+	"github.com/nosyliam/revolution/pkg/config"
+	"github.com/nosyliam/revolution/pkg/logging"
+	"github.com/pkg/errors"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Zeroconf does not cross networks, so a relay can bridge its network to an upstream relay on another network. The
+// bridge registers with the upstream relay like any other client, and both relays forward broadcasts and role
+// messages over it. Identities on the other side of a bridge are namespaced with the identity of the relay they were
+// bridged from, e.g. Host/User/Alt@Host/User/Relay, so that direct messages can be routed back over the bridge.
+//
+// Every relay a message is bridged through is appended to its route. Messages which have already passed through a
+// relay or have been bridged too many times are dropped, which prevents loops between misconfigured relays.
+
+const (
+	namespaceSeparator = "@"
+	maxBridgeHops      = 4
+)
+
+func qualifyIdentity(identity, relay string) string {
+	return identity + namespaceSeparator + relay
+}
+
+// splitIdentity returns the identity of a bridged client and the relay it was bridged from
+func splitIdentity(identity string) (string, string, bool) {
+	i := strings.LastIndex(identity, namespaceSeparator)
+	if i <= 0 || i == len(identity)-1 {
+		return "", "", false
+	}
+	return identity[:i], identity[i+1:], true
+}
+
+// Bridge connects the relay to an upstream relay. The upstream relay's pairing code is required the first time, after
+// which the network key it shared is used. An empty address removes the bridge.
+func (r *Relay) Bridge(address, code string) error {
+	r.mu.Lock()
+	if r.upstream != nil {
+		r.upstream.closeLocked()
+		r.upstream = nil
+	}
+	r.mu.Unlock()
+
+	if address != r.state.Object().Networking.Object().Upstream {
+		_ = r.state.SetPath("networking.upstreamKey", "")
+		_ = r.state.SetPath("networking.upstreamFingerprint", "")
+		if err := r.state.SetPath("networking.upstream", address); err != nil {
+			return errors.Wrap(err, "failed to save upstream relay")
+		}
+	}
+	if address == "" {
+		return nil
+	}
+
+	upstream := newBridge(r, address, normalizePairingCode(code))
+	r.mu.Lock()
+	r.upstream = upstream
+	r.mu.Unlock()
+	if err := upstream.connect(); err != nil {
+		r.mu.Lock()
+		if r.upstream == upstream {
+			upstream.closeLocked()
+			r.upstream = nil
+		}
+		r.mu.Unlock()
+		return err
+	}
+	go upstream.run()
+	return nil
+}
+
+// linksLocked returns the identities of the relays bridged with this relay, except for the given relay
+func (r *Relay) linksLocked(exclude string) []string {
+	var links []string
+	for identity, peer := range r.identities {
+		if peer.bridge && identity != exclude {
+			links = append(links, identity)
+		}
+	}
+	if r.upstream != nil && r.upstream.conn != nil && r.upstream.identity != exclude {
+		links = append(links, r.upstream.identity)
+	}
+	return links
+}
+
+func (r *Relay) linkedLocked(relay string) bool {
+	if peer, ok := r.identities[relay]; ok {
+		return peer.bridge
+	}
+	return r.upstream != nil && r.upstream.conn != nil && r.upstream.identity == relay
+}
+
+// remoteLocked returns the identities last shared by a bridged relay
+func (r *Relay) remoteLocked(relay string) *[]config.NetworkIdentity {
+	if peer, ok := r.identities[relay]; ok && peer.bridge {
+		return &peer.remote
+	}
+	if r.upstream != nil && r.upstream.conn != nil && r.upstream.identity == relay {
+		return &r.upstream.remote
+	}
+	return nil
+}
+
+// remoteIdentitiesLocked returns the namespaced identities of every bridged network, except for the given relay's
+func (r *Relay) remoteIdentitiesLocked(exclude string) []config.NetworkIdentity {
+	var identities []config.NetworkIdentity
+	for _, link := range r.linksLocked(exclude) {
+		for _, identity := range *r.remoteLocked(link) {
+			if strings.Count(identity.Identity, namespaceSeparator) >= maxBridgeHops-1 {
+				continue
+			}
+			identity.Identity = qualifyIdentity(identity.Identity, link)
+			identity.Address = qualifyIdentity(identity.Address, link)
+			identities = append(identities, identity)
+		}
+	}
+	return identities
+}
+
+func (r *Relay) handleRemoteIdentities(relay string, message *Message) {
+	remote := r.remoteLocked(relay)
+	if remote == nil {
+		return
+	}
+	var content ConnectedIdentitiesMessage
+	if err := json.Unmarshal([]byte(message.Content), &content); err != nil {
+		r.logger.Log(0, logging.Warning, fmt.Sprintf("[Relay]: Failed to unmarshal identities bridged from relay %s", relay))
+		return
+	}
+	*remote = content.Identities
+	r.sendIdentitiesLocked()
+}
+
+// sendLinkLocked signs a message with the session of a bridged relay and writes it to its connection
+func (r *Relay) sendLinkLocked(relay string, message Message) {
+	if peer, ok := r.identities[relay]; ok && peer.bridge {
+		r.forward(relay, message)
+	} else if r.upstream != nil && r.upstream.identity == relay {
+		r.upstream.writeLocked(message)
+	}
+}
+
+func (r *Relay) sendBridgedLocked(relay string, message Message) {
+	message.Route = append(slices.Clone(message.Route), r.Identity())
+	r.sendLinkLocked(relay, message)
+}
+
+// bridgeLocked forwards a message to every bridged relay except the one it came from
+func (r *Relay) bridgeLocked(origin string, message Message) {
+	if origin == "" {
+		return
+	}
+	for _, link := range r.linksLocked(origin) {
+		r.sendBridgedLocked(link, message)
+	}
+}
+
+// receiveBridgedLocked namespaces the sender of a message bridged from another relay and routes it
+func (r *Relay) receiveBridgedLocked(relay string, message *Message) {
+	if len(message.Route) >= maxBridgeHops || slices.Contains(message.Route, r.Identity()) {
+		r.logger.Log(0, logging.Warning, fmt.Sprintf("[Relay]: Dropped message from %s bridged through %s: loop detected",
+			message.Sender, strings.Join(message.Route, " -> ")))
+		return
+	}
+	message.Sender = qualifyIdentity(message.Sender, relay)
+	r.routeMessage(message, relay)
+}
+
+// bridge is a relay's connection to its upstream relay. Apart from the stop channel, its fields are guarded by the
+// relay's mutex.
+type bridge struct {
+	relay       *Relay
+	address     string
+	pairingCode string
+	stop        chan struct{}
+	closed      bool
+
+	conn     net.Conn
+	codec    *codec
+	outbox   *outbox
+	session  *session
+	identity string
+	remote   []config.NetworkIdentity
+}
+
+func newBridge(relay *Relay, address string, pairingCode string) *bridge {
+	return &bridge{relay: relay, address: address, pairingCode: pairingCode, stop: make(chan struct{})}
+}
+
+func (b *bridge) connected() bool {
+	b.relay.mu.Lock()
+	defer b.relay.mu.Unlock()
+	return b.conn != nil
+}
+
+func (b *bridge) connect() error {
+	r := b.relay
+	networking := r.state.Object().Networking.Object()
+	fingerprint := networking.UpstreamFingerprint
+	var observed string
+	dialer := &net.Dialer{Timeout: handshakeTimeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", b.address, clientTLSConfig(fingerprint, func(actual string) {
+		observed = actual
+	}))
+	if err != nil {
+		return errors.Wrap(err, "failed to connect to upstream relay")
+	}
+	_ = conn.SetDeadline(time.Now().Add(handshakeTimeout))
+
+	// Pairing codes may only be used once, whether or not the registration succeeds
+	r.mu.Lock()
+	secret, pairing := b.pairingCode, b.pairingCode != ""
+	b.pairingCode = ""
+	r.mu.Unlock()
+	if !pairing {
+		secret = networking.UpstreamKey
+	}
+	if secret == "" {
+		conn.Close()
+		return errors.New("this relay has not been paired with the upstream relay. Enter the pairing code shown by the upstream relay")
+	}
+	stream, sess, ack, err := handshake(conn, RegistrationMessage{Identity: r.Identity(), Pairing: pairing, Bridge: true}, secret)
+	if err != nil {
+		conn.Close()
+		return errors.Wrap(err, "failed to register with upstream relay")
+	}
+	if ack.Relay == "" {
+		conn.Close()
+		return errors.New("the upstream relay does not support bridging")
+	}
+	_ = conn.SetDeadline(time.Time{})
+	if pairing {
+		key, err := sess.open(ack.Key)
+		if err == nil {
+			err = r.state.SetPath("networking.upstreamKey", key)
+		}
+		if err != nil {
+			conn.Close()
+			return errors.Wrap(err, "failed to save the upstream relay's network key")
+		}
+	}
+	if fingerprint == "" {
+		_ = r.state.SetPath("networking.upstreamFingerprint", observed)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if b.closed {
+		conn.Close()
+		return InactiveNetworkError
+	}
+	// A second bridge between the same relays would deliver every message twice
+	if peer, ok := r.identities[ack.Relay]; ok && peer.bridge {
+		conn.Close()
+		return errors.New(fmt.Sprintf("the relay %s is already bridged to this relay", ack.Relay))
+	}
+	b.conn, b.codec, b.session, b.identity = conn, stream, sess, ack.Relay
+	b.outbox = newOutbox(conn, stream, func(err error) {
+		r.logger.Log(0, logging.Warning, fmt.Sprintf("[Relay]: Failed to write to upstream relay: %v", err))
+	})
+	r.logger.Log(0, logging.Info, fmt.Sprintf("[Relay]: Bridged network to upstream relay %s", ack.Relay))
+	_ = r.state.SetPath("networking.upstreamConnected", true)
+	r.broadcastIdentitiesLocked()
+	return nil
+}
+
+// run reconnects to the upstream relay with backoff until the bridge is closed
+func (b *bridge) run() {
+	backoff := minReconnectDelay
+	for {
+		select {
+		case <-b.stop:
+			return
+		default:
+		}
+		if b.connected() {
+			b.listen()
+			backoff = minReconnectDelay
+		} else if err := b.connect(); err != nil {
+			b.relay.logger.Log(0, logging.Warning, fmt.Sprintf("[Relay]: Failed to bridge to upstream relay %s: %v", b.address, err))
+			backoff = min(backoff*2, maxReconnectDelay)
+		} else {
+			continue
+		}
+		select {
+		case <-b.stop:
+			return
+		case <-time.After(backoff):
+		}
+	}
+}
+
+func (b *bridge) listen() {
+	r := b.relay
+	r.mu.Lock()
+	conn, stream, sess := b.conn, b.codec, b.session
+	r.mu.Unlock()
+	if conn == nil {
+		return
+	}
+	for {
+		// The upstream relay sends heartbeats regularly, so a silent connection has been lost
+		_ = conn.SetReadDeadline(time.Now().Add(heartbeatTimeout()))
+		message, err := stream.read()
+		if errors.Is(err, MalformedMessageError) {
+			r.logger.Log(0, logging.Warning, fmt.Sprintf("[Relay]: Received invalid message from upstream relay: %v", err))
+			continue
+		} else if err != nil {
+			break
+		}
+		if err := sess.verify(message); err != nil {
+			r.logger.Log(0, logging.Warning, fmt.Sprintf("[Relay]: Disconnecting from upstream relay: %v", err))
+			break
+		}
+		r.mu.Lock()
+		switch {
+		case message.Kind == HeartbeatMessageKind:
+			b.handleHeartbeatLocked(message)
+		case message.Kind == ConnectedIdentitiesMessageKind:
+			r.handleRemoteIdentities(b.identity, message)
+		case message.Receiver != RelayReceiver:
+			r.receiveBridgedLocked(b.identity, message)
+		}
+		r.mu.Unlock()
+	}
+
+	r.mu.Lock()
+	if b.conn == conn {
+		b.outbox.close()
+		b.conn, b.codec, b.outbox, b.session, b.remote = nil, nil, nil, nil, nil
+		r.sendIdentitiesLocked()
+	}
+	r.mu.Unlock()
+	_ = conn.Close()
+	_ = r.state.SetPath("networking.upstreamConnected", false)
+}
+
+func (b *bridge) handleHeartbeatLocked(message *Message) {
+	var content HeartbeatMessage
+	if err := json.Unmarshal([]byte(message.Content), &content); err != nil {
+		b.relay.logger.Log(0, logging.Warning, "[Relay]: Failed to unmarshal heartbeat from upstream relay")
+		return
+	}
+	data, _ := json.Marshal(AckHeartbeatMessage{Sent: content.Sent})
+	b.writeLocked(Message{
+		Kind:     AckHeartbeatMessageKind,
+		Sender:   b.relay.Identity(),
+		Receiver: RelayReceiver,
+		Content:  string(data),
+	})
+}
+
+func (b *bridge) writeLocked(message Message) {
+	if b.conn == nil {
+		return
+	}
+	b.session.sign(&message)
+	if !b.outbox.send(&message) {
+		b.relay.logger.Log(0, logging.Warning, "[Relay]: Disconnecting from upstream relay: too many messages are waiting to be sent")
+		// The listener notices the closed connection and reconnects
+		_ = abort(b.conn)
+	}
+}
+
+func (b *bridge) closeLocked() {
+	if !b.closed {
+		b.closed = true
+		close(b.stop)
+	}
+	if b.conn != nil {
+		_ = b.conn.Close()
+	}
+}
@@ -0,0 +1,107 @@
+package networking
+
+import (
+	"encoding/json"
+	. "github.com/nosyliam/revolution/pkg/common"
+	"github.com/nosyliam/revolution/pkg/config"
+	"github.com/nosyliam/revolution/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRelay_Bridge(t *testing.T) {
+	network := newTestNetwork(t)
+	upstream := network.relay
+	downstream, address := network.startRelay("Downstream")
+	t.Cleanup(func() { _ = downstream.Bridge("", "") })
+
+	mainState := network.account("Main")
+	main := NewClient(mainState, logging.NewLogger("Main", nil))
+	stream, err := network.connect(main, upstream.pairingCode)
+	assert.NoError(t, err)
+	received := main.Subscribe(VicDetectMessageKind)
+	go main.listenForMessages(stream)
+	assert.NoError(t, main.SetRole(MainClientRole))
+
+	alt := NewClient(network.account("Alt"), logging.NewLogger("Alt", nil))
+	stream, err = network.connectTo(downstream, address, alt, downstream.pairingCode)
+	assert.NoError(t, err)
+	go alt.listenForMessages(stream)
+
+	assert.ErrorContains(t, downstream.Bridge(network.address, "WRONGCODE"), "Invalid pairing code")
+	assert.NoError(t, downstream.Bridge(network.address, upstream.pairingCode))
+	networking := downstream.state.Object().Networking.Object()
+	assert.Equal(t, upstream.state.Object().Networking.Object().NetworkKey, networking.UpstreamKey)
+	assert.Equal(t, upstream.fingerprint, networking.UpstreamFingerprint)
+	assert.True(t, networking.UpstreamConnected)
+	upstream.mu.Lock()
+	for _, identity := range upstream.connectedIdentitiesLocked().Identities {
+		assert.NotEqual(t, downstream.Identity(), identity.Identity, "bridges are not listed as identities")
+	}
+	upstream.mu.Unlock()
+
+	// Identities on the other network are namespaced by the relay they were bridged from
+	bridged := qualifyIdentity(alt.Identity(), downstream.Identity())
+	assert.Eventually(t, func() bool {
+		found := false
+		mainState.Object().Networking.Object().ConnectedIdentities.ForEach(func(id *config.NetworkIdentity) {
+			found = found || id.Identity == bridged
+		})
+		return found
+	}, 2*time.Second, 10*time.Millisecond)
+
+	alt.Send(MainReceiver, VicDetectMessage{Field: "Rose"})
+	select {
+	case message := <-received:
+		assert.Equal(t, bridged, message.Sender)
+		assert.Equal(t, []string{downstream.Identity()}, message.Route)
+		var vic VicDetectMessage
+		assert.NoError(t, json.Unmarshal([]byte(message.Content), &vic))
+		assert.Equal(t, "Rose", vic.Field)
+	case <-time.After(2 * time.Second):
+		t.Fatal("the detection was not bridged to the main")
+	}
+	// The acknowledgement is routed back to the namespaced sender
+	assert.Eventually(t, func() bool {
+		alt.mu.Lock()
+		defer alt.mu.Unlock()
+		return len(alt.pending) == 0
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// Messages which have already passed through a relay are dropped
+	upstream.mu.Lock()
+	upstream.receiveBridgedLocked(downstream.Identity(), &Message{
+		Kind:     VicDetectMessageKind,
+		Sender:   alt.Identity(),
+		Receiver: BroadcastReceiver,
+		Content:  "{}",
+		Route:    []string{downstream.Identity(), upstream.Identity()},
+	})
+	upstream.mu.Unlock()
+	select {
+	case <-received:
+		t.Fatal("received a message bridged in a loop")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// A second bridge between the same relays is rejected
+	assert.Error(t, upstream.Bridge(address, downstream.pairingCode))
+
+	assert.NoError(t, downstream.Bridge("", ""))
+	assert.Empty(t, downstream.state.Object().Networking.Object().UpstreamKey)
+	assert.Eventually(t, func() bool {
+		upstream.mu.Lock()
+		defer upstream.mu.Unlock()
+		return len(upstream.linksLocked("")) == 0
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestSplitIdentity(t *testing.T) {
+	identity, relay, ok := splitIdentity(qualifyIdentity(qualifyIdentity("Host/User/Alt", "Host/User/A"), "Host/User/B"))
+	assert.True(t, ok)
+	assert.Equal(t, "Host/User/Alt@Host/User/A", identity)
+	assert.Equal(t, "Host/User/B", relay)
+	_, _, ok = splitIdentity("Host/User/Alt")
+	assert.False(t, ok)
+}
@@ -3,6 +3,7 @@ package networking
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	. "github.com/nosyliam/revolution/pkg/common"
@@ -24,19 +25,52 @@ var (
 	InactiveNetworkError = errors.New("inactive network")
 )
 
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = time.Minute
+	maxQueuedMessages = 64
+	deliveryTimeout   = 30 * time.Second
+)
+
+var retransmitInterval = newInterval(2 * time.Second)
+
+type pendingMessage struct {
+	message *Message
+	sent    time.Time
+	expires time.Time
+}
+
 type subscriber struct {
 	ch   chan *Message
 	once bool
 }
 
 type Client struct {
-	stop       chan struct{}
-	disconnect chan struct{}
-	mu         sync.Mutex
-	conn       net.Conn
-	watchers   map[MessageKind]map[subscriber]bool
-	state      *config.Object[config.MacroState]
-	logger     *logging.Logger
+	stop        chan struct{}
+	stopOnce    sync.Once
+	disconnect  chan struct{}
+	mu          sync.Mutex
+	conn        net.Conn
+	codec       *codec
+	session     *session
+	pairingCode string
+	watchers    map[MessageKind]map[subscriber]bool
+
+	// After registering, the client reconnects to the same relay whenever the connection is lost. Messages sent in
+	// the meantime are queued, and the role is restored once the client has registered again.
+	address     string
+	fingerprint string
+	reconnect   bool
+	role        ClientRole
+	queue       []*Message
+	pending     map[string]*pendingMessage
+
+	// Requests which are waiting for a response, and the handlers answering the requests of each kind
+	requests map[string]chan *ResponseMessage
+	handlers map[MessageKind]Handler
+
+	state  *config.Object[config.MacroState]
+	logger *logging.Logger
 }
 
 func NewClient(state *config.Object[config.MacroState], logger *logging.Logger) *Client {
@@ -44,6 +78,9 @@ func NewClient(state *config.Object[config.MacroState], logger *logging.Logger)
 		stop:       make(chan struct{}),
 		disconnect: make(chan struct{}),
 		watchers:   make(map[MessageKind]map[subscriber]bool),
+		pending:    make(map[string]*pendingMessage),
+		requests:   make(map[string]chan *ResponseMessage),
+		handlers:   make(map[MessageKind]Handler),
 		state:      state,
 		logger:     logger,
 	}
@@ -58,6 +95,13 @@ func (c *Client) Identity() string {
 	return getIdentity() + "/" + c.state.Object().AccountName
 }
 
+// connected returns whether the client holds a connection to a relay, including one it is still registering with
+func (c *Client) connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn != nil
+}
+
 func (c *Client) Subscribe(kind MessageKind) chan *Message {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -75,6 +119,10 @@ func (c *Client) SubscribeOnce(kind MessageKind) <-chan *Message {
 }
 
 func (c *Client) Send(receiver string, content interface{}) {
+	c.deliver(c.newMessage(receiver, content))
+}
+
+func (c *Client) newMessage(receiver string, content interface{}) *Message {
 	kind := MessageKinds.Determine(content)
 	if kind == UnknownMessageKind {
 		panic("received a call to send an unknown type of message")
@@ -83,22 +131,109 @@ func (c *Client) Send(receiver string, content interface{}) {
 	if err != nil {
 		panic(fmt.Sprintf("failed to marshal message of kind: %v", err))
 	}
-	var message = &Message{
+	id, _ := randomHex(8)
+	return &Message{
 		Sender:   c.Identity(),
 		Receiver: receiver,
 		Content:  string(data),
 		Kind:     kind,
+		ID:       id,
+		Time:     time.Now(),
 	}
-	serialized, err := json.Marshal(message)
-	if err != nil {
-		panic(fmt.Sprintf("failed to marshal wrapped message of kind: %v", err))
-	}
+}
+
+func (c *Client) deliver(message *Message) {
 	c.mu.Lock()
-	if _, err = c.conn.Write(append(serialized, '\n')); err != nil {
+	defer c.mu.Unlock()
+	if ReliableMessageKinds[message.Kind] {
+		c.pending[message.ID] = &pendingMessage{message: message, sent: message.Time, expires: message.Time.Add(deliveryTimeout)}
+	}
+	if c.conn == nil || c.session == nil {
+		// Messages sent while the client is still registering are delivered once it has registered
+		if c.reconnect || c.conn != nil {
+			c.enqueueLocked(message)
+			return
+		}
+		c.logger.Log(0, logging.Warning, "[Client]: dropped message: not registered with a relay")
+		return
+	}
+	if err := c.writeLocked(message); err != nil && c.reconnect {
+		c.enqueueLocked(message)
+	}
+}
+
+func (c *Client) writeLocked(message *Message) error {
+	c.session.sign(message)
+	if err := c.codec.write(message); err != nil {
 		c.logger.Log(0, logging.Error, fmt.Sprintf("[Client]: failed to write to relay: %v", err))
-		defer c.Disconnect()
+		// The listener notices the closed connection and starts reconnecting
+		_ = c.conn.Close()
+		return err
 	}
-	c.mu.Unlock()
+	return nil
+}
+
+func (c *Client) enqueueLocked(message *Message) {
+	if len(c.queue) >= maxQueuedMessages {
+		c.logger.Log(0, logging.Warning, "[Client]: outbound queue is full, dropping the oldest message")
+		c.queue = c.queue[1:]
+	}
+	c.queue = append(c.queue, message)
+}
+
+// flushQueue sends the messages queued while the client was disconnected, in the order they were sent
+func (c *Client) flushQueue() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.queue) > 0 && c.conn != nil && c.session != nil {
+		if err := c.writeLocked(c.queue[0]); err != nil {
+			return
+		}
+		c.queue = c.queue[1:]
+	}
+}
+
+// retransmit resends reliable messages which have not been acknowledged until they expire
+func (c *Client) retransmit() {
+	ticker := time.NewTicker(retransmitInterval.get() / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.retransmitPending()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+func (c *Client) retransmitPending() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for id, pending := range c.pending {
+		if now.After(pending.expires) {
+			c.logger.Log(0, logging.Warning, fmt.Sprintf("[Client]: Failed to deliver message %s to %s: no acknowledgement received", id, pending.message.Receiver))
+			delete(c.pending, id)
+			continue
+		}
+		if c.conn == nil || c.session == nil || now.Sub(pending.sent) < retransmitInterval.get() {
+			continue
+		}
+		pending.sent = now
+		_ = c.writeLocked(pending.message)
+	}
+}
+
+func (c *Client) handleDeliveryAck(message Message) {
+	var data DeliveryAckMessage
+	if err := json.Unmarshal([]byte(message.Content), &data); err != nil {
+		c.logger.Log(0, logging.Warning, fmt.Sprintf("[Client]: Failed to unserialize delivery acknowledgement: %v", err))
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, data.ID)
 }
 
 func (c *Client) Broadcast(content interface{}) {
@@ -106,7 +241,10 @@ func (c *Client) Broadcast(content interface{}) {
 }
 
 func (c *Client) SetRole(role ClientRole) error {
-	if c.conn == nil {
+	c.mu.Lock()
+	connected := c.conn != nil
+	c.mu.Unlock()
+	if !connected {
 		return InactiveNetworkError
 	}
 	c.state.SetPath("status", "Registering role with relay")
@@ -127,6 +265,9 @@ func (c *Client) SetRole(role ClientRole) error {
 			c.state.SetPath("networking.roleRegisterError", fmt.Sprintf("registration rejected: %s", ack.Error))
 			return errors.New(ack.Error)
 		}
+		c.mu.Lock()
+		c.role = role
+		c.mu.Unlock()
 		return nil
 	case <-time.After(10 * time.Second):
 		c.logger.Log(0, logging.Error, "[Client]: Failed to register role with relay: no acknowledgement received!")
@@ -137,77 +278,258 @@ func (c *Client) SetRole(role ClientRole) error {
 	}
 }
 
-func (c *Client) Connect(address string) error {
+// Pair connects to a relay using the one-time pairing code it displays. Once paired, the relay's network key is saved
+// and used for every following connection.
+func (c *Client) Pair(address, code string) error {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	c.pairingCode = normalizePairingCode(code)
+	c.mu.Unlock()
+	if err := c.Connect(address); err != nil {
+		c.mu.Lock()
+		c.pairingCode = ""
+		c.mu.Unlock()
+		return err
+	}
+	return nil
+}
 
-	if c.conn != nil {
+func (c *Client) Connect(address string) error {
+	return c.connect(address, c.pinnedFingerprint(address))
+}
+
+// pinnedFingerprint returns the certificate fingerprint saved for a relay, or the one it advertised over zeroconf
+func (c *Client) pinnedFingerprint(address string) string {
+	networking := c.state.Object().Networking.Object()
+	for _, relays := range []*config.List[config.NetworkIdentity]{networking.SavedRelays, networking.AvailableRelays} {
+		if relays == nil {
+			continue
+		}
+		if relay := relays.Lookup(address); relay != nil {
+			if fingerprint := relay.Object().Fingerprint; fingerprint != "" {
+				return fingerprint
+			}
+		}
+	}
+	return ""
+}
+
+func (c *Client) connect(address string, fingerprint string) error {
+	if c.connected() {
 		return nil
 	}
 
-	fmt.Println("[Client]: Connecting to", address)
-	fmt.Println("conn", c.state.SetPath("networking.connectingAddress", address))
-	conn, err := net.Dial("tcp", address)
+	_ = c.state.SetPath("networking.connectingAddress", address)
+	var observed string
+	dialer := &net.Dialer{Timeout: handshakeTimeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", address, clientTLSConfig(fingerprint, func(actual string) {
+		observed = actual
+	}))
 	if err != nil {
 		dialog.Message(fmt.Sprintf("Failed to connect to %s: %v", address, err))
 		_ = c.state.SetPath("networking.connectingAddress", "")
 		return err
 	}
 
+	// Saved relays without a fingerprint trust the first certificate they are presented with
+	if saved := c.state.Object().Networking.Object().SavedRelays; fingerprint == "" && saved != nil && saved.Lookup(address) != nil {
+		_ = c.state.SetPathf(observed, "networking.savedRelays[%s].fingerprint", address)
+	}
+	// The lock is not held while dialing, so another connection may have been established in the meantime
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		_ = conn.Close()
+		return nil
+	}
 	c.conn = conn
+	c.address, c.fingerprint = address, observed
 	return nil
 }
 
 func (c *Client) Start() {
 	go c.discoverRelays()
+	go c.retransmit()
 
+	backoff := minReconnectDelay
 	for {
-		if c.conn == nil {
+		c.mu.Lock()
+		connected, reconnect, address, fingerprint := c.conn != nil, c.reconnect, c.address, c.fingerprint
+		c.mu.Unlock()
+		if !connected {
+			delay := 1 * time.Second
+			if reconnect {
+				delay = backoff
+			}
 			select {
-			case <-time.After(1 * time.Second):
-				continue
+			case <-time.After(delay):
 			case <-c.stop:
 				return
 			}
+			if reconnect {
+				c.logger.Log(0, logging.Info, fmt.Sprintf("[Client]: Reconnecting to relay %s", address))
+				if err := c.connect(address, fingerprint); err != nil {
+					backoff = min(backoff*2, maxReconnectDelay)
+				}
+			}
+			continue
 		}
 
-		c.Send(RelayReceiver, RegistrationMessage{Identity: c.Identity()})
-		go c.listenForMessages()
-		select {
-		case message := <-c.SubscribeOnce(AckRegistrationMessageKind):
-			if message == nil {
-				c.Disconnect()
-				continue
-			}
-			var ack AckRegistrationMessage
-			if err := json.Unmarshal([]byte(message.Content), &ack); err != nil {
-				c.logger.Log(0, logging.Error, fmt.Sprintf("[Client]: Failed to unmarshal registration acknowledgement: %v", err))
-				c.Disconnect()
-				continue
-			}
-			if ack.Error != "" {
-				dialog.Message(fmt.Sprintf("Failed to connect to relay: %s", ack.Error))
-				c.Disconnect()
+		reader, err := c.register()
+		if err != nil {
+			c.logger.Log(0, logging.Error, fmt.Sprintf("[Client]: Failed to register with relay: %v", err))
+			if reconnect {
+				c.dropConnection()
+				backoff = min(backoff*2, maxReconnectDelay)
 				continue
 			}
-			break
-		case <-time.After(10 * time.Second):
-			c.logger.Log(0, logging.Error, "[Client]: Failed to connect to relay: no registration acknowledgement received!")
+			dialog.Message(fmt.Sprintf("Failed to connect to relay: %v", err))
+			c.state.SetPath("networking.connectingAddress", "")
 			c.Disconnect()
 			continue
-		case <-c.stop:
-			return
 		}
-		fmt.Println("connected to relay")
-		c.state.SetPath("networking.connectedAddress", c.conn.RemoteAddr().String())
+		backoff = minReconnectDelay
+		c.mu.Lock()
+		c.reconnect = true
+		role := c.role
+		c.mu.Unlock()
+		go c.listenForMessages(reader)
+		c.mu.Lock()
+		conn := c.conn
+		c.mu.Unlock()
+		if conn != nil {
+			c.state.SetPath("networking.connectedAddress", conn.RemoteAddr().String())
+		}
 		c.state.SetPath("networking.connectingAddress", "")
+		c.restore(role)
 		<-c.disconnect
 	}
 }
 
+// restore delivers the messages queued while the client was disconnected and registers the role it held before
+func (c *Client) restore(role ClientRole) {
+	c.flushQueue()
+	if role == "" {
+		return
+	}
+	if err := c.SetRole(role); err != nil {
+		c.logger.Log(0, logging.Warning, fmt.Sprintf("[Client]: Failed to restore role %s: %v", role, err))
+	}
+}
+
+// readMessage reads a message exchanged as JSON during registration
+func readMessage(reader *bufio.Reader, kind MessageKind) (*Message, error) {
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	var message Message
+	if err := json.Unmarshal(line, &message); err != nil {
+		return nil, errors.Wrap(err, "failed to decode message")
+	}
+	if message.Kind != kind {
+		return nil, errors.New(fmt.Sprintf("unexpected message kind %d", message.Kind))
+	}
+	return &message, nil
+}
+
+// handshake answers a relay's challenge with a registration signed with the given secret, and verifies the relay's
+// acknowledgement. Both sides switch to the acknowledged protocol version afterwards.
+func handshake(conn net.Conn, registration RegistrationMessage, secret string) (*codec, *session, *AckRegistrationMessage, error) {
+	reader := bufio.NewReader(conn)
+	message, err := readMessage(reader, ChallengeMessageKind)
+	if err != nil {
+		return nil, nil, nil, errors.Wrap(err, "no challenge received")
+	}
+	var challenge ChallengeMessage
+	if err := json.Unmarshal([]byte(message.Content), &challenge); err != nil {
+		return nil, nil, nil, errors.Wrap(err, "failed to decode challenge")
+	}
+
+	if registration.Nonce, err = randomHex(nonceSize); err != nil {
+		return nil, nil, nil, err
+	}
+	registration.Version = protocolVersion
+	if registration.Pairing {
+		secret = derivePairingKey(secret, challenge.Salt)
+	}
+	sess := newSession(secret, challenge.Nonce, registration.Nonce)
+	data, _ := json.Marshal(registration)
+	message = &Message{
+		Kind:     RegistrationMessageKind,
+		Sender:   registration.Identity,
+		Receiver: RelayReceiver,
+		Content:  string(data),
+	}
+	sess.sign(message)
+	if err := writeMessage(conn, message); err != nil {
+		return nil, nil, nil, errors.Wrap(err, "failed to send registration")
+	}
+
+	message, err = readMessage(reader, AckRegistrationMessageKind)
+	if err != nil {
+		return nil, nil, nil, errors.Wrap(err, "no registration acknowledgement received")
+	}
+	var ack AckRegistrationMessage
+	if err := json.Unmarshal([]byte(message.Content), &ack); err != nil {
+		return nil, nil, nil, errors.Wrap(err, "failed to decode registration acknowledgement")
+	}
+	if ack.Error != "" {
+		return nil, nil, nil, errors.New(ack.Error)
+	}
+	// A relay which does not hold the secret cannot sign its acknowledgement
+	if err := sess.verify(message); err != nil {
+		return nil, nil, nil, errors.Wrap(err, "failed to verify relay")
+	}
+	// Relays which predate framing do not acknowledge a version, and keep using JSON
+	return newCodec(conn, reader, negotiateVersion(ack.Version)), sess, &ack, nil
+}
+
+// register answers the relay's challenge and waits for the registration to be acknowledged. The returned codec must
+// be used to receive every following message.
+func (c *Client) register() (*codec, error) {
+	// Pairing codes may only be used once, whether or not the registration succeeds
+	c.mu.Lock()
+	conn, pairingCode := c.conn, c.pairingCode
+	c.pairingCode = ""
+	c.mu.Unlock()
+	if conn == nil {
+		return nil, InactiveNetworkError
+	}
+	_ = conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	pairing := pairingCode != ""
+	secret := pairingCode
+	if !pairing {
+		secret = c.state.Object().Networking.Object().NetworkKey
+	}
+	if secret == "" {
+		return nil, errors.New("this account has not been paired with a relay. Enter the pairing code shown by the relay")
+	}
+	stream, sess, ack, err := handshake(conn, RegistrationMessage{Identity: c.Identity(), Pairing: pairing}, secret)
+	if err != nil {
+		return nil, err
+	}
+	if pairing {
+		key, err := sess.open(ack.Key)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to receive network key")
+		}
+		if err := c.state.SetPath("networking.networkKey", key); err != nil {
+			return nil, errors.Wrap(err, "failed to save network key")
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.codec = stream
+	c.session = sess
+	return stream, nil
+}
+
 func (c *Client) Close() {
 	c.Disconnect()
-	c.stop <- struct{}{}
+	c.stopOnce.Do(func() { close(c.stop) })
 }
 
 func (c *Client) UnsubscribeAll() {
@@ -223,16 +545,63 @@ func (c *Client) UnsubscribeAll() {
 	defer c.mu.Unlock()
 }
 
+// dropConnection closes a lost connection. Unlike Disconnect, subscriptions and queued messages are kept so that
+// the client can resume once it has reconnected.
+func (c *Client) dropConnection() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		return
+	}
+
+	conn := c.conn
+	c.conn = nil
+	c.codec = nil
+	c.session = nil
+	// Acknowledgements which are being waited on will never arrive
+	for _, watcher := range c.watchers {
+		for sub := range watcher {
+			if sub.once {
+				sub.ch <- nil
+				delete(watcher, sub)
+			}
+		}
+	}
+
+	c.state.SetPath("networking.connectedAddress", "")
+	_ = conn.Close()
+}
+
+// abandonRelay closes the connection to a relay which has stopped, and stops reconnecting to it. Like dropConnection,
+// subscriptions, the role and unacknowledged messages are kept so that they carry over to the next relay.
+func (c *Client) abandonRelay() {
+	c.mu.Lock()
+	c.reconnect = false
+	c.mu.Unlock()
+	c.dropConnection()
+}
+
 func (c *Client) Disconnect() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	c.reconnect = false
+	c.role = ""
+	c.queue = nil
+	clear(c.pending)
+	for id, response := range c.requests {
+		response <- nil
+		delete(c.requests, id)
+	}
 	if c.conn == nil {
 		return
 	}
 
 	conn := c.conn
 	c.conn = nil
+	c.codec = nil
+	c.session = nil
 	for _, watcher := range c.watchers {
 		for sub := range watcher {
 			sub.ch <- nil
@@ -264,22 +633,23 @@ func (c *Client) discoverRelays() {
 		go func(results <-chan *zeroconf.ServiceEntry) {
 			var identities = make(map[string]*config.NetworkIdentity)
 			for entry := range results {
+				var relay config.NetworkIdentity
 				for _, txt := range entry.Text {
 					if strings.HasPrefix(txt, "identity=") {
 						identity, err := url.QueryUnescape(strings.TrimPrefix(txt, "identity="))
 						if err != nil {
 							continue
 						}
-						if identity == c.Identity() {
-							continue
-						}
-						address := fmt.Sprintf("%s:%d", entry.AddrIPv4[0].String(), entry.Port)
-						identities[address] = &config.NetworkIdentity{
-							Identity: identity,
-							Address:  fmt.Sprintf("%s:%d", entry.AddrIPv4[0].String(), entry.Port),
-						}
+						relay.Identity = identity
+					} else if strings.HasPrefix(txt, "fingerprint=") {
+						relay.Fingerprint = strings.TrimPrefix(txt, "fingerprint=")
 					}
 				}
+				if relay.Identity == "" || relay.Identity == c.Identity() || len(entry.AddrIPv4) == 0 {
+					continue
+				}
+				relay.Address = fmt.Sprintf("%s:%d", entry.AddrIPv4[0].String(), entry.Port)
+				identities[relay.Address] = &relay
 			}
 			if len(c.stop) > 0 {
 				cancel()
@@ -289,10 +659,13 @@ func (c *Client) discoverRelays() {
 			var removedIdentities []string
 			var existingIdentities = make(map[string]bool)
 			status.AvailableRelays.ForEach(func(id *config.NetworkIdentity) {
-				if _, ok := identities[id.Address]; !ok {
+				if newId, ok := identities[id.Address]; !ok {
 					removedIdentities = append(removedIdentities, id.Address)
 				} else {
 					existingIdentities[id.Address] = true
+					if id.Fingerprint != newId.Fingerprint {
+						_ = c.state.SetPathf(newId.Fingerprint, "networking.availableRelays[%s].fingerprint", id.Address)
+					}
 				}
 			})
 			for _, id := range removedIdentities {
@@ -302,6 +675,7 @@ func (c *Client) discoverRelays() {
 				if _, ok := existingIdentities[address]; !ok {
 					_ = c.state.AppendPathf("networking.availableRelays[%s]", address)
 					_ = c.state.SetPathf(id.Identity, "networking.availableRelays[%s].identity", address)
+					_ = c.state.SetPathf(id.Fingerprint, "networking.availableRelays[%s].fingerprint", address)
 				}
 			}
 			cancel()
@@ -326,7 +700,6 @@ func (c *Client) discoverRelays() {
 
 func (c *Client) handleConnectedIdentities(message Message) {
 	var data ConnectedIdentitiesMessage
-	fmt.Println(string(message.Content))
 	if err := json.Unmarshal([]byte(message.Content), &data); err != nil {
 		c.logger.Log(0, logging.Warning, fmt.Sprintf("[Client]: Failed to unserialize connected identities message: %v", err))
 		return
@@ -346,20 +719,38 @@ func (c *Client) handleConnectedIdentities(message Message) {
 			if id.Role != newId.Role {
 				c.state.SetPathf(newId.Role, "networking.connectedIdentities[%s].role", id.Address)
 			}
+			c.setPresence(newId)
 		}
 	})
 	for _, id := range removedIdentities {
 		c.state.DeletePathf("networking.connectedIdentities[%s]", id)
 	}
+	c.pruneFleet(data.Identities)
 	for address, id := range identities {
 		if _, ok := existingIdentities[address]; !ok {
 			c.state.AppendPathf("networking.connectedIdentities[%s]", address)
 			c.state.SetPathf(id.Identity, "networking.connectedIdentities[%s].identity", address)
 			c.state.SetPathf(id.Role, "networking.connectedIdentities[%s].role", address)
+			c.setPresence(id)
 		}
 	}
 }
 
+func (c *Client) setPresence(id config.NetworkIdentity) {
+	c.state.SetPathf(id.LastSeen, "networking.connectedIdentities[%s].lastSeen", id.Address)
+	c.state.SetPathf(id.Latency, "networking.connectedIdentities[%s].latency", id.Address)
+	c.state.SetPathf(id.Stale, "networking.connectedIdentities[%s].stale", id.Address)
+}
+
+func (c *Client) handleHeartbeat(message Message) {
+	var data HeartbeatMessage
+	if err := json.Unmarshal([]byte(message.Content), &data); err != nil {
+		c.logger.Log(0, logging.Warning, fmt.Sprintf("[Client]: Failed to unserialize heartbeat message: %v", err))
+		return
+	}
+	c.Send(RelayReceiver, AckHeartbeatMessage{Sent: data.Sent})
+}
+
 func (c *Client) handleShutdown() {
 	status := c.state.Object().Networking.Object()
 	status.ConnectedIdentities.ForEach(func(id *config.NetworkIdentity) {
@@ -367,41 +758,85 @@ func (c *Client) handleShutdown() {
 	})
 }
 
-func (c *Client) listenForMessages() {
-	scanner := bufio.NewScanner(c.conn)
-	for scanner.Scan() {
-		var msg Message
-		data := scanner.Bytes()
-		if len(data) == 0 {
-			continue
+func (c *Client) dispatch(msg *Message) {
+	c.mu.Lock()
+	watcher, ok := c.watchers[msg.Kind]
+	if !ok {
+		c.mu.Unlock()
+		c.logger.Log(0, logging.Warning, "[Client]: received invalid message type from relay!")
+		return
+	}
+	var subs []subscriber
+	for sub := range watcher {
+		subs = append(subs, sub)
+		if sub.once {
+			delete(watcher, sub)
 		}
-		if err := json.Unmarshal(data, &msg); err != nil {
+	}
+	c.mu.Unlock()
+	// Subscribers are sent to without holding the lock, since they may call into the client before receiving
+	for _, sub := range subs {
+		sub.ch <- msg
+	}
+}
+
+func (c *Client) listenForMessages(stream *codec) {
+	c.mu.Lock()
+	conn, sess := c.conn, c.session
+	c.mu.Unlock()
+	if conn == nil {
+		c.disconnect <- struct{}{}
+		return
+	}
+	var err error
+	for {
+		// The relay sends heartbeats regularly, so a silent connection has been lost
+		_ = conn.SetReadDeadline(time.Now().Add(heartbeatTimeout()))
+		var msg *Message
+		if msg, err = stream.read(); errors.Is(err, MalformedMessageError) {
 			c.logger.Log(0, logging.Warning, fmt.Sprintf("[Client]: received invalid message from relay: %v", err))
 			continue
+		} else if err != nil {
+			break
+		}
+		if err := sess.verify(msg); err != nil {
+			c.logger.Log(0, logging.Warning, fmt.Sprintf("[Client]: dropped message from relay: %v", err))
+			continue
 		}
 		switch msg.Kind {
 		case ConnectedIdentitiesMessageKind:
-			c.handleConnectedIdentities(msg)
+			c.handleConnectedIdentities(*msg)
+			continue
+		case HeartbeatMessageKind:
+			c.handleHeartbeat(*msg)
+			continue
+		case DeliveryAckMessageKind:
+			c.handleDeliveryAck(*msg)
+			continue
+		case ResponseMessageKind:
+			c.handleResponse(*msg)
+			continue
+		case StatusSnapshotMessageKind:
+			c.handleStatusSnapshot(*msg)
 			continue
 		case ShutdownMessageKind:
 			c.handleShutdown()
-			break
+			c.dispatch(msg)
 		default:
-			if _, ok := c.watchers[msg.Kind]; !ok {
-				c.logger.Log(0, logging.Warning, "[Client]: received invalid message type from relay!")
-				continue
+			// Duplicates are acknowledged as well, since the previous acknowledgement may have been lost
+			if ReliableMessageKinds[msg.Kind] && msg.ID != "" {
+				c.Send(msg.Sender, DeliveryAckMessage{ID: msg.ID})
 			}
-			for sub := range c.watchers[msg.Kind] {
-				sub.ch <- &msg
-				if sub.once {
-					delete(c.watchers[msg.Kind], sub)
-				}
+			if handler := c.handler(msg.Kind); handler != nil {
+				go c.handleRequest(msg, handler)
+				continue
 			}
+			c.dispatch(msg)
 		}
 	}
-	if err := scanner.Err(); err != nil && err != io.EOF {
+	if err != io.EOF && !errors.Is(err, net.ErrClosed) {
 		c.logger.Log(0, logging.Error, fmt.Sprintf("Relay connection error: %v", err))
 	}
-	c.Disconnect()
+	c.dropConnection()
 	c.disconnect <- struct{}{}
 }
@@ -0,0 +1,164 @@
+package networking
+
+import (
+	"encoding/json"
+	. "github.com/nosyliam/revolution/pkg/common"
+	"github.com/nosyliam/revolution/pkg/config"
+	"github.com/nosyliam/revolution/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func (n *testNetwork) role(identity string) ClientRole {
+	n.relay.mu.Lock()
+	defer n.relay.mu.Unlock()
+	return n.relay.roles[identity]
+}
+
+// kick closes the relay's connection to an identity, as if the relay had restarted
+func (n *testNetwork) kick(identity string) {
+	n.relay.mu.Lock()
+	defer n.relay.mu.Unlock()
+	n.relay.identities[identity].conn.Close()
+}
+
+func TestClient_Reconnect(t *testing.T) {
+	network := newTestNetwork(t)
+	receiver := NewClient(network.account("Main"), logging.NewLogger("Main", nil))
+	stream, err := network.connect(receiver, network.relay.pairingCode)
+	assert.NoError(t, err)
+
+	state := network.account("Alt")
+	key := network.relay.state.Object().Networking.Object().NetworkKey
+	assert.NoError(t, state.SetPath("networking.networkKey", key))
+	client := NewClient(state, logging.NewLogger("Alt", nil))
+	go client.Start()
+	defer client.Close()
+	assert.NoError(t, client.connect(network.address, network.relay.fingerprint))
+	assert.Eventually(t, func() bool { return network.connected(client.Identity()) }, 2*time.Second, 10*time.Millisecond)
+	assert.NoError(t, client.SetRole(SearcherClientRole))
+	assert.Equal(t, ClientRole(SearcherClientRole), network.role(client.Identity()))
+
+	network.kick(client.Identity())
+	assert.Eventually(t, func() bool {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		return client.conn == nil
+	}, time.Second, 10*time.Millisecond)
+	assert.Empty(t, *config.Concrete[string](state, "networking.connectedAddress"))
+
+	// Messages sent while disconnected are delivered after reconnecting
+	client.Broadcast(VicDetectMessage{Field: "Rose"})
+	client.Broadcast(VicDetectMessage{Field: "Pepper"})
+	assert.Eventually(t, func() bool {
+		return network.connected(client.Identity()) && network.role(client.Identity()) == SearcherClientRole
+	}, 5*time.Second, 10*time.Millisecond, "the client reconnects and restores its role")
+
+	var fields []string
+	assert.NoError(t, receiver.conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	for len(fields) < 2 {
+		message, err := stream.read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Identity updates are interleaved with the forwarded messages
+		if message.Kind != VicDetectMessageKind {
+			continue
+		}
+		var vic VicDetectMessage
+		assert.NoError(t, json.Unmarshal([]byte(message.Content), &vic))
+		fields = append(fields, vic.Field)
+	}
+	assert.Equal(t, []string{"Rose", "Pepper"}, fields)
+
+	// Clients which disconnect deliberately do not reconnect
+	client.Disconnect()
+	assert.Eventually(t, func() bool { return !network.connected(client.Identity()) }, time.Second, 10*time.Millisecond)
+	client.mu.Lock()
+	assert.False(t, client.reconnect)
+	client.mu.Unlock()
+}
+
+func TestClient_QueueBounded(t *testing.T) {
+	network := newTestNetwork(t)
+	client := NewClient(network.account("Alt"), logging.NewLogger("Alt", nil))
+	client.Broadcast(VicDetectMessage{Field: "Rose"})
+	assert.Empty(t, client.queue, "messages are only queued while reconnecting")
+
+	client.reconnect = true
+	for i := 0; i < maxQueuedMessages+10; i++ {
+		client.Broadcast(VicDetectMessage{Field: "Rose"})
+	}
+	assert.Len(t, client.queue, maxQueuedMessages)
+}
+
+func TestClient_ReliableDelivery(t *testing.T) {
+	interval := retransmitInterval.get()
+	retransmitInterval.set(50 * time.Millisecond)
+	defer retransmitInterval.set(interval)
+	network := newTestNetwork(t)
+
+	sender := NewClient(network.account("Searcher"), logging.NewLogger("Searcher", nil))
+	stream, err := network.connect(sender, network.relay.pairingCode)
+	assert.NoError(t, err)
+	go sender.listenForMessages(stream)
+	main := NewClient(network.account("Main"), logging.NewLogger("Main", nil))
+
+	// The main is not connected yet, so the relay cannot deliver the detection
+	sender.Send(main.Identity(), VicDetectMessage{Field: "Rose"})
+	sender.Send(main.Identity(), SearchedServerMessage{})
+	sender.mu.Lock()
+	assert.Len(t, sender.pending, 1, "only designated kinds are retransmitted")
+	sender.mu.Unlock()
+
+	stream, err = network.connect(main, network.relay.pairingCode)
+	assert.NoError(t, err)
+	received := make(chan string, 10)
+	macro := &Macro{Network: &Network{Client: main}, Error: make(chan string, 10)}
+	SubscribeMessage(macro, func(message *VicDetectMessage) { received <- message.Field })
+	go main.listenForMessages(stream)
+
+	// Retransmit faster than acknowledgements can arrive to produce duplicates
+	for i := 0; i < 3; i++ {
+		sender.mu.Lock()
+		for _, pending := range sender.pending {
+			pending.sent = time.Time{}
+		}
+		sender.mu.Unlock()
+		sender.retransmitPending()
+	}
+	assert.Eventually(t, func() bool {
+		sender.mu.Lock()
+		defer sender.mu.Unlock()
+		return len(sender.pending) == 0
+	}, 2*time.Second, 10*time.Millisecond, "the main acknowledges the detection")
+	assert.Equal(t, "Rose", <-received)
+	select {
+	case field := <-received:
+		t.Fatalf("received duplicate detection in %s", field)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// Messages which are never acknowledged expire
+	sender.Send("Missing", VicDetectMessage{Field: "Pepper"})
+	sender.mu.Lock()
+	for _, pending := range sender.pending {
+		pending.expires = time.Now().Add(-time.Second)
+	}
+	sender.mu.Unlock()
+	sender.retransmitPending()
+	assert.Empty(t, sender.pending)
+}
+
+func TestDeduplicator(t *testing.T) {
+	dedupe := newDeduplicator(time.Minute)
+	assert.False(t, dedupe.duplicate(&Message{ID: "a"}))
+	assert.True(t, dedupe.duplicate(&Message{ID: "a"}))
+	assert.False(t, dedupe.duplicate(&Message{}), "messages without an ID are never duplicates")
+	assert.False(t, dedupe.duplicate(&Message{}))
+
+	dedupe.seen["a"] = time.Now().Add(-2 * time.Minute)
+	assert.False(t, dedupe.duplicate(&Message{ID: "b"}))
+	assert.False(t, dedupe.duplicate(&Message{ID: "a"}), "old IDs are forgotten")
+}
@@ -0,0 +1,229 @@
+package networking
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/grandcat/zeroconf"
+	. "github.com/nosyliam/revolution/pkg/common"
+	"github.com/nosyliam/revolution/pkg/config"
+	"github.com/nosyliam/revolution/pkg/logging"
+	"github.com/pkg/errors"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Paired accounts with AltSync.AutoStartRelay enabled advertise themselves as candidates over zeroconf. When no relay
+// has been discovered for the election timeout, the candidate with the lowest identity starts a relay, and the other
+// candidates connect to it once it has been discovered. A relay which stops names a successor in its shutdown message,
+// which starts a relay straight away instead of waiting for the timeout.
+
+const (
+	candidateService          = "_revolution-candidate._tcp"
+	candidateDiscoveryTimeout = 3 * time.Second
+	// Relays which could not be connected to are skipped for this long
+	electionRetryDelay = time.Minute
+)
+
+var electionInterval = time.Second
+
+type elector struct {
+	relay    *Relay
+	client   *Client
+	settings func() config.AltSync
+	server   *zeroconf.Server
+
+	lastRelay time.Time
+	attempts  map[string]time.Time
+
+	// Candidates are discovered and advertised over zeroconf, which is replaced in tests
+	candidates func() ([]string, error)
+	advertise  func(enabled bool)
+	start      func() error
+}
+
+// Elect takes part in relay elections for as long as the relay's client is running
+func (r *Relay) Elect(settings func() config.AltSync) {
+	go newElector(r, settings).run()
+}
+
+func newElector(relay *Relay, settings func() config.AltSync) *elector {
+	e := &elector{
+		relay:     relay,
+		client:    relay.client,
+		settings:  settings,
+		lastRelay: time.Now(),
+		attempts:  make(map[string]time.Time),
+		start:     relay.Start,
+	}
+	e.candidates = e.discoverCandidates
+	e.advertise = e.advertiseCandidate
+	return e
+}
+
+func (e *elector) run() {
+	shutdown := e.client.Subscribe(ShutdownMessageKind)
+	ticker := time.NewTicker(electionInterval)
+	defer ticker.Stop()
+	defer e.advertise(false)
+	for {
+		select {
+		case message := <-shutdown:
+			if message == nil {
+				// Subscriptions are dropped whenever the client disconnects
+				shutdown = e.client.Subscribe(ShutdownMessageKind)
+				continue
+			}
+			e.handleShutdown(message, time.Now())
+		case now := <-ticker.C:
+			e.step(now)
+		case <-e.client.stop:
+			return
+		}
+	}
+}
+
+func (e *elector) step(now time.Time) {
+	settings := e.settings()
+	if !settings.AutoStartRelay || !e.paired() {
+		e.advertise(false)
+		e.lastRelay = now
+		return
+	}
+	e.advertise(true)
+	if e.relay.Active() || e.client.connected() {
+		e.lastRelay = now
+		return
+	}
+	if relay := e.discoveredRelay(); relay != nil {
+		e.lastRelay = now
+		e.connect(relay.Address, now)
+		return
+	}
+	if now.Sub(e.lastRelay) < settings.ElectionTimeout {
+		return
+	}
+
+	// Every candidate would believe it has the lowest identity if none could be discovered
+	candidates, err := e.candidates()
+	if err != nil {
+		e.client.logger.Log(0, logging.Error, fmt.Sprintf("[Election]: Failed to discover candidates: %v", err))
+		return
+	}
+	identity := e.client.Identity()
+	for _, candidate := range candidates {
+		if candidate < identity {
+			return
+		}
+	}
+	e.lastRelay = now
+	e.client.logger.Log(0, logging.Info, "[Election]: No relay was discovered, starting a relay")
+	e.elected()
+}
+
+// paired returns whether the account holds the network key. Accounts which have never been paired could neither
+// register with an elected relay nor share the network's key as one.
+func (e *elector) paired() bool {
+	return e.client.state.Object().Networking.Object().NetworkKey != ""
+}
+
+// elected starts a relay, after abandoning any relay the client is still trying to reconnect to
+func (e *elector) elected() {
+	e.client.abandonRelay()
+	if err := e.start(); err != nil {
+		e.client.logger.Log(0, logging.Error, fmt.Sprintf("[Election]: Failed to start relay: %v", err))
+	}
+}
+
+// discoveredRelay returns the relay with the lowest identity of those discovered over zeroconf
+func (e *elector) discoveredRelay() *config.NetworkIdentity {
+	var relay *config.NetworkIdentity
+	relays := e.client.state.Object().Networking.Object().AvailableRelays
+	if relays == nil {
+		return nil
+	}
+	relays.ForEach(func(id *config.NetworkIdentity) {
+		if relay == nil || id.Identity < relay.Identity {
+			relay = id
+		}
+	})
+	return relay
+}
+
+func (e *elector) connect(address string, now time.Time) {
+	if attempted, ok := e.attempts[address]; ok && now.Sub(attempted) < electionRetryDelay {
+		return
+	}
+	e.attempts[address] = now
+	e.client.logger.Log(0, logging.Info, fmt.Sprintf("[Election]: Connecting to relay %s", address))
+	if err := e.client.Connect(address); err != nil {
+		e.client.logger.Log(0, logging.Warning, fmt.Sprintf("[Election]: Failed to connect to relay %s: %v", address, err))
+	}
+}
+
+func (e *elector) handleShutdown(message *Message, now time.Time) {
+	if !e.settings().AutoStartRelay || !e.paired() {
+		return
+	}
+	var content ShutdownMessage
+	if err := json.Unmarshal([]byte(message.Content), &content); err != nil {
+		e.client.logger.Log(0, logging.Warning, fmt.Sprintf("[Election]: Failed to unmarshal shutdown message: %v", err))
+		return
+	}
+	e.lastRelay = now
+	if content.Successor == e.client.Identity() {
+		e.client.logger.Log(0, logging.Info, "[Election]: Taking over from the stopped relay")
+		e.elected()
+		return
+	}
+	// The relay is gone, so the client must not keep reconnecting to it
+	e.client.abandonRelay()
+}
+
+func (e *elector) advertiseCandidate(enabled bool) {
+	if enabled == (e.server != nil) {
+		return
+	}
+	if !enabled {
+		e.server.Shutdown()
+		e.server = nil
+		return
+	}
+	txtRecords := []string{fmt.Sprintf("identity=%s", url.QueryEscape(e.client.Identity()))}
+	server, err := zeroconf.Register("RevolutionCandidate", candidateService, "local.", e.relay.port, txtRecords, nil)
+	if err != nil {
+		e.client.logger.Log(0, logging.Error, fmt.Sprintf("[Election]: Failed to advertise candidate: %v", err))
+		return
+	}
+	e.server = server
+}
+
+func (e *elector) discoverCandidates() ([]string, error) {
+	resolver, err := zeroconf.NewResolver(nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create resolver")
+	}
+	entries := make(chan *zeroconf.ServiceEntry)
+	ctx, cancel := context.WithTimeout(context.Background(), candidateDiscoveryTimeout)
+	defer cancel()
+	done := make(chan []string)
+	go func() {
+		var identities []string
+		for entry := range entries {
+			for _, txt := range entry.Text {
+				if !strings.HasPrefix(txt, "identity=") {
+					continue
+				}
+				if identity, err := url.QueryUnescape(strings.TrimPrefix(txt, "identity=")); err == nil {
+					identities = append(identities, identity)
+				}
+			}
+		}
+		done <- identities
+	}()
+	if err := resolver.Browse(ctx, candidateService, "local.", entries); err != nil {
+		return nil, errors.Wrap(err, "failed to browse for candidates")
+	}
+	return <-done, nil
+}
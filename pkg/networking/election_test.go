@@ -0,0 +1,134 @@
+package networking
+
+import (
+	"encoding/json"
+	"errors"
+	. "github.com/nosyliam/revolution/pkg/common"
+	"github.com/nosyliam/revolution/pkg/config"
+	"github.com/nosyliam/revolution/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type testElection struct {
+	*elector
+	settings   config.AltSync
+	candidates []string
+	discovery  error
+	starts     int
+}
+
+func newTestElection(network *testNetwork, name string) *testElection {
+	state := network.account(name)
+	key := network.relay.state.Object().Networking.Object().NetworkKey
+	assert.NoError(network.t, state.SetPath("networking.networkKey", key))
+	client := NewClient(state, logging.NewLogger(name, nil))
+	election := &testElection{settings: config.AltSync{AutoStartRelay: true, ElectionTimeout: 15 * time.Second}}
+	election.elector = newElector(NewRelay(client, state, client.logger), func() config.AltSync {
+		return election.settings
+	})
+	election.elector.candidates = func() ([]string, error) { return election.candidates, election.discovery }
+	election.advertise = func(bool) {}
+	election.start = func() error {
+		election.starts++
+		return nil
+	}
+	return election
+}
+
+func TestElector_Step(t *testing.T) {
+	network := newTestNetwork(t)
+	election := newTestElection(network, "Bravo")
+	now := election.lastRelay
+	identity := func(name string) string { return getIdentity() + "/" + name }
+
+	election.settings.AutoStartRelay = false
+	election.step(now.Add(time.Minute))
+	assert.Zero(t, election.starts, "elections are disabled")
+
+	election.settings.AutoStartRelay = true
+	election.step(now.Add(time.Minute + 10*time.Second))
+	assert.Zero(t, election.starts, "the timeout restarts once elections are enabled")
+
+	election.candidates = []string{identity("Alpha"), identity("Charlie")}
+	election.step(now.Add(2 * time.Minute))
+	assert.Zero(t, election.starts, "a lower identity is elected instead")
+
+	election.candidates = []string{identity("Charlie")}
+	election.discovery = errors.New("no multicast interface")
+	election.step(now.Add(2*time.Minute + time.Second))
+	assert.Zero(t, election.starts, "candidates which could not be discovered may have a lower identity")
+
+	election.discovery = nil
+	assert.NoError(t, election.client.state.SetPath("networking.networkKey", ""))
+	election.step(now.Add(2*time.Minute + time.Second))
+	assert.Zero(t, election.starts, "accounts which have not been paired do not stand for election")
+
+	key := network.relay.state.Object().Networking.Object().NetworkKey
+	assert.NoError(t, election.client.state.SetPath("networking.networkKey", key))
+	election.step(now.Add(4*time.Minute + time.Second))
+	assert.Equal(t, 1, election.starts)
+	election.step(now.Add(4*time.Minute + 2*time.Second))
+	assert.Equal(t, 1, election.starts, "the timeout restarts after an election")
+
+	// Relays which have been discovered are connected to instead
+	assert.NoError(t, election.client.state.AppendPath("networking.availableRelays[127.0.0.1:1]"))
+	election.step(now.Add(time.Hour))
+	assert.Equal(t, 1, election.starts)
+}
+
+func TestElector_Handover(t *testing.T) {
+	network := newTestNetwork(t)
+	election := newTestElection(network, "Bravo")
+	client := election.client
+	stream, err := network.connect(client, "")
+	assert.NoError(t, err)
+	go client.listenForMessages(stream)
+	detections := client.Subscribe(VicDetectMessageKind)
+
+	data, _ := json.Marshal(ShutdownMessage{Successor: getIdentity() + "/Alpha"})
+	election.handleShutdown(&Message{Kind: ShutdownMessageKind, Sender: RelayReceiver, Content: string(data)}, time.Now())
+	assert.Eventually(t, func() bool { return !network.connected(client.Identity()) }, 2*time.Second, 10*time.Millisecond)
+
+	// Subscriptions carry over to the next relay
+	stream, err = network.connect(client, "")
+	assert.NoError(t, err)
+	go client.listenForMessages(stream)
+	sender := NewClient(network.pairedAccount("Alt"), logging.NewLogger("Alt", nil))
+	_, err = network.connect(sender, "")
+	assert.NoError(t, err)
+	sender.Broadcast(VicDetectMessage{Field: "Rose"})
+	select {
+	case message := <-detections:
+		if assert.NotNil(t, message) {
+			assert.Equal(t, sender.Identity(), message.Sender)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the detection was not received after the handover")
+	}
+}
+
+func TestElector_Successor(t *testing.T) {
+	network := newTestNetwork(t)
+	election := newTestElection(network, "Bravo")
+	shutdown := func(successor string) *Message {
+		data, _ := json.Marshal(ShutdownMessage{Successor: successor})
+		return &Message{Kind: ShutdownMessageKind, Sender: RelayReceiver, Content: string(data)}
+	}
+
+	election.handleShutdown(shutdown(getIdentity()+"/Alpha"), time.Now())
+	assert.Zero(t, election.starts)
+	election.handleShutdown(shutdown(election.client.Identity()), time.Now())
+	assert.Equal(t, 1, election.starts, "the successor starts a relay without waiting for an election")
+
+	// The stopping relay names the lowest connected identity as its successor
+	for _, name := range []string{"Delta", "Charlie"} {
+		client := NewClient(network.account(name), logging.NewLogger(name, nil))
+		_, err := network.connect(client, network.relay.pairingCode)
+		assert.NoError(t, err)
+	}
+	network.relay.mu.Lock()
+	assert.Equal(t, getIdentity()+"/Charlie", network.relay.successorLocked())
+	network.relay.mu.Unlock()
+}
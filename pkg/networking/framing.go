@@ -0,0 +1,297 @@
+package networking
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	. "github.com/nosyliam/revolution/pkg/common"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"time"
+)
+
+// The challenge, registration and its acknowledgement are always exchanged as newline-delimited JSON. Clients
+// advertise the highest protocol version they support in their registration, and both sides switch to the version
+// acknowledged by the relay once registered. Clients which do not advertise a version keep using JSON.
+//
+// Framed messages are prefixed with their length as a big-endian uint32:
+//
+//	flags    byte
+//	kind     uvarint
+//	sender   uvarint length + bytes
+//	receiver uvarint length + bytes
+//	id       uvarint length + bytes
+//	route    uvarint count, followed by each relay as uvarint length + bytes, present if flagRoute is set
+//	time     varint unix nanoseconds, present if flagTime is set
+//	sequence uvarint
+//	signature uvarint length + raw bytes
+//	content  remaining bytes, the compact payload if flagCompact is set, and deflated if flagCompressed is set
+//
+// From version 3, the content of the most frequent messages is sent as a compact payload (see payload.go) rather
+// than as JSON. Either is deflated once it is long enough to shrink.
+
+const (
+	legacyProtocolVersion  = 1
+	framedProtocolVersion  = 2
+	compactProtocolVersion = 3
+	protocolVersion        = compactProtocolVersion
+
+	maxFrameSize = 1 << 20
+	// Content shorter than this rarely shrinks when deflated
+	compressionThreshold = 512
+)
+
+const (
+	flagTime byte = 1 << iota
+	flagCompressed
+	flagRoute
+	flagCompact
+)
+
+var MalformedMessageError = errors.New("malformed message")
+
+// negotiateVersion returns the protocol version used with a client which advertised the given version
+func negotiateVersion(version int) int {
+	if version < legacyProtocolVersion {
+		return legacyProtocolVersion
+	}
+	return min(version, protocolVersion)
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func encodeMessage(message *Message, version int) ([]byte, error) {
+	var flags byte
+	if !message.Time.IsZero() {
+		flags |= flagTime
+	}
+	if len(message.Route) > 0 {
+		flags |= flagRoute
+	}
+	content := []byte(message.Content)
+	if version >= compactProtocolVersion {
+		if payload, ok := encodePayload(message.Kind, message.Content); ok {
+			flags |= flagCompact
+			content = payload
+		}
+	}
+	if len(content) >= compressionThreshold {
+		var compressed bytes.Buffer
+		writer, _ := flate.NewWriter(&compressed, flate.BestSpeed)
+		if _, err := writer.Write(content); err != nil {
+			return nil, errors.Wrap(err, "failed to compress content")
+		}
+		if err := writer.Close(); err != nil {
+			return nil, errors.Wrap(err, "failed to compress content")
+		}
+		if compressed.Len() < len(content) {
+			flags |= flagCompressed
+			content = compressed.Bytes()
+		}
+	}
+	signature, err := hex.DecodeString(message.Signature)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode signature")
+	}
+
+	buf := []byte{flags}
+	buf = binary.AppendUvarint(buf, uint64(message.Kind))
+	buf = appendString(buf, message.Sender)
+	buf = appendString(buf, message.Receiver)
+	buf = appendString(buf, message.ID)
+	if flags&flagRoute != 0 {
+		buf = binary.AppendUvarint(buf, uint64(len(message.Route)))
+		for _, relay := range message.Route {
+			buf = appendString(buf, relay)
+		}
+	}
+	if flags&flagTime != 0 {
+		buf = binary.AppendVarint(buf, message.Time.UnixNano())
+	}
+	buf = binary.AppendUvarint(buf, message.Sequence)
+	buf = appendString(buf, string(signature))
+	buf = append(buf, content...)
+	if len(buf) > maxFrameSize {
+		return nil, errors.New(fmt.Sprintf("message of %d bytes exceeds the maximum frame size", len(buf)))
+	}
+	return buf, nil
+}
+
+type frameDecoder struct {
+	data []byte
+	err  error
+}
+
+func (d *frameDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	value, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.err = MalformedMessageError
+		return 0
+	}
+	d.data = d.data[n:]
+	return value
+}
+
+func (d *frameDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	value, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.err = MalformedMessageError
+		return 0
+	}
+	d.data = d.data[n:]
+	return value
+}
+
+func (d *frameDecoder) string() string {
+	length := d.uvarint()
+	if d.err != nil {
+		return ""
+	}
+	if length > uint64(len(d.data)) {
+		d.err = MalformedMessageError
+		return ""
+	}
+	s := string(d.data[:length])
+	d.data = d.data[length:]
+	return s
+}
+
+func decodeMessage(data []byte) (*Message, error) {
+	if len(data) == 0 {
+		return nil, MalformedMessageError
+	}
+	flags := data[0]
+	decoder := frameDecoder{data: data[1:]}
+	var message Message
+	kind := decoder.uvarint()
+	if kind >= uint64(UnknownMessageKind) {
+		return nil, errors.Wrap(MalformedMessageError, fmt.Sprintf("unknown message kind %d", kind))
+	}
+	message.Kind = MessageKind(kind)
+	message.Sender = decoder.string()
+	message.Receiver = decoder.string()
+	message.ID = decoder.string()
+	if flags&flagRoute != 0 {
+		// Every relay is encoded with at least one byte, which bounds the allocation below
+		count := decoder.uvarint()
+		if count == 0 || count > uint64(len(decoder.data)) {
+			return nil, MalformedMessageError
+		}
+		message.Route = make([]string, count)
+		for i := range message.Route {
+			message.Route[i] = decoder.string()
+		}
+	}
+	if flags&flagTime != 0 {
+		message.Time = time.Unix(0, decoder.varint())
+	}
+	message.Sequence = decoder.uvarint()
+	message.Signature = hex.EncodeToString([]byte(decoder.string()))
+	if decoder.err != nil {
+		return nil, decoder.err
+	}
+	content := decoder.data
+	if flags&flagCompressed != 0 {
+		// The decompressed size is limited so that a small frame cannot exhaust memory
+		reader := flate.NewReader(bytes.NewReader(content))
+		inflated, err := io.ReadAll(io.LimitReader(reader, maxFrameSize+1))
+		if err != nil {
+			return nil, errors.Wrap(MalformedMessageError, err.Error())
+		}
+		if len(inflated) > maxFrameSize {
+			return nil, errors.Wrap(MalformedMessageError, "decompressed content exceeds the maximum frame size")
+		}
+		content = inflated
+	}
+	if flags&flagCompact != 0 {
+		payload, err := decodePayload(message.Kind, content)
+		if err != nil {
+			return nil, err
+		}
+		message.Content = payload
+	} else {
+		message.Content = string(content)
+	}
+	return &message, nil
+}
+
+func writeFrame(w io.Writer, message *Message, version int) error {
+	body, err := encodeMessage(message, version)
+	if err != nil {
+		return err
+	}
+	frame := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(body)), uint32(len(body)))
+	_, err = w.Write(append(frame, body...))
+	return err
+}
+
+// readFrame reads a single framed message. Frames which cannot be decoded are consumed entirely, so a
+// MalformedMessageError does not desynchronize the stream.
+func readFrame(reader *bufio.Reader) (*Message, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > maxFrameSize {
+		return nil, errors.New(fmt.Sprintf("frame of %d bytes exceeds the maximum frame size", size))
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return nil, err
+	}
+	return decodeMessage(body)
+}
+
+// codec reads and writes the messages of a registered connection using the negotiated protocol version
+type codec struct {
+	version int
+	conn    net.Conn
+	reader  *bufio.Reader
+}
+
+func newCodec(conn net.Conn, reader *bufio.Reader, version int) *codec {
+	return &codec{version: version, conn: conn, reader: reader}
+}
+
+func (c *codec) read() (*Message, error) {
+	if c.version >= framedProtocolVersion {
+		return readFrame(c.reader)
+	}
+	for {
+		line, err := c.reader.ReadBytes('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		var message Message
+		if err := json.Unmarshal(line, &message); err != nil {
+			return nil, errors.Wrap(MalformedMessageError, err.Error())
+		}
+		return &message, nil
+	}
+}
+
+func (c *codec) write(message *Message) error {
+	if c.version >= framedProtocolVersion {
+		return writeFrame(c.conn, message, c.version)
+	}
+	return writeMessage(c.conn, message)
+}
@@ -0,0 +1,206 @@
+package networking
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	. "github.com/nosyliam/revolution/pkg/common"
+	"github.com/nosyliam/revolution/pkg/logging"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testMessages() []*Message {
+	sess := newSession("secret", "a", "b")
+	messages := []*Message{
+		{Kind: VicDetectMessageKind, Sender: "Host/Alt", Receiver: MainReceiver, Content: "{\"Field\":\"Rose\"}\n", ID: "0123456789abcdef", Time: time.Date(2024, 1, 1, 12, 0, 0, 1, time.UTC), Route: []string{"Host/User/Relay"}},
+		{Kind: ConnectedIdentitiesMessageKind, Sender: RelayReceiver, Receiver: BroadcastReceiver, Content: strings.Repeat(`{"Identity":"Host/Alt"},`, 100)},
+		{Kind: ShutdownMessageKind},
+	}
+	for _, message := range messages {
+		sess.sign(message)
+	}
+	return messages
+}
+
+func TestCodec(t *testing.T) {
+	for _, version := range []int{legacyProtocolVersion, framedProtocolVersion, compactProtocolVersion} {
+		var buf bytes.Buffer
+		for _, message := range testMessages() {
+			if version >= framedProtocolVersion {
+				assert.NoError(t, writeFrame(&buf, message, version))
+			} else {
+				data, _ := json.Marshal(message)
+				buf.Write(append(data, "\r\n"...))
+			}
+		}
+		stream := newCodec(nil, bufio.NewReader(&buf), version)
+		receiver := newSession("secret", "a", "b")
+		for _, expected := range testMessages() {
+			message, err := stream.read()
+			assert.NoError(t, err)
+			assert.NoError(t, receiver.verify(message), "signatures survive encoding")
+			assert.Equal(t, expected.Content, message.Content)
+			assert.Equal(t, expected.Sender, message.Sender)
+			assert.True(t, expected.Time.Equal(message.Time))
+		}
+	}
+
+	large := testMessages()[1]
+	legacy, _ := json.Marshal(large)
+	framed, err := encodeMessage(large, framedProtocolVersion)
+	assert.NoError(t, err)
+	assert.Less(t, len(framed), len(legacy)/4, "large payloads are compressed")
+	assert.NotZero(t, framed[0]&flagCompressed)
+
+	// Malformed frames are skipped without losing the following frames
+	var buf bytes.Buffer
+	buf.Write([]byte{0, 0, 0, 2, flagTime, byte(UnknownMessageKind)})
+	assert.NoError(t, writeFrame(&buf, testMessages()[0], protocolVersion))
+	stream := newCodec(nil, bufio.NewReader(&buf), framedProtocolVersion)
+	_, err = stream.read()
+	assert.ErrorIs(t, err, MalformedMessageError)
+	message, err := stream.read()
+	assert.NoError(t, err)
+	assert.Equal(t, VicDetectMessageKind, message.Kind)
+
+	buf.Reset()
+	buf.Write(binary.BigEndian.AppendUint32(nil, maxFrameSize+1))
+	_, err = newCodec(nil, bufio.NewReader(&buf), framedProtocolVersion).read()
+	assert.ErrorContains(t, err, "exceeds the maximum frame size")
+}
+
+func TestCodec_CompactPayload(t *testing.T) {
+	sess := newSession("secret", "a", "b")
+	receiver := newSession("secret", "a", "b")
+	for _, content := range []interface{}{
+		HeartbeatMessage{Sent: time.Now()},
+		AckHeartbeatMessage{Sent: time.Now().UTC()},
+		DeliveryAckMessage{ID: "0123456789abcdef"},
+		VicDetectMessage{GameInstance: "0123456789abcdef", Field: "Rose", Time: time.Now()},
+		SetRoleMessage{Role: SearcherClientRole},
+		ShutdownMessage{},
+	} {
+		data, _ := json.Marshal(content)
+		message := &Message{Kind: MessageKinds.Determine(content), Sender: "Host/Alt", Content: string(data)}
+		sess.sign(message)
+
+		framed, err := encodeMessage(message, framedProtocolVersion)
+		assert.NoError(t, err)
+		compact, err := encodeMessage(message, compactProtocolVersion)
+		assert.NoError(t, err)
+		assert.NotZero(t, compact[0]&flagCompact)
+		assert.Less(t, len(compact), len(framed))
+
+		decoded, err := decodeMessage(compact)
+		assert.NoError(t, err)
+		assert.Equal(t, message.Content, decoded.Content)
+		assert.NoError(t, receiver.verify(decoded), "signatures survive the compact payload")
+	}
+
+	// Content which cannot be rebuilt from its fields is sent as is
+	for _, content := range []string{`{"Sent": "2024-01-01T12:00:00Z"}`, `{"Sent":"2024-01-01T12:00:00Z","Extra":1}`, `[]`} {
+		message := &Message{Kind: HeartbeatMessageKind, Content: content}
+		data, err := encodeMessage(message, compactProtocolVersion)
+		assert.NoError(t, err)
+		assert.Zero(t, data[0]&flagCompact)
+		decoded, err := decodeMessage(data)
+		assert.NoError(t, err)
+		assert.Equal(t, content, decoded.Content)
+	}
+
+	// Clients which advertised the framed protocol never receive compact payloads
+	assert.Equal(t, framedProtocolVersion, negotiateVersion(framedProtocolVersion))
+	assert.Equal(t, compactProtocolVersion, negotiateVersion(compactProtocolVersion))
+}
+
+func TestRelay_LegacyClient(t *testing.T) {
+	network := newTestNetwork(t)
+	client := NewClient(network.account("Alt"), logging.NewLogger("Alt", nil))
+	stream, err := network.connect(client, network.relay.pairingCode)
+	assert.NoError(t, err)
+	assert.Equal(t, protocolVersion, stream.version)
+
+	// Clients which do not advertise a version keep exchanging JSON
+	conn, err := tls.Dial("tcp", network.address, clientTLSConfig(network.relay.fingerprint, nil))
+	assert.NoError(t, err)
+	defer conn.Close()
+	assert.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+	reader := bufio.NewReader(conn)
+	message, err := readMessage(reader, ChallengeMessageKind)
+	assert.NoError(t, err)
+	var challenge ChallengeMessage
+	assert.NoError(t, json.Unmarshal([]byte(message.Content), &challenge))
+	sess := newSession(network.relay.state.Object().Networking.Object().NetworkKey, challenge.Nonce, "nonce")
+	data, _ := json.Marshal(RegistrationMessage{Identity: "Legacy", Nonce: "nonce"})
+	registration := &Message{Kind: RegistrationMessageKind, Sender: "Legacy", Receiver: RelayReceiver, Content: string(data)}
+	sess.sign(registration)
+	assert.NoError(t, writeMessage(conn, registration))
+	message, err = readMessage(reader, AckRegistrationMessageKind)
+	assert.NoError(t, err)
+	var ack AckRegistrationMessage
+	assert.NoError(t, json.Unmarshal([]byte(message.Content), &ack))
+	assert.Empty(t, ack.Error)
+	assert.Equal(t, legacyProtocolVersion, ack.Version)
+	assert.NoError(t, sess.verify(message))
+
+	// Messages from framed clients are forwarded to legacy clients as JSON
+	client.Send("Legacy", VicDetectMessage{Field: "Rose"})
+	for {
+		message, err = newCodec(conn, reader, legacyProtocolVersion).read()
+		assert.NoError(t, err)
+		if err != nil || message.Kind == VicDetectMessageKind {
+			break
+		}
+	}
+	assert.NoError(t, sess.verify(message))
+	assert.Equal(t, client.Identity(), message.Sender)
+}
+
+func FuzzDecodeMessage(f *testing.F) {
+	for _, message := range testMessages() {
+		data, err := encodeMessage(message, protocolVersion)
+		assert.NoError(f, err)
+		f.Add(data)
+	}
+	f.Add([]byte{flagCompressed, 0, 0, 0, 0, 0, 0, 0xff})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		message, err := decodeMessage(data)
+		if err != nil {
+			return
+		}
+		// Every message which can be decoded survives encoding again
+		encoded, err := encodeMessage(message, protocolVersion)
+		if err != nil {
+			return
+		}
+		decoded, err := decodeMessage(encoded)
+		assert.NoError(t, err)
+		assert.Equal(t, message.Content, decoded.Content)
+		assert.Equal(t, message.Signature, decoded.Signature)
+		assert.Equal(t, message.Sequence, decoded.Sequence)
+		assert.True(t, message.Time.Equal(decoded.Time))
+	})
+}
+
+func FuzzReadFrame(f *testing.F) {
+	for _, message := range testMessages() {
+		var buf bytes.Buffer
+		assert.NoError(f, writeFrame(&buf, message, protocolVersion))
+		f.Add(buf.Bytes())
+	}
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		reader := bufio.NewReader(bytes.NewReader(data))
+		for i := 0; i < 8; i++ {
+			if _, err := readFrame(reader); err != nil && !errors.Is(err, MalformedMessageError) {
+				return
+			}
+		}
+	})
+}
@@ -8,6 +8,12 @@ import (
 	"time"
 )
 
+// ReliableMessageKinds are acknowledged by their receivers, and retransmitted by their senders until they are
+var ReliableMessageKinds = map[MessageKind]bool{
+	VicDetectMessageKind:   true,
+	NightDetectMessageKind: true,
+}
+
 type MessageKindEnumerator []MessageKind
 
 var MessageKinds = MessageKindEnumerator{
@@ -20,6 +26,14 @@ var MessageKinds = MessageKindEnumerator{
 	NightDetectMessageKind,
 	SearchedServerMessageKind,
 	ShutdownMessageKind,
+	ChallengeMessageKind,
+	HeartbeatMessageKind,
+	AckHeartbeatMessageKind,
+	DeliveryAckMessageKind,
+	ResponseMessageKind,
+	StatusQueryMessageKind,
+	ExecCommandMessageKind,
+	StatusSnapshotMessageKind,
 }
 
 func (m *MessageKindEnumerator) Determine(data interface{}) MessageKind {
@@ -40,16 +54,49 @@ func (m *MessageKindEnumerator) Determine(data interface{}) MessageKind {
 		return SearchedServerMessageKind
 	case NightDetectMessage:
 		return NightDetectMessageKind
+	case ChallengeMessage:
+		return ChallengeMessageKind
+	case HeartbeatMessage:
+		return HeartbeatMessageKind
+	case AckHeartbeatMessage:
+		return AckHeartbeatMessageKind
+	case DeliveryAckMessage:
+		return DeliveryAckMessageKind
+	case ShutdownMessage:
+		return ShutdownMessageKind
+	case ResponseMessage:
+		return ResponseMessageKind
+	case StatusQueryMessage:
+		return StatusQueryMessageKind
+	case ExecCommandMessage:
+		return ExecCommandMessageKind
+	case StatusSnapshotMessage:
+		return StatusSnapshotMessageKind
 	}
 	return UnknownMessageKind
 }
 
+// ChallengeMessage is sent by the relay when a client connects. The client must prove that it holds the network key
+// or the relay's pairing code by signing its registration with a key derived from the nonce.
+type ChallengeMessage struct {
+	Nonce string
+	// Salt of the current pairing key
+	Salt string `json:",omitempty"`
+}
+
 type RegistrationMessage struct {
 	Identity string
+	Nonce    string
+	Pairing  bool
+	Version  int  `json:",omitempty"` // The highest protocol version supported by the client
+	Bridge   bool `json:",omitempty"` // Set by relays bridging their network to this relay
 }
 
 type AckRegistrationMessage struct {
-	Error string
+	Error   string
+	Key     string `json:",omitempty"` // The sealed network key, sent to clients which registered with a pairing code
+	Version int    `json:",omitempty"` // The protocol version used once registered
+	Relay   string `json:",omitempty"` // The identity of the relay, which namespaces the identities bridged from it
 }
 
 type ConnectedIdentitiesMessage struct {
@@ -83,21 +130,105 @@ type SearchedServerMessage struct {
 	Server SearchedServer
 }
 
+// HeartbeatMessage is sent to every client by the relay, which echoes the time back to measure its latency
+type HeartbeatMessage struct {
+	Sent time.Time
+}
+
+type AckHeartbeatMessage struct {
+	Sent time.Time
+}
+
+// DeliveryAckMessage acknowledges the receipt of a reliable message
+type DeliveryAckMessage struct {
+	ID string
+}
+
 type EmptyMessage struct{}
 
-type ShutdownMessage EmptyMessage
+// ResponseMessage answers the request with the given ID. The result is the JSON encoding of the value returned by
+// the receiver's handler.
+type ResponseMessage struct {
+	Request string
+	Error   string          `json:",omitempty"`
+	Result  json.RawMessage `json:",omitempty"`
+}
+
+// StatusQueryMessage requests the StatusReply of another client
+type StatusQueryMessage struct{}
+
+type StatusReply struct {
+	Account string
+	Status  string
+	Running bool
+	Paused  bool
+	Role    ClientRole `json:",omitempty"`
+	Routine string     `json:",omitempty"` // The routine being executed, from the top of the macro's stack
+	Field   string     `json:",omitempty"` // The field of the vicious bee being hunted
+	Server  string     `json:",omitempty"`
+}
+
+// StatusSnapshotMessage is published to the main periodically by every other client, so that the main can monitor
+// the whole network
+type StatusSnapshotMessage struct {
+	StatusReply
+}
+
+// ExecCommandMessage requests another client to run one of its macro's commands, such as execroutine or execpattern
+type ExecCommandMessage struct {
+	Command string
+	Args    []string
+}
+
+// ShutdownMessage is broadcast by a relay which is stopping. The successor, if any, is expected to start a relay in
+// its place.
+type ShutdownMessage struct {
+	Successor string `json:",omitempty"`
+}
+
+// deduplicator remembers the IDs of recently received messages so that retransmissions are only handled once
+type deduplicator struct {
+	seen map[string]time.Time
+	ttl  time.Duration
+}
+
+func newDeduplicator(ttl time.Duration) *deduplicator {
+	return &deduplicator{seen: make(map[string]time.Time), ttl: ttl}
+}
+
+func (d *deduplicator) duplicate(message *Message) bool {
+	if message.ID == "" {
+		return false
+	}
+	if _, ok := d.seen[message.ID]; ok {
+		return true
+	}
+	now := time.Now()
+	for id, received := range d.seen {
+		if now.Sub(received) > d.ttl {
+			delete(d.seen, id)
+		}
+	}
+	d.seen[message.ID] = now
+	return false
+}
 
 func SubscribeMessage[T any](macro *Macro, callback func(message *T)) {
 	var t T
 	kind := MessageKinds.Determine(t)
 	watcher := macro.Network.Client.Subscribe(kind)
 	macro.Network.Watchers = append(macro.Network.Watchers, watcher)
+	// Messages are retransmitted until their acknowledgement arrives, so they can be received more than once
+	dedupe := newDeduplicator(2 * deliveryTimeout)
 	go func() {
 		for {
 			message, ok := <-watcher
 			if message == nil || !ok {
 				return
 			}
+			if dedupe.duplicate(message) {
+				continue
+			}
 			var msg T
 			if err := json.Unmarshal([]byte(message.Content), &msg); err != nil {
 				macro.Error <- fmt.Sprintf("Unable to decode message from %s: %v", message.Sender, err)
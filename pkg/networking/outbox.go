@@ -0,0 +1,76 @@
+package networking
+
+import (
+	"crypto/tls"
+	. "github.com/nosyliam/revolution/pkg/common"
+	"net"
+	"sync"
+	"time"
+)
+
+// Messages which have been queued for a connection but not yet written. Connections which fall this far behind are
+// closed instead of delaying the messages of every other connection.
+const outboxSize = 256
+
+// outbox writes the messages sent over a connection on its own goroutine, so that the relay is never locked while
+// writing. Messages are signed before they are queued, and written in the order they were signed.
+type outbox struct {
+	conn     net.Conn
+	codec    *codec
+	messages chan *Message
+	done     chan struct{}
+	once     sync.Once
+	failed   func(err error)
+}
+
+func newOutbox(conn net.Conn, stream *codec, failed func(err error)) *outbox {
+	o := &outbox{
+		conn:     conn,
+		codec:    stream,
+		messages: make(chan *Message, outboxSize),
+		done:     make(chan struct{}),
+		failed:   failed,
+	}
+	go o.run()
+	return o
+}
+
+// send queues a message, returning false if the queue is full
+func (o *outbox) send(message *Message) bool {
+	select {
+	case o.messages <- message:
+		return true
+	default:
+		return false
+	}
+}
+
+func (o *outbox) run() {
+	for {
+		select {
+		case message := <-o.messages:
+			// Connections which stop accepting messages would otherwise block the writer forever
+			_ = o.conn.SetWriteDeadline(time.Now().Add(writeTimeout.get()))
+			if err := o.codec.write(message); err != nil {
+				o.failed(err)
+				_ = abort(o.conn)
+				return
+			}
+		case <-o.done:
+			return
+		}
+	}
+}
+
+func (o *outbox) close() {
+	o.once.Do(func() { close(o.done) })
+}
+
+// abort closes a connection without notifying the peer. Closing a TLS connection sends an alert first, which blocks
+// for as long as the peer is not reading.
+func abort(conn net.Conn) error {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		return tlsConn.NetConn().Close()
+	}
+	return conn.Close()
+}
@@ -0,0 +1,108 @@
+package networking
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	. "github.com/nosyliam/revolution/pkg/common"
+	"github.com/pkg/errors"
+	"reflect"
+	"time"
+)
+
+// The compact payload of a message is the value of each of its fields in declaration order:
+//
+//	string   uvarint length + bytes
+//	int      varint
+//	bool     uvarint 0 or 1
+//	time     varint unix nanoseconds, followed by the zone offset in seconds as a varint
+//
+// Signatures cover the JSON content, so the receiver rebuilds it by marshaling the decoded fields. Content which
+// cannot be rebuilt exactly, such as messages carrying fields unknown to this version, is sent as JSON instead. The
+// fields of these messages are part of the protocol, and must not be changed without a new protocol version.
+var compactMessageTypes = map[MessageKind]reflect.Type{
+	SetRoleMessageKind:      reflect.TypeOf(SetRoleMessage{}),
+	AckSetRoleMessageKind:   reflect.TypeOf(AckSetRoleMessage{}),
+	VicDetectMessageKind:    reflect.TypeOf(VicDetectMessage{}),
+	NightDetectMessageKind:  reflect.TypeOf(NightDetectMessage{}),
+	HeartbeatMessageKind:    reflect.TypeOf(HeartbeatMessage{}),
+	AckHeartbeatMessageKind: reflect.TypeOf(AckHeartbeatMessage{}),
+	DeliveryAckMessageKind:  reflect.TypeOf(DeliveryAckMessage{}),
+	ShutdownMessageKind:     reflect.TypeOf(ShutdownMessage{}),
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// encodePayload returns the compact payload of the content, or false if the message must be sent as JSON
+func encodePayload(kind MessageKind, content string) ([]byte, bool) {
+	typ, ok := compactMessageTypes[kind]
+	if !ok {
+		return nil, false
+	}
+	value := reflect.New(typ).Elem()
+	if err := json.Unmarshal([]byte(content), value.Addr().Interface()); err != nil {
+		return nil, false
+	}
+	var buf []byte
+	for i := 0; i < typ.NumField(); i++ {
+		field := value.Field(i)
+		switch {
+		case field.Type() == timeType:
+			t := field.Interface().(time.Time)
+			_, offset := t.Zone()
+			buf = binary.AppendVarint(buf, t.UnixNano())
+			buf = binary.AppendVarint(buf, int64(offset))
+		case field.Kind() == reflect.String:
+			buf = appendString(buf, field.String())
+		case field.Kind() == reflect.Int:
+			buf = binary.AppendVarint(buf, field.Int())
+		case field.Kind() == reflect.Bool:
+			var flag uint64
+			if field.Bool() {
+				flag = 1
+			}
+			buf = binary.AppendUvarint(buf, flag)
+		default:
+			return nil, false
+		}
+	}
+	if decoded, err := decodePayload(kind, buf); err != nil || decoded != content {
+		return nil, false
+	}
+	return buf, true
+}
+
+// decodePayload rebuilds the JSON content of a compact payload
+func decodePayload(kind MessageKind, data []byte) (string, error) {
+	typ, ok := compactMessageTypes[kind]
+	if !ok {
+		return "", errors.Wrap(MalformedMessageError, "message kind has no compact payload")
+	}
+	value := reflect.New(typ).Elem()
+	decoder := frameDecoder{data: data}
+	for i := 0; i < typ.NumField(); i++ {
+		field := value.Field(i)
+		switch {
+		case field.Type() == timeType:
+			nanoseconds := decoder.varint()
+			offset := decoder.varint()
+			field.Set(reflect.ValueOf(time.Unix(0, nanoseconds).In(time.FixedZone("", int(offset)))))
+		case field.Kind() == reflect.String:
+			field.SetString(decoder.string())
+		case field.Kind() == reflect.Int:
+			field.SetInt(decoder.varint())
+		case field.Kind() == reflect.Bool:
+			field.SetBool(decoder.uvarint() != 0)
+		}
+	}
+	if decoder.err != nil {
+		return "", decoder.err
+	}
+	if len(decoder.data) > 0 {
+		return "", errors.Wrap(MalformedMessageError, "trailing bytes after compact payload")
+	}
+	data, err := json.Marshal(value.Interface())
+	if err != nil {
+		return "", errors.Wrap(MalformedMessageError, err.Error())
+	}
+	return string(data), nil
+}
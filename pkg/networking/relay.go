@@ -2,6 +2,7 @@ package networking
 
 import (
 	"bufio"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"github.com/grandcat/zeroconf"
@@ -13,6 +14,7 @@ import (
 	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -20,19 +22,70 @@ const BroadcastReceiver = "!BROADCAST"
 const RelayReceiver = "!RELAY"
 const MainReceiver = "!main"
 
+const handshakeTimeout = 10 * time.Second
+
+// Peers which do not accept a message within this long are evicted
+var writeTimeout = newInterval(5 * time.Second)
+
+// Clients which miss this many heartbeats in a row are evicted. Clients disconnect from relays which stop sending them
+// for as long, and start reconnecting.
+const missedHeartbeats = 3
+
+var heartbeatInterval = newInterval(5 * time.Second)
+
+func heartbeatTimeout() time.Duration {
+	return time.Duration(missedHeartbeats) * heartbeatInterval.get()
+}
+
+// interval is a timing which tests shorten while the connections of other tests may still be running
+type interval struct {
+	value atomic.Int64
+}
+
+func newInterval(value time.Duration) *interval {
+	i := &interval{}
+	i.set(value)
+	return i
+}
+
+func (i *interval) get() time.Duration {
+	return time.Duration(i.value.Load())
+}
+
+func (i *interval) set(value time.Duration) {
+	i.value.Store(int64(value))
+}
+
+type peer struct {
+	conn     net.Conn
+	outbox   *outbox
+	session  *session
+	lastSeen time.Time
+	latency  time.Duration
+
+	// Relays bridging their network to this relay, and the identities connected to them
+	bridge bool
+	remote []config.NetworkIdentity
+}
+
 type Relay struct {
-	mu         sync.Mutex
-	client     *Client
-	server     *zeroconf.Server
-	listener   net.Listener
-	port       int
-	identities map[string]net.Conn
-	roles      map[string]ClientRole
-	banned     map[string]bool
-	state      *config.Object[config.MacroState]
-	logger     *logging.Logger
-	stop       chan struct{}
-	actionTime time.Time
+	mu              sync.Mutex
+	client          *Client
+	server          *zeroconf.Server
+	listener        net.Listener
+	port            int
+	identities      map[string]*peer
+	roles           map[string]ClientRole
+	banned          map[string]bool
+	state           *config.Object[config.MacroState]
+	logger          *logging.Logger
+	stop            chan struct{}
+	actionTime      time.Time
+	pairingCode     string
+	pairingKey      *pairingKey
+	pairingAttempts int
+	fingerprint     string
+	upstream        *bridge
 }
 
 func getRandomOpenPort() (int, error) {
@@ -54,7 +107,7 @@ func NewRelay(client *Client, state *config.Object[config.MacroState], logger *l
 	return &Relay{
 		client:     client,
 		port:       port,
-		identities: make(map[string]net.Conn),
+		identities: make(map[string]*peer),
 		banned:     make(map[string]bool),
 		roles:      make(map[string]ClientRole),
 		state:      state,
@@ -66,6 +119,42 @@ func (r *Relay) Identity() string {
 	return getIdentity() + "/" + r.state.Object().AccountName
 }
 
+// networkKey returns the key shared by every account paired with this relay, generating one if none exists
+func (r *Relay) networkKey() (string, error) {
+	if key := r.state.Object().Networking.Object().NetworkKey; key != "" {
+		return key, nil
+	}
+	key, err := generateNetworkKey()
+	if err != nil {
+		return "", err
+	}
+	if err := r.state.SetPath("networking.networkKey", key); err != nil {
+		return "", errors.Wrap(err, "failed to save network key")
+	}
+	return key, nil
+}
+
+// RefreshPairingCode replaces the pairing code displayed in the UI. Each code may only be used once.
+func (r *Relay) RefreshPairingCode() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.refreshPairingCodeLocked()
+}
+
+func (r *Relay) refreshPairingCodeLocked() error {
+	code, err := generatePairingCode()
+	if err != nil {
+		return err
+	}
+	key, err := newPairingKey(code)
+	if err != nil {
+		return err
+	}
+	r.pairingCode, r.pairingKey = code, key
+	r.pairingAttempts = 0
+	return r.state.SetPath("networking.pairingCode", code)
+}
+
 func (r *Relay) Start() error {
 	if time.Now().Sub(r.actionTime) < time.Second {
 		return nil
@@ -76,16 +165,31 @@ func (r *Relay) Start() error {
 	var err error
 	defer r.state.SetPath("networking.relayStarting", false)
 	r.state.SetPath("networking.relayStarting", true)
-	r.listener, err = net.Listen("tcp", fmt.Sprintf(":%d", r.port))
+	if _, err = r.networkKey(); err != nil {
+		return err
+	}
+	if err = r.refreshPairingCodeLocked(); err != nil {
+		return err
+	}
+	cert, err := loadCertificate(r.state)
+	if err != nil {
+		return errors.Wrap(err, "failed to load certificate")
+	}
+	r.fingerprint = Fingerprint(cert.Certificate[0])
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", r.port))
 	if err != nil {
 		return err
 	}
+	r.listener = tls.NewListener(listener, serverTLSConfig(cert))
 
-	if err := r.client.Connect(r.listener.Addr().String()); err != nil {
+	if err := r.client.connect(r.listener.Addr().String(), r.fingerprint); err != nil {
 		return errors.Wrap(err, "failed to connect to local relay")
 	}
 
-	txtRecords := []string{fmt.Sprintf("identity=%s", url.QueryEscape(r.Identity()))}
+	txtRecords := []string{
+		fmt.Sprintf("identity=%s", url.QueryEscape(r.Identity())),
+		fmt.Sprintf("fingerprint=%s", r.fingerprint),
+	}
 	r.server, err = zeroconf.Register("RevolutionMacro", "_revolution._tcp", "local.", r.port, txtRecords, nil)
 	if err != nil {
 		return errors.Wrap(err, "failed to start zeroconf")
@@ -93,10 +197,17 @@ func (r *Relay) Start() error {
 
 	r.state.SetPath("networking.relayActive", true)
 
+	stop := make(chan struct{})
+	r.stop = stop
+	go r.monitor(stop)
+	if networking := r.state.Object().Networking.Object(); networking.Upstream != "" && networking.UpstreamKey != "" {
+		r.upstream = newBridge(r, networking.Upstream, "")
+		go r.upstream.run()
+	}
 	go func() {
 		for {
 			select {
-			case <-r.stop:
+			case <-stop:
 				return
 			default:
 				conn, err := r.listener.Accept()
@@ -117,124 +228,373 @@ func (r *Relay) Start() error {
 }
 
 func (r *Relay) Ban(identity string) {
-	conn, ok := r.identities[identity]
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	peer, ok := r.identities[identity]
 	if !ok {
 		return
 	}
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	conn.Close()
+	peer.conn.Close()
 	r.banned[identity] = true
 	delete(r.identities, identity)
 	delete(r.roles, identity)
-	r.broadcastIdentities()
+	r.broadcastIdentitiesLocked()
+}
+
+func (r *Relay) Active() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stop != nil
 }
 
 func (r *Relay) Stop() {
-	if time.Now().Sub(r.actionTime) < time.Second {
+	if time.Now().Sub(r.actionTime) < time.Second || !r.Active() {
 		return
 	}
+	// The relay's own client must not try to reconnect to it
+	r.client.Disconnect()
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	r.actionTime = time.Now()
+	data, _ := json.Marshal(ShutdownMessage{Successor: r.successorLocked()})
 	var message = Message{
 		Kind:     ShutdownMessageKind,
 		Receiver: BroadcastReceiver,
 		Sender:   RelayReceiver,
-		Content:  "{}",
+		Content:  string(data),
 	}
 	r.handleMessage(&message)
 	r.state.SetPath("networking.relayActive", false)
+	r.state.SetPath("networking.pairingCode", "")
+	if r.stop != nil {
+		close(r.stop)
+		r.stop = nil
+	}
+	if r.upstream != nil {
+		r.upstream.closeLocked()
+		r.upstream = nil
+	}
+	r.pairingCode, r.pairingKey = "", nil
 	r.listener.Close()
 	r.server.Shutdown()
-	for _, conn := range r.identities {
-		conn.Close()
+	for _, peer := range r.identities {
+		peer.conn.Close()
 	}
 	clear(r.identities)
 	clear(r.roles)
 }
 
-func (r *Relay) handleConnection(conn net.Conn) {
-	reader := bufio.NewReader(conn)
-	data, err := reader.ReadString('\n')
+// successorLocked returns the identity which should take over from the relay when it stops. Like in an election, the
+// lowest identity is chosen.
+func (r *Relay) successorLocked() string {
+	var successor string
+	for identity, peer := range r.identities {
+		if peer.bridge || identity == r.Identity() {
+			continue
+		}
+		if successor == "" || identity < successor {
+			successor = identity
+		}
+	}
+	return successor
+}
+
+func writeMessage(conn net.Conn, message *Message) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(append(data, "\r\n"...))
+	return err
+}
+
+func (r *Relay) rejectRegistration(conn net.Conn, identity string, reason string) {
+	r.logger.Log(0, logging.Warning, fmt.Sprintf("[Relay]: Rejected registration from client %s: %s", conn.RemoteAddr().String(), reason))
+	data, _ := json.Marshal(AckRegistrationMessage{Error: reason})
+	_ = writeMessage(conn, &Message{
+		Kind:     AckRegistrationMessageKind,
+		Receiver: identity,
+		Sender:   RelayReceiver,
+		Content:  string(data),
+	})
+	conn.Close()
+}
+
+// authenticate challenges a new connection and verifies the signature of its registration. The returned session is
+// used to verify and sign every following message.
+func (r *Relay) authenticate(conn net.Conn, reader *bufio.Reader) (*RegistrationMessage, *session, *AckRegistrationMessage, bool) {
+	nonce, err := randomHex(nonceSize)
+	if err != nil {
+		r.logger.Log(0, logging.Error, fmt.Sprintf("[Relay]: Failed to generate challenge: %v", err))
+		conn.Close()
+		return nil, nil, nil, false
+	}
+	r.mu.Lock()
+	challenge, pairing := ChallengeMessage{Nonce: nonce}, r.pairingKey
+	r.mu.Unlock()
+	if pairing != nil {
+		challenge.Salt = pairing.salt
+	}
+	data, _ := json.Marshal(challenge)
+	if err := writeMessage(conn, &Message{Kind: ChallengeMessageKind, Sender: RelayReceiver, Content: string(data)}); err != nil {
+		r.logger.Log(0, logging.Warning, fmt.Sprintf("[Relay]: Failed to send challenge to client %s: %v", conn.RemoteAddr().String(), err))
+		conn.Close()
+		return nil, nil, nil, false
+	}
+
+	line, err := reader.ReadString('\n')
 	if err != nil {
 		r.logger.Log(0, logging.Warning, fmt.Sprintf("[Relay]: Failed to receive registration message from client %s", conn.RemoteAddr().String()))
 		conn.Close()
-		return
+		return nil, nil, nil, false
 	}
 
 	var message Message
-	if err := json.Unmarshal([]byte(data), &message); err != nil || message.Kind != RegistrationMessageKind || message.Receiver != RelayReceiver {
+	if err := json.Unmarshal([]byte(line), &message); err != nil || message.Kind != RegistrationMessageKind || message.Receiver != RelayReceiver {
 		r.logger.Log(0, logging.Warning, fmt.Sprintf("[Relay]: Failed to decode message from client %s: %v", conn.RemoteAddr().String(), err))
 		conn.Close()
-		return
+		return nil, nil, nil, false
 	}
 
-	var registrationMessage RegistrationMessage
-	if err = json.Unmarshal([]byte(message.Content), &registrationMessage); err != nil {
+	var registration RegistrationMessage
+	if err = json.Unmarshal([]byte(message.Content), &registration); err != nil || registration.Identity == "" {
 		r.logger.Log(0, logging.Warning, fmt.Sprintf("[Relay]: Failed to decode registration message from client %s", conn.RemoteAddr().String()))
 		conn.Close()
+		return nil, nil, nil, false
+	}
+	identity := registration.Identity
+	var pairingSecret string
+	if registration.Pairing && pairing != nil {
+		pairingSecret = pairing.derive()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.banned[identity] {
+		r.rejectRegistration(conn, identity, "This identity has been banned from the relay")
+		return nil, nil, nil, false
+	}
+
+	if _, ok := r.identities[identity]; ok {
+		r.rejectRegistration(conn, identity, fmt.Sprintf("The identity \"%s\" is already connected to this relay!", identity))
+		return nil, nil, nil, false
+	}
+
+	// A second bridge between the same relays would deliver every message twice
+	if registration.Bridge && r.upstream != nil && r.upstream.identity == identity {
+		r.rejectRegistration(conn, identity, "This relay is already bridged to the relay")
+		return nil, nil, nil, false
+	}
+
+	// The registration is signed with the session key, which proves that the client holds the secret
+	var ack AckRegistrationMessage
+	var secret string
+	if registration.Pairing {
+		// The code may have been replaced since the challenge was sent
+		if r.pairingKey == pairing {
+			secret = pairingSecret
+		}
+	} else if secret, err = r.networkKey(); err != nil {
+		secret = ""
+	}
+	sess := newSession(secret, nonce, registration.Nonce)
+	if secret == "" || sess.verify(&message) != nil {
+		if !registration.Pairing {
+			r.rejectRegistration(conn, identity, "Invalid network key. Pair with the relay using its pairing code.")
+			return nil, nil, nil, false
+		}
+		// Codes are replaced after too many failed attempts to prevent them from being guessed
+		if r.pairingAttempts++; r.pairingAttempts >= maxPairingAttempts {
+			_ = r.refreshPairingCodeLocked()
+		}
+		r.rejectRegistration(conn, identity, "Invalid pairing code")
+		return nil, nil, nil, false
+	}
+
+	if registration.Pairing {
+		key, err := r.networkKey()
+		if err == nil {
+			ack.Key, err = sess.seal(key)
+		}
+		if err != nil {
+			r.logger.Log(0, logging.Error, fmt.Sprintf("[Relay]: Failed to seal network key: %v", err))
+			conn.Close()
+			return nil, nil, nil, false
+		}
+		_ = r.refreshPairingCodeLocked()
+	}
+	ack.Version = negotiateVersion(registration.Version)
+	ack.Relay = r.Identity()
+	return &registration, sess, &ack, true
+}
+
+func (r *Relay) handleConnection(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	_ = conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	registration, sess, ack, ok := r.authenticate(conn, reader)
+	if !ok {
 		return
 	}
+	_ = conn.SetDeadline(time.Time{})
+	identity := registration.Identity
 
-	identity := registrationMessage.Identity
-	ack := Message{
+	// The acknowledgement is the last message sent before switching to the negotiated protocol version
+	data, _ := json.Marshal(ack)
+	message := &Message{
 		Kind:     AckRegistrationMessageKind,
 		Receiver: identity,
 		Sender:   RelayReceiver,
-		Content:  "{}",
+		Content:  string(data),
 	}
-
-	if _, ok := r.identities[identity]; ok {
-		byteData, _ := json.Marshal(AckRegistrationMessage{Error: fmt.Sprintf("The identity \"%s\" is already connected to this relay!", identity)})
-		ack.Content = string(byteData)
-		msg, _ := json.Marshal(ack)
-		conn.Write(append(msg, "\r\n"...))
+	r.mu.Lock()
+	sess.sign(message)
+	if err := writeMessage(conn, message); err != nil {
+		r.mu.Unlock()
+		r.logger.Log(0, logging.Warning, fmt.Sprintf("[Relay]: Failed to acknowledge registration of client %s: %v", identity, err))
 		conn.Close()
 		return
 	}
-
-	r.mu.Lock()
-	r.identities[identity] = conn
-	r.handleMessage(&ack)
+	stream := newCodec(conn, reader, ack.Version)
+	out := newOutbox(conn, stream, func(err error) {
+		r.logger.Log(0, logging.Warning, fmt.Sprintf("[Relay]: Evicting client %s: failed to forward message: %v", identity, err))
+	})
+	r.identities[identity] = &peer{conn: conn, outbox: out, session: sess, lastSeen: time.Now(), bridge: registration.Bridge}
+	if registration.Bridge {
+		r.logger.Log(0, logging.Info, fmt.Sprintf("[Relay]: Bridged network of relay %s", identity))
+	}
 	r.mu.Unlock()
 	r.broadcastIdentities()
 
 	defer func() {
 		r.mu.Lock()
-		delete(r.identities, identity)
-		delete(r.roles, identity)
+		if current, ok := r.identities[identity]; ok && current.conn == conn {
+			delete(r.identities, identity)
+			delete(r.roles, identity)
+			r.broadcastIdentitiesLocked()
+		}
 		r.mu.Unlock()
 		conn.Close()
+		out.close()
 	}()
 
 	for {
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			return
-		}
-		var message Message
-		if err = json.Unmarshal([]byte(line), &message); err != nil {
+		_ = conn.SetReadDeadline(time.Now().Add(heartbeatTimeout()))
+		message, err := stream.read()
+		if errors.Is(err, MalformedMessageError) {
 			r.logger.Log(0, logging.Warning, fmt.Sprintf("[Relay]: Failed to decode message from client %s: %v", identity, err))
 			continue
+		} else if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				r.logger.Log(0, logging.Warning, fmt.Sprintf("[Relay]: Evicting client %s: no heartbeat received", identity))
+			}
+			return
+		}
+		// Unsigned and forged messages are never forwarded, and the client is disconnected
+		if err = sess.verify(message); err != nil {
+			r.logger.Log(0, logging.Warning, fmt.Sprintf("[Relay]: Disconnecting client %s: %v", identity, err))
+			return
+		}
+		// Bridges send messages on behalf of the identities of their network, which are namespaced below
+		bridged := registration.Bridge && message.Receiver != RelayReceiver
+		if message.Sender != identity && !bridged {
+			r.logger.Log(0, logging.Warning, fmt.Sprintf("[Relay]: Disconnecting client %s: message sent on behalf of %s", identity, message.Sender))
+			return
 		}
-		message.Data = []byte(line)
 		r.mu.Lock()
-		r.handleMessage(&message)
+		if peer, ok := r.identities[identity]; ok {
+			peer.lastSeen = time.Now()
+		}
+		if bridged {
+			r.receiveBridgedLocked(identity, message)
+		} else {
+			r.routeMessage(message, identity)
+		}
 		r.mu.Unlock()
 	}
 }
 
-func (r *Relay) broadcastIdentitiesLocked() {
+// monitor sends heartbeats to every client until the relay is stopped
+func (r *Relay) monitor(stop chan struct{}) {
+	ticker := time.NewTicker(heartbeatInterval.get())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.heartbeat()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// heartbeat pings every client and shares the latest presence of each identity with the network
+func (r *Relay) heartbeat() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data, _ := json.Marshal(HeartbeatMessage{Sent: time.Now()})
+	for identity := range r.identities {
+		r.forward(identity, Message{
+			Kind:     HeartbeatMessageKind,
+			Receiver: identity,
+			Sender:   RelayReceiver,
+			Content:  string(data),
+		})
+	}
+	r.broadcastIdentitiesLocked()
+}
+
+func (r *Relay) handleHeartbeat(message *Message) {
+	var content AckHeartbeatMessage
+	if err := json.Unmarshal([]byte(message.Content), &content); err != nil {
+		r.logger.Log(0, logging.Warning, fmt.Sprintf("[Relay]: Failed to unmarshal heartbeat from identity %s", message.Sender))
+		return
+	}
+	if peer, ok := r.identities[message.Sender]; ok {
+		peer.latency = time.Since(content.Sent).Round(time.Microsecond)
+	}
+}
+
+func (r *Relay) connectedIdentitiesLocked() ConnectedIdentitiesMessage {
 	var identities ConnectedIdentitiesMessage
-	for identity, conn := range r.identities {
+	for identity, peer := range r.identities {
+		if peer.bridge {
+			continue
+		}
 		role, _ := r.roles[identity]
 		identities.Identities = append(identities.Identities, config.NetworkIdentity{
-			Address:  conn.RemoteAddr().String(),
+			Address:  peer.conn.RemoteAddr().String(),
 			Identity: identity,
 			Role:     string(role),
+			LastSeen: peer.lastSeen,
+			Latency:  peer.latency,
+			// Identities are stale once they have missed a heartbeat, and are evicted after missing several
+			Stale: time.Since(peer.lastSeen) > 2*heartbeatInterval.get(),
 		})
 	}
+	return identities
+}
+
+// broadcastIdentitiesLocked shares the identities connected to this relay with its clients and bridged relays
+func (r *Relay) broadcastIdentitiesLocked() {
+	r.sendIdentitiesLocked()
+	local := r.connectedIdentitiesLocked().Identities
+	for _, link := range r.linksLocked("") {
+		identities := ConnectedIdentitiesMessage{Identities: append(local, r.remoteIdentitiesLocked(link)...)}
+		data, _ := json.Marshal(identities)
+		r.sendLinkLocked(link, Message{
+			Kind:     ConnectedIdentitiesMessageKind,
+			Receiver: RelayReceiver,
+			Sender:   r.Identity(),
+			Content:  string(data),
+		})
+	}
+}
+
+// sendIdentitiesLocked shares the identities connected to this relay and every bridged relay with its clients
+func (r *Relay) sendIdentitiesLocked() {
+	identities := r.connectedIdentitiesLocked()
+	identities.Identities = append(identities.Identities, r.remoteIdentitiesLocked("")...)
 	data, _ := json.Marshal(identities)
 	var message = Message{
 		Kind:     ConnectedIdentitiesMessageKind,
@@ -287,36 +647,63 @@ func (r *Relay) handleRoleRegistration(message *Message) {
 	r.broadcastIdentitiesLocked()
 }
 
-func (r *Relay) handleMessage(message *Message) {
-	if string(message.Data) == "" {
-		message.Data, _ = json.Marshal(message)
+// forward signs a copy of the message with the session of the receiving identity and queues it for its connection
+func (r *Relay) forward(identity string, message Message) {
+	peer, ok := r.identities[identity]
+	if !ok {
+		return
+	}
+	peer.session.sign(&message)
+	if !peer.outbox.send(&message) {
+		r.logger.Log(0, logging.Warning, fmt.Sprintf("[Relay]: Evicting client %s: too many messages are waiting to be sent", identity))
+		// The connection handler removes the peer once its connection is closed
+		_ = abort(peer.conn)
 	}
+}
+
+// handleMessage routes a message sent by the relay itself. These are never bridged to other networks.
+func (r *Relay) handleMessage(message *Message) {
+	r.routeMessage(message, "")
+}
+
+// routeMessage forwards a message to the clients it is addressed to. Messages from the relay's own network are bridged
+// to every other network unless they are addressed to a single identity, but never back to the network they came from.
+func (r *Relay) routeMessage(message *Message, origin string) {
 	switch message.Receiver {
 	case RelayReceiver:
-		if message.Kind == SetRoleMessageKind {
+		switch message.Kind {
+		case SetRoleMessageKind:
 			r.handleRoleRegistration(message)
+		case AckHeartbeatMessageKind:
+			r.handleHeartbeat(message)
+		case ConnectedIdentitiesMessageKind:
+			r.handleRemoteIdentities(origin, message)
 		}
 	case BroadcastReceiver:
-		for _, conn := range r.identities {
-			conn.Write(append(message.Data, "\r\n"...))
+		for identity, peer := range r.identities {
+			if !peer.bridge {
+				r.forward(identity, *message)
+			}
 		}
-		return
+		r.bridgeLocked(origin, *message)
 	default:
 		if strings.HasPrefix(message.Receiver, "!") {
 			role := strings.TrimPrefix(message.Receiver, "!")
 			for identity, idRole := range r.roles {
 				if string(idRole) == role {
-					r.identities[identity].Write(append(message.Data, "\r\n"...))
+					r.forward(identity, *message)
 				}
 			}
+			r.bridgeLocked(origin, *message)
+		} else if peer, ok := r.identities[message.Receiver]; ok && !peer.bridge {
+			r.forward(message.Receiver, *message)
+		} else if identity, link, ok := splitIdentity(message.Receiver); ok && r.linkedLocked(link) && link != origin {
+			bridged := *message
+			bridged.Receiver = identity
+			r.sendBridgedLocked(link, bridged)
 		} else {
-			if conn, ok := r.identities[message.Receiver]; !ok {
-				r.logger.Log(0, logging.Warning,
-					fmt.Sprintf("[Relay]: Failed to forward message from %s->%s: invalid receiver", message.Sender, message.Receiver))
-				return
-			} else {
-				fmt.Println(conn.Write(append(message.Data, "\r\n"...)))
-			}
+			r.logger.Log(0, logging.Warning,
+				fmt.Sprintf("[Relay]: Failed to forward message from %s->%s: invalid receiver", message.Sender, message.Receiver))
 		}
 	}
 }
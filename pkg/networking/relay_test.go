@@ -0,0 +1,130 @@
+package networking
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	. "github.com/nosyliam/revolution/pkg/common"
+	"github.com/nosyliam/revolution/pkg/config"
+	"github.com/nosyliam/revolution/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRelay_Heartbeat(t *testing.T) {
+	interval := heartbeatInterval.get()
+	heartbeatInterval.set(100 * time.Millisecond)
+	defer heartbeatInterval.set(interval)
+	network := newTestNetwork(t)
+
+	// Pairing takes longer than a heartbeat under the race detector
+	state := network.pairedAccount("Alt")
+	client := NewClient(state, logging.NewLogger("Alt", nil))
+	stream, err := network.connect(client, "")
+	assert.NoError(t, err)
+	go client.listenForMessages(stream)
+
+	// Clients which do not answer heartbeats are evicted
+	silent := NewClient(network.pairedAccount("Silent"), logging.NewLogger("Silent", nil))
+	_, err = network.connect(silent, "")
+	assert.NoError(t, err)
+	assert.True(t, network.connected(silent.Identity()))
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go network.relay.monitor(stop)
+
+	address := client.conn.LocalAddr().String()
+	assert.Eventually(t, func() bool {
+		latency := config.Concrete[time.Duration](state, "networking.connectedIdentities[%s].latency", address)
+		return latency != nil && *latency > 0
+	}, 2*time.Second, 10*time.Millisecond, "latency is shared with every client")
+	assert.False(t, *config.Concrete[bool](state, "networking.connectedIdentities[%s].stale", address))
+	assert.WithinDuration(t, time.Now(), *config.Concrete[time.Time](state, "networking.connectedIdentities[%s].lastSeen", address), time.Second)
+
+	assert.Eventually(t, func() bool { return !network.connected(silent.Identity()) }, 2*time.Second, 10*time.Millisecond)
+	assert.True(t, network.connected(client.Identity()), "responsive clients are kept")
+}
+
+func TestRelay_Stale(t *testing.T) {
+	network := newTestNetwork(t)
+	client := NewClient(network.account("Alt"), logging.NewLogger("Alt", nil))
+	_, err := network.connect(client, network.relay.pairingCode)
+	assert.NoError(t, err)
+
+	network.relay.mu.Lock()
+	defer network.relay.mu.Unlock()
+	identities := network.relay.connectedIdentitiesLocked().Identities
+	assert.Len(t, identities, 1)
+	assert.False(t, identities[0].Stale)
+
+	network.relay.identities[client.Identity()].lastSeen = time.Now().Add(-3 * heartbeatInterval.get())
+	identities = network.relay.connectedIdentitiesLocked().Identities
+	assert.True(t, identities[0].Stale, "identities which miss a heartbeat are stale")
+}
+
+func TestRelay_EvictsStuckClients(t *testing.T) {
+	network := newTestNetwork(t)
+
+	// The client never reads, so its connection eventually stops accepting messages
+	stuck := NewClient(network.pairedAccount("Stuck"), logging.NewLogger("Stuck", nil))
+	_, err := network.connect(stuck, "")
+	assert.NoError(t, err)
+	receiver := NewClient(network.pairedAccount("Alt"), logging.NewLogger("Alt", nil))
+	stream, err := network.connect(receiver, "")
+	assert.NoError(t, err)
+
+	// Random content is not shrunk by compression
+	field := make([]byte, 32*1024)
+	_, _ = rand.Read(field)
+	data, _ := json.Marshal(VicDetectMessage{Field: hex.EncodeToString(field)})
+	message := Message{Kind: VicDetectMessageKind, Sender: RelayReceiver, Receiver: stuck.Identity(), Content: string(data)}
+	begin := time.Now()
+	for i := 0; i < 1000 && network.connected(stuck.Identity()); i++ {
+		network.relay.mu.Lock()
+		network.relay.forward(stuck.Identity(), message)
+		network.relay.mu.Unlock()
+	}
+	assert.Less(t, time.Since(begin), writeTimeout.get(), "the relay is not locked while writing")
+	assert.Eventually(t, func() bool { return !network.connected(stuck.Identity()) }, time.Second, 10*time.Millisecond)
+
+	// Other clients are unaffected
+	data, _ = json.Marshal(VicDetectMessage{Field: "Rose"})
+	network.relay.mu.Lock()
+	network.relay.forward(receiver.Identity(), Message{Kind: VicDetectMessageKind, Sender: RelayReceiver, Receiver: receiver.Identity(), Content: string(data)})
+	network.relay.mu.Unlock()
+	assert.NoError(t, receiver.conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	for {
+		received, err := stream.read()
+		if !assert.NoError(t, err) {
+			return
+		}
+		if received.Kind == VicDetectMessageKind {
+			assert.Equal(t, string(data), received.Content)
+			return
+		}
+	}
+}
+
+func TestRelay_EvictsUnresponsiveClients(t *testing.T) {
+	timeout := writeTimeout.get()
+	writeTimeout.set(100 * time.Millisecond)
+	defer writeTimeout.set(timeout)
+	network := newTestNetwork(t)
+
+	// Peers which stop accepting messages are evicted once the write deadline passes, even if their queue never fills
+	stuck := NewClient(network.pairedAccount("Stuck"), logging.NewLogger("Stuck", nil))
+	_, err := network.connect(stuck, "")
+	assert.NoError(t, err)
+	field := make([]byte, 32*1024)
+	_, _ = rand.Read(field)
+	data, _ := json.Marshal(VicDetectMessage{Field: hex.EncodeToString(field)})
+	message := Message{Kind: VicDetectMessageKind, Sender: RelayReceiver, Receiver: stuck.Identity(), Content: string(data)}
+	for i := 0; i < outboxSize/2 && network.connected(stuck.Identity()); i++ {
+		network.relay.mu.Lock()
+		network.relay.forward(stuck.Identity(), message)
+		network.relay.mu.Unlock()
+	}
+	assert.Eventually(t, func() bool { return !network.connected(stuck.Identity()) }, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,135 @@
+package networking
+
+import (
+	"encoding/json"
+	"fmt"
+	. "github.com/nosyliam/revolution/pkg/common"
+	"github.com/nosyliam/revolution/pkg/logging"
+	"github.com/pkg/errors"
+	"time"
+)
+
+// Requests are ordinary messages which are answered by the handler registered for their kind on the receiving client.
+// The handler's result is sent back in a ResponseMessage carrying the ID of the request, which correlates it with the
+// waiting sender.
+
+const defaultRequestTimeout = 10 * time.Second
+
+var RequestTimeoutError = errors.New("request timed out")
+
+// Handler answers a request. The returned value is encoded as the result of the response.
+type Handler func(message *Message) (interface{}, error)
+
+// RemoteError is returned to the sender of a request whose handler failed
+type RemoteError struct {
+	Receiver string
+	Message  string
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Receiver, e.Message)
+}
+
+// Handle registers the handler answering requests of the given kind. Messages of that kind are no longer delivered
+// to subscribers.
+func (c *Client) Handle(kind MessageKind, handler Handler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handlers[kind] = handler
+}
+
+func (c *Client) handler(kind MessageKind) Handler {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.handlers[kind]
+}
+
+// Request sends a request to a client and waits for its response. A timeout of zero uses the default timeout.
+func (c *Client) Request(receiver string, content interface{}, timeout time.Duration) (*ResponseMessage, error) {
+	if timeout == 0 {
+		timeout = defaultRequestTimeout
+	}
+	message := c.newMessage(receiver, content)
+	response := make(chan *ResponseMessage, 1)
+	c.mu.Lock()
+	if c.conn == nil && !c.reconnect {
+		c.mu.Unlock()
+		return nil, InactiveNetworkError
+	}
+	c.requests[message.ID] = response
+	c.mu.Unlock()
+
+	c.deliver(message)
+	select {
+	case res := <-response:
+		if res == nil {
+			return nil, InactiveNetworkError
+		}
+		if res.Error != "" {
+			return res, &RemoteError{Receiver: receiver, Message: res.Error}
+		}
+		return res, nil
+	case <-time.After(timeout):
+	case <-c.stop:
+	}
+	c.mu.Lock()
+	delete(c.requests, message.ID)
+	c.mu.Unlock()
+	return nil, errors.Wrap(RequestTimeoutError, fmt.Sprintf("no response from %s", receiver))
+}
+
+func (c *Client) handleResponse(message Message) {
+	var data ResponseMessage
+	if err := json.Unmarshal([]byte(message.Content), &data); err != nil {
+		c.logger.Log(0, logging.Warning, fmt.Sprintf("[Client]: Failed to unserialize response: %v", err))
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Responses which arrive after their request timed out are dropped
+	if response, ok := c.requests[data.Request]; ok {
+		response <- &data
+		delete(c.requests, data.Request)
+	}
+}
+
+func (c *Client) handleRequest(message *Message, handler Handler) {
+	response := ResponseMessage{Request: message.ID}
+	result, err := handler(message)
+	if err != nil {
+		response.Error = err.Error()
+	} else if result != nil {
+		if response.Result, err = json.Marshal(result); err != nil {
+			response.Error = fmt.Sprintf("failed to encode result: %v", err)
+		}
+	}
+	c.Send(message.Sender, response)
+}
+
+// Call sends a request to a client and decodes the result of its handler
+func Call[T any](client *Client, receiver string, content interface{}, timeout time.Duration) (*T, error) {
+	response, err := client.Request(receiver, content, timeout)
+	if err != nil {
+		return nil, err
+	}
+	var result T
+	if len(response.Result) == 0 {
+		return &result, nil
+	}
+	if err := json.Unmarshal(response.Result, &result); err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to decode response from %s", receiver))
+	}
+	return &result, nil
+}
+
+// HandleRequest registers a handler for the requests of type T
+func HandleRequest[T any](client *Client, handler func(sender string, request *T) (interface{}, error)) {
+	var t T
+	client.Handle(MessageKinds.Determine(t), func(message *Message) (interface{}, error) {
+		var request T
+		if err := json.Unmarshal([]byte(message.Content), &request); err != nil {
+			return nil, errors.Wrap(err, "failed to decode request")
+		}
+		return handler(message.Sender, &request)
+	})
+}
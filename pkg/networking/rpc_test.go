@@ -0,0 +1,63 @@
+package networking
+
+import (
+	"github.com/nosyliam/revolution/pkg/logging"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestClient_Request(t *testing.T) {
+	network := newTestNetwork(t)
+	clients := make(map[string]*Client)
+	for _, name := range []string{"Main", "Alt"} {
+		client := NewClient(network.account(name), logging.NewLogger(name, nil))
+		stream, err := network.connect(client, network.relay.pairingCode)
+		assert.NoError(t, err)
+		go client.listenForMessages(stream)
+		clients[name] = client
+	}
+	main, alt := clients["Main"], clients["Alt"]
+
+	HandleRequest(alt, func(sender string, _ *StatusQueryMessage) (interface{}, error) {
+		assert.Equal(t, main.Identity(), sender)
+		return StatusReply{Account: "Alt", Status: "Collecting pollen", Running: true}, nil
+	})
+	HandleRequest(alt, func(sender string, request *ExecCommandMessage) (interface{}, error) {
+		return nil, errors.New("Macro not started!")
+	})
+
+	reply, err := Call[StatusReply](main, alt.Identity(), StatusQueryMessage{}, 2*time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "Collecting pollen", reply.Status)
+	assert.True(t, reply.Running)
+
+	_, err = main.Request(alt.Identity(), ExecCommandMessage{Command: "execroutine", Args: []string{"Main"}}, 2*time.Second)
+	var remote *RemoteError
+	assert.ErrorAs(t, err, &remote)
+	assert.Equal(t, "Macro not started!", remote.Message)
+
+	// Requests which are not handled by the receiver time out
+	_, err = alt.Request(main.Identity(), StatusQueryMessage{}, 100*time.Millisecond)
+	assert.ErrorIs(t, err, RequestTimeoutError)
+	alt.mu.Lock()
+	assert.Empty(t, alt.requests)
+	alt.mu.Unlock()
+
+	// Requests waiting for a response fail once the client disconnects
+	done := make(chan error, 1)
+	go func() {
+		_, err := alt.Request(main.Identity(), StatusQueryMessage{}, 5*time.Second)
+		done <- err
+	}()
+	assert.Eventually(t, func() bool {
+		alt.mu.Lock()
+		defer alt.mu.Unlock()
+		return len(alt.requests) == 1
+	}, time.Second, 10*time.Millisecond)
+	alt.Disconnect()
+	assert.ErrorIs(t, <-done, InactiveNetworkError)
+	_, err = alt.Request(main.Identity(), StatusQueryMessage{}, time.Second)
+	assert.ErrorIs(t, err, InactiveNetworkError)
+}
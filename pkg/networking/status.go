@@ -0,0 +1,82 @@
+package networking
+
+import (
+	"encoding/json"
+	"fmt"
+	. "github.com/nosyliam/revolution/pkg/common"
+	"github.com/nosyliam/revolution/pkg/config"
+	"github.com/nosyliam/revolution/pkg/logging"
+	"time"
+)
+
+var statusInterval = 5 * time.Second
+
+// PublishStatus sends a snapshot of the account's status to the main periodically while registered with a relay
+func (c *Client) PublishStatus(snapshot func() StatusReply) {
+	go func() {
+		ticker := time.NewTicker(statusInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.publishStatus(snapshot)
+			case <-c.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (c *Client) publishStatus(snapshot func() StatusReply) {
+	c.mu.Lock()
+	registered, role := c.session != nil, c.role
+	c.mu.Unlock()
+	// Snapshots are not queued while disconnected, since they are replaced by the next one anyway
+	if !registered || role == MainClientRole {
+		return
+	}
+	status := snapshot()
+	status.Role = role
+	c.Send(MainReceiver, StatusSnapshotMessage{StatusReply: status})
+}
+
+func (c *Client) handleStatusSnapshot(message Message) {
+	var data StatusSnapshotMessage
+	if err := json.Unmarshal([]byte(message.Content), &data); err != nil {
+		c.logger.Log(0, logging.Warning, fmt.Sprintf("[Client]: Failed to unserialize status snapshot: %v", err))
+		return
+	}
+	identity := message.Sender
+	if c.state.Object().Networking.Object().Fleet.Lookup(identity) == nil {
+		if err := c.state.AppendPathf("networking.fleet[%s]", identity); err != nil {
+			c.logger.Log(0, logging.Warning, fmt.Sprintf("[Client]: Failed to add %s to the fleet: %v", identity, err))
+			return
+		}
+	}
+	c.state.SetPathf(data.Account, "networking.fleet[%s].account", identity)
+	c.state.SetPathf(data.Status, "networking.fleet[%s].status", identity)
+	c.state.SetPathf(data.Running, "networking.fleet[%s].running", identity)
+	c.state.SetPathf(data.Paused, "networking.fleet[%s].paused", identity)
+	c.state.SetPathf(string(data.Role), "networking.fleet[%s].role", identity)
+	c.state.SetPathf(data.Routine, "networking.fleet[%s].routine", identity)
+	c.state.SetPathf(data.Field, "networking.fleet[%s].field", identity)
+	c.state.SetPathf(data.Server, "networking.fleet[%s].server", identity)
+	c.state.SetPathf(message.Time, "networking.fleet[%s].updated", identity)
+}
+
+// pruneFleet removes the snapshots of identities which are no longer connected to the relay
+func (c *Client) pruneFleet(identities []config.NetworkIdentity) {
+	connected := make(map[string]bool)
+	for _, identity := range identities {
+		connected[identity.Identity] = true
+	}
+	var removed []string
+	c.state.Object().Networking.Object().Fleet.ForEach(func(status *config.FleetStatus) {
+		if !connected[status.Identity] {
+			removed = append(removed, status.Identity)
+		}
+	})
+	for _, identity := range removed {
+		c.state.DeletePathf("networking.fleet[%s]", identity)
+	}
+}
@@ -0,0 +1,53 @@
+package networking
+
+import (
+	. "github.com/nosyliam/revolution/pkg/common"
+	"github.com/nosyliam/revolution/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestClient_PublishStatus(t *testing.T) {
+	network := newTestNetwork(t)
+	mainState := network.account("Main")
+	main := NewClient(mainState, logging.NewLogger("Main", nil))
+	stream, err := network.connect(main, network.relay.pairingCode)
+	assert.NoError(t, err)
+	go main.listenForMessages(stream)
+	assert.NoError(t, main.SetRole(MainClientRole))
+
+	alt := NewClient(network.account("Alt"), logging.NewLogger("Alt", nil))
+	stream, err = network.connect(alt, network.relay.pairingCode)
+	assert.NoError(t, err)
+	go alt.listenForMessages(stream)
+	assert.NoError(t, alt.SetRole(SearcherClientRole))
+
+	snapshot := func() StatusReply {
+		return StatusReply{Account: "Alt", Status: "Searching for vicious bees", Running: true, Routine: "VicSearch", Field: "Rose"}
+	}
+	alt.publishStatus(snapshot)
+	fleet := mainState.Object().Networking.Object().Fleet
+	// The update time is set last, once the rest of the snapshot has been stored
+	assert.Eventually(t, func() bool {
+		entry := fleet.Lookup(alt.Identity())
+		return entry != nil && !entry.Object().Updated.IsZero()
+	}, 2*time.Second, 10*time.Millisecond)
+	status := fleet.Lookup(alt.Identity()).Object()
+	assert.Equal(t, "Searching for vicious bees", status.Status)
+	assert.Equal(t, string(SearcherClientRole), status.Role)
+	assert.Equal(t, "VicSearch", status.Routine)
+	assert.Equal(t, "Rose", status.Field)
+
+	// The main does not publish its own status
+	main.publishStatus(snapshot)
+	assert.Never(t, func() bool {
+		return fleet.Lookup(main.Identity()) != nil
+	}, 200*time.Millisecond, 10*time.Millisecond)
+
+	// Snapshots are removed once their account disconnects
+	alt.Disconnect()
+	assert.Eventually(t, func() bool {
+		return fleet.Lookup(alt.Identity()) == nil
+	}, 2*time.Second, 10*time.Millisecond)
+}
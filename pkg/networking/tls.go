@@ -0,0 +1,117 @@
+package networking
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"github.com/nosyliam/revolution/pkg/config"
+	"github.com/pkg/errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Relays serve TLS with a self-signed certificate generated on first run. Clients cannot verify it against a
+// certificate authority, so they pin the SHA-256 fingerprint advertised in the relay's zeroconf record instead, or
+// trust the first certificate they see for relays which were added manually.
+
+const certificateValidity = 10 * 365 * 24 * time.Hour
+
+var FingerprintMismatchError = errors.New("the relay's certificate does not match its saved fingerprint")
+
+// Fingerprint returns the hex encoded SHA-256 digest of a DER encoded certificate
+func Fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+func generateCertificate() (certPEM string, keyPEM string, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", errors.Wrap(err, "failed to generate key")
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return "", "", errors.Wrap(err, "failed to generate serial number")
+	}
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "Revolution Relay"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(certificateValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return "", "", errors.Wrap(err, "failed to create certificate")
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", "", errors.Wrap(err, "failed to encode key")
+	}
+	certPEM = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	keyPEM = string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	return certPEM, keyPEM, nil
+}
+
+// loadCertificate returns the relay's certificate, generating and saving one if none exists
+func loadCertificate(state *config.Object[config.MacroState]) (tls.Certificate, error) {
+	networking := state.Object().Networking.Object()
+	if networking.Certificate != "" && networking.CertificateKey != "" {
+		cert, err := tls.X509KeyPair([]byte(networking.Certificate), []byte(networking.CertificateKey))
+		if err == nil {
+			return cert, nil
+		}
+	}
+	certPEM, keyPEM, err := generateCertificate()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		return tls.Certificate{}, errors.Wrap(err, "failed to load generated certificate")
+	}
+	if err := state.SetPath("networking.certificateKey", keyPEM); err != nil {
+		return tls.Certificate{}, errors.Wrap(err, "failed to save certificate key")
+	}
+	if err := state.SetPath("networking.certificate", certPEM); err != nil {
+		return tls.Certificate{}, errors.Wrap(err, "failed to save certificate")
+	}
+	return cert, nil
+}
+
+func serverTLSConfig(cert tls.Certificate) *tls.Config {
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+}
+
+// clientTLSConfig verifies the relay's certificate against a pinned fingerprint. If no fingerprint is pinned, any
+// certificate is accepted and its fingerprint is passed to observe so that it can be pinned for future connections.
+func clientTLSConfig(fingerprint string, observe func(string)) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// Chains cannot be verified for self-signed certificates; the fingerprint is verified below instead
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("the relay did not present a certificate")
+			}
+			actual := Fingerprint(rawCerts[0])
+			if fingerprint != "" && !strings.EqualFold(actual, fingerprint) {
+				return errors.Wrap(FingerprintMismatchError, fmt.Sprintf("expected %s, got %s", fingerprint, actual))
+			}
+			if observe != nil {
+				observe(actual)
+			}
+			return nil
+		},
+	}
+}
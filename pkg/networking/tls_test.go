@@ -0,0 +1,42 @@
+package networking
+
+import (
+	"encoding/pem"
+	"fmt"
+	"github.com/nosyliam/revolution/pkg/config"
+	"github.com/nosyliam/revolution/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestClient_FingerprintPinning(t *testing.T) {
+	network := newTestNetwork(t)
+	state := network.account("Alt")
+	client := NewClient(state, logging.NewLogger("Alt", nil))
+
+	other, _, err := generateCertificate()
+	assert.NoError(t, err)
+	block, _ := pem.Decode([]byte(other))
+	err = client.connect(network.address, Fingerprint(block.Bytes))
+	assert.ErrorContains(t, err, FingerprintMismatchError.Error(), "relays presenting a different certificate are rejected")
+	assert.Nil(t, client.conn)
+
+	// Relays advertised over zeroconf are pinned to the fingerprint in their record
+	assert.NoError(t, state.AppendPath(fmt.Sprintf("networking.availableRelays[%s]", network.address)))
+	assert.NoError(t, state.SetPathf(Fingerprint(block.Bytes), "networking.availableRelays[%s].fingerprint", network.address))
+	assert.Error(t, client.Connect(network.address))
+	assert.NoError(t, state.SetPathf(network.relay.fingerprint, "networking.availableRelays[%s].fingerprint", network.address))
+	assert.NoError(t, client.Connect(network.address))
+	client.Disconnect()
+
+	// Saved relays without a fingerprint are pinned to the first certificate they present
+	assert.NoError(t, state.AppendPath(fmt.Sprintf("networking.savedRelays[%s]", network.address)))
+	assert.NoError(t, client.connect(network.address, ""))
+	assert.Equal(t, network.relay.fingerprint, *config.Concrete[string](state, "networking.savedRelays[%s].fingerprint", network.address))
+	client.Disconnect()
+
+	// The certificate is reused when the relay restarts
+	cert, err := loadCertificate(network.relay.state)
+	assert.NoError(t, err)
+	assert.Equal(t, network.relay.fingerprint, Fingerprint(cert.Certificate[0]))
+}
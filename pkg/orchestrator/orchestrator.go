@@ -0,0 +1,471 @@
+package orchestrator
+
+import (
+	"fmt"
+	. "github.com/nosyliam/revolution/pkg/config"
+	"github.com/nosyliam/revolution/pkg/logging"
+	"github.com/pkg/errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LoadedStatus is the macro status reported once an account has joined and loaded the game
+const LoadedStatus = "Game Loaded"
+
+// Health statuses reported in macros[*].health.status
+const (
+	IdleHealth       = "idle"
+	QueuedHealth     = "queued"
+	StartingHealth   = "starting"
+	HealthyHealth    = "healthy"
+	RestartingHealth = "restarting"
+	RotatingHealth   = "rotating"
+	FailedHealth     = "failed"
+)
+
+// Defaults used in place of zero orchestration settings
+const (
+	defaultStartDelay     = 10 * time.Second
+	defaultLoadTimeout    = 5 * time.Minute
+	defaultRestartBackoff = 30 * time.Second
+	defaultMaxBackoff     = 10 * time.Minute
+	stopTimeout           = 30 * time.Second
+)
+
+// Session is a macro instance which can be started by the orchestrator
+type Session interface {
+	Start()
+	Stop()
+	Unpause()
+}
+
+type event int
+
+const (
+	loadedEvent event = iota
+	exitedEvent
+)
+
+type session struct {
+	name    string
+	session Session
+	state   *Object[MacroState]
+	subs    []*Subscription
+	events  chan event
+
+	// Guarded by the orchestrator mutex
+	health       string
+	managed      bool
+	restarts     int
+	loadedAt     time.Time
+	restartTimer *time.Timer
+	rotateTimer  *time.Timer
+}
+
+func (s *session) stopTimers() {
+	if s.restartTimer != nil {
+		s.restartTimer.Stop()
+		s.restartTimer = nil
+	}
+	if s.rotateTimer != nil {
+		s.rotateTimer.Stop()
+		s.rotateTimer = nil
+	}
+}
+
+// Orchestrator starts accounts one at a time in a configurable order, waiting for each to load the game before starting
+// the next, and restarts accounts which fail with an exponential backoff
+type Orchestrator struct {
+	mu       sync.Mutex
+	config   *Object[Orchestration]
+	logger   *logging.Logger
+	sessions map[string]*session
+	pending  []string
+	wake     chan struct{}
+	quit     chan struct{}
+	closed   bool
+	rotation *rotation
+}
+
+func NewOrchestrator(logger *logging.Logger, config *Object[Orchestration]) *Orchestrator {
+	return &Orchestrator{
+		config:   config,
+		logger:   logger,
+		sessions: make(map[string]*session),
+		wake:     make(chan struct{}, 1),
+		quit:     make(chan struct{}),
+	}
+}
+
+// Start starts the launcher goroutine
+func (o *Orchestrator) Start() {
+	go o.run()
+}
+
+// Close stops the launcher and cancels pending restarts without stopping running sessions
+func (o *Orchestrator) Close() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return
+	}
+	o.closed = true
+	close(o.quit)
+	for _, s := range o.sessions {
+		s.stopTimers()
+	}
+}
+
+// Register adds a session which can be started by the orchestrator. Its health is tracked in the given macro state.
+func (o *Orchestrator) Register(name string, sess Session, state *Object[MacroState]) error {
+	s := &session{name: name, session: sess, state: state, events: make(chan event, 4), health: IdleHealth}
+	for pattern, handler := range map[string]func(Change){
+		"status":  func(change Change) { o.handleStatus(s, change) },
+		"running": func(change Change) { o.handleRunning(s, change) },
+		"lastRoutine": func(change Change) {
+			if routine, ok := change.Value.(string); ok {
+				o.handleRoutine(s, routine)
+			}
+		},
+	} {
+		sub, err := state.Subscribe(pattern, handler)
+		if err != nil {
+			for _, sub := range s.subs {
+				sub.Close()
+			}
+			return errors.Wrap(err, fmt.Sprintf("Failed to watch state for account %s", name))
+		}
+		s.subs = append(s.subs, sub)
+	}
+
+	o.mu.Lock()
+	if old, ok := o.sessions[name]; ok {
+		o.release(old)
+	}
+	o.sessions[name] = s
+	o.mu.Unlock()
+	o.setHealth(s, IdleHealth, "")
+	return nil
+}
+
+// Unregister removes a session from the orchestrator
+func (o *Orchestrator) Unregister(name string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if s, ok := o.sessions[name]; ok {
+		o.release(s)
+		delete(o.sessions, name)
+	}
+}
+
+func (o *Orchestrator) release(s *session) {
+	for _, sub := range s.subs {
+		sub.Close()
+	}
+	s.stopTimers()
+	s.managed = false
+}
+
+// Order returns the order in which the registered sessions are started: the configured order first, followed by the
+// remaining sessions in alphabetical order
+func (o *Orchestrator) Order() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var order []string
+	seen := make(map[string]bool)
+	if o.config.Object().Order != nil {
+		o.config.Object().Order.ForEach(func(name *string) {
+			if _, ok := o.sessions[*name]; ok && !seen[*name] {
+				order = append(order, *name)
+				seen[*name] = true
+			}
+		})
+	}
+	var rest []string
+	for name := range o.sessions {
+		if !seen[name] {
+			rest = append(rest, name)
+		}
+	}
+	sort.Strings(rest)
+	return append(order, rest...)
+}
+
+// StartAll queues every session which is not already running. Paused sessions are resumed immediately. Of the accounts
+// sharing a rotating window slot, only the account currently occupying the slot is started.
+func (o *Orchestrator) StartAll() {
+	for _, name := range o.Order() {
+		if slot, members := o.rotationGroup(name); slot != "" && o.rotationCurrent(slot, members) != name {
+			continue
+		}
+		o.mu.Lock()
+		s := o.sessions[name]
+		o.mu.Unlock()
+		state := s.state.Object()
+		if state.Running && state.Paused {
+			s.session.Unpause()
+			continue
+		}
+		if state.Running {
+			continue
+		}
+		o.Enqueue(name)
+	}
+}
+
+// Enqueue queues a single session to be started once the sessions before it have loaded
+func (o *Orchestrator) Enqueue(name string) {
+	o.mu.Lock()
+	s, ok := o.sessions[name]
+	if !ok || o.closed {
+		o.mu.Unlock()
+		return
+	}
+	s.managed = true
+	if s.health == QueuedHealth || s.health == StartingHealth {
+		o.mu.Unlock()
+		return
+	}
+	o.pending = append(o.pending, name)
+	o.mu.Unlock()
+	o.setHealth(s, QueuedHealth, "")
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Detach stops managing a session, e.g. because the user stopped it manually. Pending starts and restarts of the
+// session are cancelled.
+func (o *Orchestrator) Detach(name string) {
+	o.mu.Lock()
+	s, ok := o.sessions[name]
+	if !ok {
+		o.mu.Unlock()
+		return
+	}
+	s.managed = false
+	s.restarts = 0
+	s.stopTimers()
+	for i, pending := range o.pending {
+		if pending == name {
+			o.pending = append(o.pending[:i], o.pending[i+1:]...)
+			break
+		}
+	}
+	o.mu.Unlock()
+	o.setHealth(s, IdleHealth, "")
+}
+
+// StopAll detaches and stops every session
+func (o *Orchestrator) StopAll() {
+	for _, name := range o.Order() {
+		o.Detach(name)
+		o.mu.Lock()
+		s := o.sessions[name]
+		o.mu.Unlock()
+		if s.state.Object().Running {
+			s.session.Stop()
+		}
+	}
+}
+
+func (o *Orchestrator) settings() Orchestration {
+	settings := o.config.Object()
+	if settings.StartDelay <= 0 {
+		settings.StartDelay = defaultStartDelay
+	}
+	if settings.LoadTimeout <= 0 {
+		settings.LoadTimeout = defaultLoadTimeout
+	}
+	if settings.RestartBackoff <= 0 {
+		settings.RestartBackoff = defaultRestartBackoff
+	}
+	if settings.MaxBackoff <= 0 {
+		settings.MaxBackoff = defaultMaxBackoff
+	}
+	return settings
+}
+
+func (o *Orchestrator) run() {
+	for {
+		o.mu.Lock()
+		var s *session
+		for len(o.pending) > 0 && s == nil {
+			name := o.pending[0]
+			o.pending = o.pending[1:]
+			if candidate, ok := o.sessions[name]; ok && candidate.managed {
+				s = candidate
+			}
+		}
+		o.mu.Unlock()
+		if s == nil {
+			select {
+			case <-o.wake:
+				continue
+			case <-o.quit:
+				return
+			}
+		}
+		if !o.launch(s) {
+			return
+		}
+	}
+}
+
+// launch starts a session and waits for it to load the game. The next session is started after the start delay
+// regardless of whether the launch succeeded. False is returned if the orchestrator was closed.
+func (o *Orchestrator) launch(s *session) bool {
+	settings := o.settings()
+	for len(s.events) > 0 {
+		<-s.events
+	}
+	o.setHealth(s, StartingHealth, "")
+	o.logger.Log(0, logging.Info, fmt.Sprintf("Starting account %s", s.name))
+	s.session.Start()
+
+	timeout := time.NewTimer(settings.LoadTimeout)
+	defer timeout.Stop()
+	select {
+	case ev := <-s.events:
+		if ev == loadedEvent {
+			o.mu.Lock()
+			s.loadedAt = time.Now()
+			o.mu.Unlock()
+			o.setHealth(s, HealthyHealth, "")
+		} else {
+			o.fail(s, "The macro stopped before the game loaded")
+		}
+	case <-timeout.C:
+		o.stopSession(s)
+		o.fail(s, fmt.Sprintf("The game did not load within %s", settings.LoadTimeout))
+	case <-o.quit:
+		return false
+	}
+
+	select {
+	case <-time.After(settings.StartDelay):
+	case <-o.quit:
+		return false
+	}
+	return true
+}
+
+// stopSession stops a session which failed to load and waits for it to exit
+func (o *Orchestrator) stopSession(s *session) {
+	if !s.state.Object().Running {
+		return
+	}
+	s.session.Stop()
+	timeout := time.NewTimer(stopTimeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case ev := <-s.events:
+			if ev == exitedEvent {
+				return
+			}
+		case <-timeout.C:
+			return
+		case <-o.quit:
+			return
+		}
+	}
+}
+
+// fail records a failure and schedules a restart with exponential backoff. Accounts which stayed healthy for longer
+// than the maximum backoff have their restart count reset.
+func (o *Orchestrator) fail(s *session, reason string) {
+	settings := o.settings()
+	o.mu.Lock()
+	if !s.managed || o.closed {
+		o.mu.Unlock()
+		return
+	}
+	if !s.loadedAt.IsZero() && time.Since(s.loadedAt) > settings.MaxBackoff {
+		s.restarts = 0
+	}
+	s.loadedAt = time.Time{}
+	s.stopTimers()
+	s.restarts++
+	if settings.MaxRestarts > 0 && s.restarts > settings.MaxRestarts {
+		s.managed = false
+		o.mu.Unlock()
+		o.setHealth(s, FailedHealth, reason)
+		o.logger.Log(0, logging.Error, fmt.Sprintf("Account %s failed too many times and will not be restarted: %s", s.name, reason))
+		return
+	}
+	delay := settings.RestartBackoff << (s.restarts - 1)
+	if delay > settings.MaxBackoff || delay <= 0 {
+		delay = settings.MaxBackoff
+	}
+	name := s.name
+	s.restartTimer = time.AfterFunc(delay, func() {
+		o.mu.Lock()
+		s.restartTimer = nil
+		managed := s.managed
+		o.mu.Unlock()
+		if managed {
+			o.Enqueue(name)
+		}
+	})
+	restarts := s.restarts
+	o.mu.Unlock()
+	o.setHealth(s, RestartingHealth, reason)
+	_ = s.state.SetPath("health.nextRestart", time.Now().Add(delay))
+	o.logger.Log(0, logging.Warning, fmt.Sprintf("Account %s failed (%s); restart %d in %s", name, reason, restarts, delay))
+}
+
+func (o *Orchestrator) handleStatus(s *session, change Change) {
+	if status, _ := change.Value.(string); status != LoadedStatus {
+		return
+	}
+	o.mu.Lock()
+	health := s.health
+	o.mu.Unlock()
+	if health == StartingHealth {
+		select {
+		case s.events <- loadedEvent:
+		default:
+		}
+	}
+}
+
+func (o *Orchestrator) handleRunning(s *session, change Change) {
+	if running, _ := change.Value.(bool); running {
+		return
+	}
+	o.mu.Lock()
+	health, managed := s.health, s.managed
+	o.mu.Unlock()
+	switch {
+	case health == StartingHealth || health == RotatingHealth:
+		select {
+		case s.events <- exitedEvent:
+		default:
+		}
+	case health == HealthyHealth && managed:
+		go o.fail(s, "The macro stopped unexpectedly")
+	}
+}
+
+func (o *Orchestrator) setHealth(s *session, health string, lastError string) {
+	o.mu.Lock()
+	s.health = health
+	restarts := s.restarts
+	o.mu.Unlock()
+	_ = s.state.SetPath("health.status", health)
+	_ = s.state.SetPath("health.restarts", restarts)
+	if lastError != "" {
+		_ = s.state.SetPath("health.lastError", lastError)
+	}
+	switch health {
+	case HealthyHealth:
+		_ = s.state.SetPath("health.loadedAt", time.Now())
+		_ = s.state.SetPath("health.nextRestart", time.Time{})
+		o.scheduleRotation(s)
+	case IdleHealth, QueuedHealth, StartingHealth:
+		_ = s.state.SetPath("health.nextRestart", time.Time{})
+	}
+}
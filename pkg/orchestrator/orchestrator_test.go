@@ -0,0 +1,221 @@
+package orchestrator
+
+import (
+	. "github.com/nosyliam/revolution/pkg/config"
+	"github.com/nosyliam/revolution/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startLog records the accounts passed to a callback in the order it was called
+type startLog struct {
+	mu    sync.Mutex
+	names []string
+}
+
+func (l *startLog) add(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.names = append(l.names, name)
+}
+
+func (l *startLog) get() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.names...)
+}
+
+type fakeSession struct {
+	mu     sync.Mutex
+	name   string
+	state  *Object[MacroState]
+	load   bool
+	crash  bool
+	starts *startLog
+	stops  int
+}
+
+func (f *fakeSession) Start() {
+	f.starts.add(f.name)
+	f.mu.Lock()
+	load, crash := f.load, f.crash
+	f.mu.Unlock()
+	_ = f.state.SetPath("running", true)
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		if load {
+			_ = f.state.SetPath("status", LoadedStatus)
+		} else if crash {
+			_ = f.state.SetPath("running", false)
+		}
+	}()
+}
+
+func (f *fakeSession) Stop() {
+	f.mu.Lock()
+	f.stops++
+	f.mu.Unlock()
+	go func() { _ = f.state.SetPath("running", false) }()
+}
+
+func (f *fakeSession) Unpause() {}
+
+func (f *fakeSession) stopCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.stops
+}
+
+type testEnv struct {
+	o        *Orchestrator
+	sessions map[string]*fakeSession
+	starts   *startLog
+	config   *Object[Config]
+	state    *Object[State]
+	database *Object[AccountDatabase]
+}
+
+// newTestEnv writes the given files to a temporary working directory and registers a session for each name
+func newTestEnv(t *testing.T, files map[string]string, names ...string) *testEnv {
+	cwd, _ := os.Getwd()
+	assert.NoError(t, os.Chdir(t.TempDir()))
+	t.Cleanup(func() { _ = os.Chdir(cwd) })
+
+	for name, data := range files {
+		assert.NoError(t, os.WriteFile(name, []byte(data), 0644))
+	}
+	runtime := NewOfflineRuntime()
+	env := &testEnv{sessions: make(map[string]*fakeSession), starts: new(startLog)}
+	var err error
+	env.config, err = NewConfig(runtime)
+	assert.NoError(t, err)
+	env.state, err = NewState(runtime)
+	assert.NoError(t, err)
+	env.database, err = NewDatabase(runtime)
+	assert.NoError(t, err)
+
+	env.o = NewOrchestrator(logging.NewLogger("test", nil), env.config.Object().Orchestration)
+	for _, name := range names {
+		assert.NoError(t, env.state.AppendPathf("macros[%s]", name))
+		macroState := *Concrete[*Object[MacroState]](env.state, "macros[%s]", name)
+		env.sessions[name] = &fakeSession{name: name, state: macroState, load: true, starts: env.starts}
+		assert.NoError(t, env.o.Register(name, env.sessions[name], macroState))
+	}
+	env.o.Start()
+	t.Cleanup(env.o.Close)
+	return env
+}
+
+func newTestOrchestrator(t *testing.T, settings string, names ...string) (*Orchestrator, map[string]*fakeSession, *startLog) {
+	env := newTestEnv(t, map[string]string{"settings.yaml": "orchestration:\n" + settings}, names...)
+	return env.o, env.sessions, env.starts
+}
+
+func health(s *fakeSession) MacroHealth {
+	return s.state.Object().Health.Object()
+}
+
+func TestOrchestrator_StaggeredStart(t *testing.T) {
+	o, sessions, starts := newTestOrchestrator(t, `
+    order: [Charlie, Alpha]
+    startDelay: 20ms
+`, "Alpha", "Bravo", "Charlie")
+	assert.Equal(t, []string{"Charlie", "Alpha", "Bravo"}, o.Order())
+
+	o.StartAll()
+	assert.Eventually(t, func() bool {
+		return health(sessions["Bravo"]).Status == HealthyHealth
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []string{"Charlie", "Alpha", "Bravo"}, starts.get())
+	for _, s := range sessions {
+		assert.Equal(t, HealthyHealth, health(s).Status)
+		assert.False(t, health(s).LoadedAt.IsZero())
+	}
+
+	// Running sessions are not started again
+	o.StartAll()
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, starts.get(), 3)
+
+	o.StopAll()
+	for _, s := range sessions {
+		assert.Equal(t, 1, s.stopCount())
+		assert.Equal(t, IdleHealth, health(s).Status)
+	}
+}
+
+func TestOrchestrator_WaitsForLoad(t *testing.T) {
+	o, sessions, starts := newTestOrchestrator(t, `
+    startDelay: 1ms
+    loadTimeout: 50ms
+    restartBackoff: 1h
+`, "Alpha", "Bravo")
+	sessions["Alpha"].load = false
+
+	o.StartAll()
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, []string{"Alpha"}, starts.get(), "the next account waits until the previous one loads")
+	assert.Equal(t, StartingHealth, health(sessions["Alpha"]).Status)
+
+	assert.Eventually(t, func() bool {
+		return health(sessions["Bravo"]).Status == HealthyHealth
+	}, time.Second, 5*time.Millisecond)
+	alpha := health(sessions["Alpha"])
+	assert.Equal(t, RestartingHealth, alpha.Status)
+	assert.Equal(t, 1, alpha.Restarts)
+	assert.Contains(t, alpha.LastError, "did not load")
+	assert.True(t, alpha.NextRestart.After(time.Now()))
+	assert.Equal(t, 1, sessions["Alpha"].stopCount())
+
+	// Stopping an account manually cancels its restart
+	o.Detach("Alpha")
+	assert.Equal(t, IdleHealth, health(sessions["Alpha"]).Status)
+	assert.True(t, health(sessions["Alpha"]).NextRestart.IsZero())
+}
+
+func TestOrchestrator_RestartBackoff(t *testing.T) {
+	o, sessions, starts := newTestOrchestrator(t, `
+    startDelay: 1ms
+    restartBackoff: 10ms
+    maxBackoff: 40ms
+    maxRestarts: 3
+`, "Alpha")
+	sessions["Alpha"].load = false
+	sessions["Alpha"].crash = true
+
+	begin := time.Now()
+	o.StartAll()
+	assert.Eventually(t, func() bool {
+		return health(sessions["Alpha"]).Status == FailedHealth
+	}, 2*time.Second, 5*time.Millisecond)
+	// One initial start and three restarts delayed by 10ms, 20ms and 40ms
+	assert.Len(t, starts.get(), 4)
+	assert.GreaterOrEqual(t, time.Since(begin), 70*time.Millisecond)
+	assert.Equal(t, 4, health(sessions["Alpha"]).Restarts)
+
+	// Failed accounts are no longer restarted
+	time.Sleep(100 * time.Millisecond)
+	assert.Len(t, starts.get(), 4)
+}
+
+func TestOrchestrator_CrashAfterLoad(t *testing.T) {
+	o, sessions, starts := newTestOrchestrator(t, `
+    startDelay: 1ms
+    restartBackoff: 10ms
+`, "Alpha")
+
+	o.StartAll()
+	assert.Eventually(t, func() bool {
+		return health(sessions["Alpha"]).Status == HealthyHealth
+	}, time.Second, 5*time.Millisecond)
+
+	_ = sessions["Alpha"].state.SetPath("running", false)
+	assert.Eventually(t, func() bool {
+		return len(starts.get()) == 2 && health(sessions["Alpha"]).Status == HealthyHealth
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, 1, health(sessions["Alpha"]).Restarts)
+	assert.Contains(t, health(sessions["Alpha"]).LastError, "unexpectedly")
+}
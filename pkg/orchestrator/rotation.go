@@ -0,0 +1,154 @@
+package orchestrator
+
+import (
+	"fmt"
+	. "github.com/nosyliam/revolution/pkg/config"
+	"github.com/nosyliam/revolution/pkg/logging"
+	"time"
+)
+
+type rotation struct {
+	config   *Object[Config]
+	state    *Object[State]
+	database *Object[AccountDatabase]
+	release  func(account string) error
+}
+
+// EnableRotation enables account rotation. Accounts assigned to the same window configuration take turns occupying
+// its slot when rotation is enabled for the window configuration. The release callback is called to close the Roblox
+// window of an account once it has been rotated out.
+func (o *Orchestrator) EnableRotation(
+	config *Object[Config],
+	state *Object[State],
+	database *Object[AccountDatabase],
+	release func(account string) error,
+) {
+	o.mu.Lock()
+	o.rotation = &rotation{config: config, state: state, database: database, release: release}
+	o.mu.Unlock()
+}
+
+func (o *Orchestrator) rotationSettings(slot string) *Rotation {
+	if o.rotation == nil || slot == "" {
+		return nil
+	}
+	window := Concrete[WindowConfig](o.rotation.config, "windows[%s]", slot)
+	if window == nil || window.Rotation == nil {
+		return nil
+	}
+	settings := window.Rotation.Object()
+	if !settings.Enabled {
+		return nil
+	}
+	return &settings
+}
+
+// rotationGroup returns the rotating window slot of an account and the accounts sharing it in start order. No slot is
+// returned if the account does not rotate.
+func (o *Orchestrator) rotationGroup(name string) (string, []string) {
+	o.mu.Lock()
+	rot := o.rotation
+	o.mu.Unlock()
+	if rot == nil {
+		return "", nil
+	}
+	db := rot.database.Object()
+	account := db.Get(name)
+	if account == nil || o.rotationSettings(account.WindowConfigID) == nil {
+		return "", nil
+	}
+	slot := account.WindowConfigID
+	var members []string
+	for _, member := range o.Order() {
+		if account := db.Get(member); account != nil && account.WindowConfigID == slot {
+			members = append(members, member)
+		}
+	}
+	if len(members) < 2 {
+		return "", nil
+	}
+	return slot, members
+}
+
+// rotationCurrent returns the account currently occupying a rotating slot
+func (o *Orchestrator) rotationCurrent(slot string, members []string) string {
+	if state := Concrete[RotationState](o.rotation.state, "rotations[%s]", slot); state != nil {
+		for _, member := range members {
+			if member == state.Account {
+				return member
+			}
+		}
+	}
+	return members[0]
+}
+
+func (o *Orchestrator) setRotationCurrent(slot string, account string) error {
+	if Concrete[RotationState](o.rotation.state, "rotations[%s]", slot) == nil {
+		if err := o.rotation.state.AppendPathf("rotations[%s]", slot); err != nil {
+			return err
+		}
+	}
+	return o.rotation.state.SetPathf(account, "rotations[%s].account", slot)
+}
+
+// scheduleRotation starts the rotation timer of a session which has loaded the game
+func (o *Orchestrator) scheduleRotation(s *session) {
+	slot, _ := o.rotationGroup(s.name)
+	settings := o.rotationSettings(slot)
+	if settings == nil || settings.Interval <= 0 {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if s.rotateTimer != nil {
+		s.rotateTimer.Stop()
+	}
+	s.rotateTimer = time.AfterFunc(settings.Interval, func() { o.Rotate(s.name) })
+}
+
+func (o *Orchestrator) handleRoutine(s *session, routine string) {
+	slot, _ := o.rotationGroup(s.name)
+	if settings := o.rotationSettings(slot); settings != nil && settings.AfterRoutine == routine {
+		go o.Rotate(s.name)
+	}
+}
+
+// Rotate replaces a healthy account in its rotating window slot with the next account of the slot. The account is
+// stopped and its Roblox window is closed before the next account is queued.
+func (o *Orchestrator) Rotate(name string) {
+	slot, members := o.rotationGroup(name)
+	if slot == "" {
+		return
+	}
+	o.mu.Lock()
+	s, ok := o.sessions[name]
+	if !ok || !s.managed || s.health != HealthyHealth || o.closed {
+		o.mu.Unlock()
+		return
+	}
+	s.managed = false
+	s.stopTimers()
+	o.mu.Unlock()
+
+	var next string
+	for i, member := range members {
+		if member == name {
+			next = members[(i+1)%len(members)]
+		}
+	}
+	o.logger.Log(0, logging.Info, fmt.Sprintf("Rotating window %s from account %s to %s", slot, name, next))
+	o.setHealth(s, RotatingHealth, "")
+	o.stopSession(s)
+	if err := o.rotation.release(name); err != nil {
+		o.logger.Log(0, logging.Warning, fmt.Sprintf("Failed to close the window of account %s: %v", name, err))
+	}
+	o.setHealth(s, IdleHealth, "")
+
+	if err := o.setRotationCurrent(slot, next); err != nil {
+		o.logger.Log(0, logging.Error, fmt.Sprintf("Failed to save rotation position of window %s: %v", slot, err))
+	}
+	if *Concrete[string](o.rotation.state, "config.activeAccount") == name {
+		_ = o.rotation.state.SetPath("config.activeAccount", next)
+	}
+	o.Enqueue(next)
+}
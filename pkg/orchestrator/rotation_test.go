@@ -0,0 +1,102 @@
+package orchestrator
+
+import (
+	. "github.com/nosyliam/revolution/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+const rotationAccounts = `accounts:
+    - name: Alpha
+      preset: Default
+      windowConfigID: slot
+    - name: Bravo
+      preset: Default
+      windowConfigID: slot
+    - name: Charlie
+      preset: Default
+`
+
+func newRotationEnv(t *testing.T, rotation string) (*testEnv, *startLog) {
+	env := newTestEnv(t, map[string]string{
+		"settings.yaml": `orchestration:
+    startDelay: 1ms
+windows:
+    - id: slot
+      rotation:
+` + rotation,
+		"accounts.yaml": rotationAccounts,
+	}, "Alpha", "Bravo", "Charlie")
+	released := new(startLog)
+	env.o.EnableRotation(env.config, env.state, env.database, func(account string) error {
+		released.add(account)
+		return nil
+	})
+	return env, released
+}
+
+func TestOrchestrator_RotationInterval(t *testing.T) {
+	env, released := newRotationEnv(t, `
+        enabled: true
+        interval: 50ms
+`)
+	assert.NoError(t, env.state.SetPath("config.activeAccount", "Alpha"))
+
+	env.o.StartAll()
+	assert.Eventually(t, func() bool {
+		return health(env.sessions["Charlie"]).Status == HealthyHealth
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, []string{"Alpha", "Charlie"}, env.starts.get(), "only the current account of a slot is started")
+
+	assert.Eventually(t, func() bool {
+		return health(env.sessions["Bravo"]).Status == HealthyHealth
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, []string{"Alpha", "Charlie", "Bravo"}, env.starts.get())
+	assert.Equal(t, []string{"Alpha"}, released.get())
+	assert.Equal(t, 1, env.sessions["Alpha"].stopCount())
+	assert.Equal(t, IdleHealth, health(env.sessions["Alpha"]).Status)
+	assert.Equal(t, "Bravo", Concrete[RotationState](env.state, "rotations[slot]").Account)
+	assert.Equal(t, "Bravo", *Concrete[string](env.state, "config.activeAccount"))
+
+	// The rotation wraps around to the first account
+	assert.Eventually(t, func() bool {
+		return len(env.starts.get()) == 4
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, "Alpha", env.starts.get()[3])
+
+	// Stopping everything prevents further rotation
+	env.o.StopAll()
+	time.Sleep(100 * time.Millisecond)
+	assert.Len(t, env.starts.get(), 4)
+}
+
+func TestOrchestrator_RotationAfterRoutine(t *testing.T) {
+	env, released := newRotationEnv(t, `
+        enabled: true
+        interval: 0s
+        afterRoutine: KillVic
+`)
+	// The persisted position is resumed
+	assert.NoError(t, env.state.AppendPath("rotations[slot]"))
+	assert.NoError(t, env.state.SetPath("rotations[slot].account", "Bravo"))
+
+	env.o.StartAll()
+	assert.Eventually(t, func() bool {
+		return health(env.sessions["Charlie"]).Status == HealthyHealth
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, []string{"Bravo", "Charlie"}, env.starts.get())
+
+	// Routines on accounts which do not rotate are ignored
+	assert.NoError(t, env.sessions["Charlie"].state.SetPath("lastRoutine", "KillVic"))
+	assert.NoError(t, env.sessions["Bravo"].state.SetPath("lastRoutine", "VicSearch"))
+	time.Sleep(20 * time.Millisecond)
+	assert.Empty(t, released.get())
+
+	assert.NoError(t, env.sessions["Bravo"].state.SetPath("lastRoutine", "KillVic"))
+	assert.Eventually(t, func() bool {
+		return health(env.sessions["Alpha"]).Status == HealthyHealth
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, []string{"Bravo"}, released.get())
+	assert.Equal(t, "Alpha", Concrete[RotationState](env.state, "rotations[slot]").Account)
+}
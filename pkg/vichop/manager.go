@@ -35,9 +35,10 @@ type Manager struct {
 
 	queue chan *VicDetectMessage
 
-	settings *Object[Config]
-	state    *Object[State]
-	logger   *logging.Logger
+	settings      *Object[Config]
+	state         *Object[State]
+	logger        *logging.Logger
+	subscriptions []*Subscription
 
 	waiters    map[chan *ServerData]bool
 	loading    atomic.Bool
@@ -69,30 +70,30 @@ func (m *Manager) HandleRoleChange(account string, role string) {
 	}
 }
 
-func (m *Manager) RegisterPreset(preset *Object[Settings]) {
-	if err := preset.ListenPath("vicHop.role", func(_ ListenOp, value interface{}) {
-		if !preset.Object().VicHop.Object().Enabled {
+func (m *Manager) handleVicHopChange(change Change) {
+	preset, field := change.Wildcards[0], change.Wildcards[1]
+	settings := Concrete[VicHop](m.settings, "presets[%s].vicHop", preset)
+	if settings == nil {
+		return
+	}
+	var role string
+	switch field {
+	case "role":
+		if !settings.Enabled {
 			return
 		}
-		for account, activePreset := range m.presets {
-			if preset.Object().Name == activePreset {
-				m.HandleRoleChange(account, value.(string))
-			}
+		role = change.Value.(string)
+	case "enabled":
+		if role = settings.Role; !change.Value.(bool) {
+			role = common.InactiveClientRole
 		}
-	}); err != nil {
-		panic(err)
+	default:
+		return
 	}
-	if err := preset.ListenPath("vicHop.enabled", func(_ ListenOp, value interface{}) {
-		if value.(bool) {
-			return
-		}
-		for account, activePreset := range m.presets {
-			if preset.Object().Name == activePreset {
-				m.HandleRoleChange(account, common.InactiveClientRole)
-			}
+	for account, activePreset := range m.presets {
+		if preset == activePreset {
+			m.HandleRoleChange(account, role)
 		}
-	}); err != nil {
-		panic(err)
 	}
 }
 
@@ -108,36 +109,44 @@ func (m *Manager) UnregisterState(state *Object[MacroState]) {
 	m.mu.Unlock()
 }
 
+func (m *Manager) subscribe(subscribe func() (*Subscription, error)) {
+	sub, err := subscribe()
+	if err != nil {
+		panic(err)
+	}
+	m.subscriptions = append(m.subscriptions, sub)
+}
+
 func (m *Manager) Start() {
-	m.settings.Object().Presets.ForEachObject(func(value *Object[Settings]) {
-		m.RegisterPreset(value)
-	})
 	m.state.Object().Macros.ForEachObject(func(value *Object[MacroState]) {
 		m.RegisterState(value)
 	})
-	if err := m.settings.ListenPath("presets", func(op ListenOp, value interface{}) {
-		if op == Append {
-			m.RegisterPreset(value.(*Object[Settings]))
-		}
-	}); err != nil {
-		panic(err)
-	}
-	if err := m.state.ListenPath("macros", func(op ListenOp, value interface{}) {
-		if op == Append {
-			m.RegisterState(value.(*Object[MacroState]))
-		} else {
-			m.UnregisterState(value.(*Object[MacroState]))
-		}
-	}); err != nil {
-		panic(err)
-	}
-	if err := m.state.ListenPath("config.defaultPreset", func(_ ListenOp, value interface{}) {
-		m.presets["Default"] = value.(string)
-		role, _ := m.settings.GetPathf("presets[%s].vicHop.role", value.(string))
-		m.HandleRoleChange("Default", role.(string))
-	}); err != nil {
-		panic(err)
+	m.subscribe(func() (*Subscription, error) {
+		return m.settings.Subscribe("presets[*].vicHop.*", m.handleVicHopChange)
+	})
+	m.subscribe(func() (*Subscription, error) {
+		return m.state.Subscribe("macros", func(change Change) {
+			if change.Op == Append {
+				m.RegisterState(change.Value.(*Object[MacroState]))
+			} else {
+				m.UnregisterState(change.Value.(*Object[MacroState]))
+			}
+		})
+	})
+	m.subscribe(func() (*Subscription, error) {
+		return m.state.Subscribe("config.defaultPreset", func(change Change) {
+			m.presets["Default"] = change.Value.(string)
+			role, _ := m.settings.GetPathf("presets[%s].vicHop.role", change.Value.(string))
+			m.HandleRoleChange("Default", role.(string))
+		})
+	})
+}
+
+func (m *Manager) Stop() {
+	for _, sub := range m.subscriptions {
+		sub.Close()
 	}
+	m.subscriptions = nil
 }
 
 func (m *Manager) RegisterMacro(macro *common.Macro) error {
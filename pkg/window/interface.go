@@ -5,6 +5,7 @@ import (
 	revimg "github.com/nosyliam/revolution/pkg/image"
 	"github.com/pkg/errors"
 	"image"
+	"net/url"
 )
 
 var (
@@ -14,27 +15,24 @@ var (
 
 type JoinOptions struct {
 	LinkCode     string
+	ShareCode    string
 	GameInstance string
 	Url          string
 }
 
+// String builds the deep link used to launch Roblox with the join options
 func (j JoinOptions) String() string {
-	var url string
-	if j.Url == "" {
-		url = fmt.Sprintf("roblox://placeID=1537690962%s", (func() string {
-			if j.LinkCode == "" && j.GameInstance == "" {
-				return ""
-			} else if j.GameInstance != "" {
-				return fmt.Sprintf("&gameInstanceId=%s", j.GameInstance)
-			} else if j.LinkCode != "" {
-				return fmt.Sprintf("&linkCode=%s", j.LinkCode)
-			}
-			return ""
-		})())
-	} else {
-		url = j.Url
+	switch {
+	case j.Url != "":
+		return j.Url
+	case j.GameInstance != "":
+		return fmt.Sprintf("roblox://placeID=%s&gameInstanceId=%s", PlaceID, url.QueryEscape(j.GameInstance))
+	case j.LinkCode != "":
+		return fmt.Sprintf("roblox://placeID=%s&linkCode=%s", PlaceID, url.QueryEscape(j.LinkCode))
+	case j.ShareCode != "":
+		return fmt.Sprintf("roblox://navigation/share_links?code=%s&type=Server", url.QueryEscape(j.ShareCode))
 	}
-	return url
+	return fmt.Sprintf("roblox://placeID=%s", PlaceID)
 }
 
 type Backend interface {
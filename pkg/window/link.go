@@ -0,0 +1,161 @@
+package window
+
+import (
+	"fmt"
+	"github.com/nosyliam/revolution/pkg/config"
+	"github.com/pkg/errors"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// PlaceID is the place ID of Bee Swarm Simulator
+const PlaceID = "1537690962"
+
+var (
+	codeRegex      = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	linkCodeRegex  = regexp.MustCompile(`^[0-9]+$`)
+	shareCodeRegex = regexp.MustCompile(`^[0-9A-Fa-f]{32}$`)
+)
+
+func init() {
+	config.RegisterValidator("privateServerLink", func(value interface{}) error {
+		link, _ := value.(string)
+		_, err := ParsePrivateServerLink(link)
+		return err
+	})
+}
+
+// ParsePrivateServerLink normalizes a private server link into join options. The following formats are accepted:
+//
+//	https://www.roblox.com/games/1537690962/Bee-Swarm-Simulator?privateServerLinkCode=<code>
+//	https://www.roblox.com/share?code=<code>&type=Server
+//	roblox://placeID=1537690962&linkCode=<code>
+//	roblox://experiences/start?placeId=1537690962&linkCode=<code>
+//	roblox://navigation/share_links?code=<code>&type=Server
+//	<code>
+//
+// An empty link results in empty join options, i.e. a public server.
+func ParsePrivateServerLink(link string) (JoinOptions, error) {
+	link = strings.TrimSpace(link)
+	if link == "" {
+		return JoinOptions{}, nil
+	}
+	if !strings.Contains(link, "/") && !strings.Contains(link, "=") {
+		return parseCode(link)
+	}
+	lower := strings.ToLower(link)
+	if strings.HasPrefix(lower, "roblox://") {
+		return parseDeepLink(link[len("roblox://"):])
+	}
+	if !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") {
+		link = "https://" + link
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return JoinOptions{}, errors.New("Invalid private server link")
+	}
+	switch strings.ToLower(u.Hostname()) {
+	case "roblox.com", "www.roblox.com", "web.roblox.com":
+	case "ro.blox.com":
+		return JoinOptions{}, errors.New("Shortened ro.blox.com links are not supported; open the link in a browser and copy the full link instead")
+	default:
+		return JoinOptions{}, errors.New(fmt.Sprintf("%s is not a Roblox link", u.Hostname()))
+	}
+	query := lowerQuery(u.Query())
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	switch strings.ToLower(segments[0]) {
+	case "games":
+		if len(segments) < 2 {
+			return JoinOptions{}, errors.New("Private server link is missing a place ID")
+		}
+		if err := checkPlace(segments[1]); err != nil {
+			return JoinOptions{}, err
+		}
+		return linkCodeOptions(query.Get("privateserverlinkcode"))
+	case "share":
+		return shareCodeOptions(query)
+	}
+	return JoinOptions{}, errors.New("Link is not a private server link")
+}
+
+func parseCode(code string) (JoinOptions, error) {
+	if linkCodeRegex.MatchString(code) {
+		return JoinOptions{LinkCode: code}, nil
+	}
+	if shareCodeRegex.MatchString(code) {
+		return JoinOptions{ShareCode: code}, nil
+	}
+	return JoinOptions{}, errors.New("Invalid private server link code")
+}
+
+func parseDeepLink(link string) (JoinOptions, error) {
+	var path, rawQuery = "", link
+	if i := strings.Index(link, "?"); i >= 0 {
+		path, rawQuery = link[:i], link[i+1:]
+	}
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return JoinOptions{}, errors.New("Invalid private server link")
+	}
+	query := lowerQuery(values)
+	switch strings.ToLower(strings.Trim(path, "/")) {
+	case "navigation/share_links":
+		return shareCodeOptions(query)
+	case "", "experiences/start":
+		if err := checkPlace(query.Get("placeid")); err != nil {
+			return JoinOptions{}, err
+		}
+		if instance := query.Get("gameinstanceid"); instance != "" {
+			if !codeRegex.MatchString(instance) {
+				return JoinOptions{}, errors.New("Invalid game instance ID")
+			}
+			return JoinOptions{GameInstance: instance}, nil
+		}
+		return linkCodeOptions(query.Get("linkcode"))
+	}
+	return JoinOptions{}, errors.New("Link is not a private server link")
+}
+
+func checkPlace(place string) error {
+	if place == "" {
+		return errors.New("Private server link is missing a place ID")
+	}
+	if place != PlaceID {
+		return errors.New(fmt.Sprintf("Private server link is for place %s, not Bee Swarm Simulator", place))
+	}
+	return nil
+}
+
+func linkCodeOptions(code string) (JoinOptions, error) {
+	if code == "" {
+		return JoinOptions{}, errors.New("Private server link is missing a link code")
+	}
+	if !codeRegex.MatchString(code) {
+		return JoinOptions{}, errors.New("Invalid private server link code")
+	}
+	return JoinOptions{LinkCode: code}, nil
+}
+
+func shareCodeOptions(query url.Values) (JoinOptions, error) {
+	if kind := query.Get("type"); kind != "" && !strings.EqualFold(kind, "Server") {
+		return JoinOptions{}, errors.New(fmt.Sprintf("Share link is a %s link, not a private server link", kind))
+	}
+	code := query.Get("code")
+	if code == "" {
+		return JoinOptions{}, errors.New("Share link is missing a code")
+	}
+	if !codeRegex.MatchString(code) {
+		return JoinOptions{}, errors.New("Invalid share link code")
+	}
+	return JoinOptions{ShareCode: code}, nil
+}
+
+// lowerQuery lowercases query keys, since Roblox links are inconsistent about casing (placeID vs placeId)
+func lowerQuery(values url.Values) url.Values {
+	query := make(url.Values)
+	for key, value := range values {
+		query[strings.ToLower(key)] = value
+	}
+	return query
+}
@@ -0,0 +1,66 @@
+package window
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParsePrivateServerLink(t *testing.T) {
+	const shareCode = "0b5ee4e8d1a2e84b9dd8c3b0a0b4ef38"
+	tests := []struct {
+		name    string
+		link    string
+		options JoinOptions
+		url     string
+		err     bool
+	}{
+		{"empty", "", JoinOptions{}, "roblox://placeID=1537690962", false},
+		{"web", "https://www.roblox.com/games/1537690962/Bee-Swarm-Simulator?privateServerLinkCode=123456789",
+			JoinOptions{LinkCode: "123456789"}, "roblox://placeID=1537690962&linkCode=123456789", false},
+		{"web without scheme", "www.roblox.com/games/1537690962?privateServerLinkCode=123",
+			JoinOptions{LinkCode: "123"}, "roblox://placeID=1537690962&linkCode=123", false},
+		{"web padded", "  https://web.roblox.com/games/1537690962/Bee-Swarm-Simulator?privateServerLinkCode=42\n",
+			JoinOptions{LinkCode: "42"}, "roblox://placeID=1537690962&linkCode=42", false},
+		{"share", "https://www.roblox.com/share?code=" + shareCode + "&type=Server",
+			JoinOptions{ShareCode: shareCode}, "roblox://navigation/share_links?code=" + shareCode + "&type=Server", false},
+		{"deep link", "roblox://placeID=1537690962&linkCode=987",
+			JoinOptions{LinkCode: "987"}, "roblox://placeID=1537690962&linkCode=987", false},
+		{"deep link start", "roblox://experiences/start?placeId=1537690962&linkCode=987",
+			JoinOptions{LinkCode: "987"}, "roblox://placeID=1537690962&linkCode=987", false},
+		{"deep link instance", "roblox://placeID=1537690962&gameInstanceId=abc-123",
+			JoinOptions{GameInstance: "abc-123"}, "roblox://placeID=1537690962&gameInstanceId=abc-123", false},
+		{"deep link share", "roblox://navigation/share_links?code=" + shareCode + "&type=Server",
+			JoinOptions{ShareCode: shareCode}, "roblox://navigation/share_links?code=" + shareCode + "&type=Server", false},
+		{"bare link code", "123456789", JoinOptions{LinkCode: "123456789"}, "roblox://placeID=1537690962&linkCode=123456789", false},
+		{"bare share code", shareCode, JoinOptions{ShareCode: shareCode}, "roblox://navigation/share_links?code=" + shareCode + "&type=Server", false},
+
+		{"other game", "https://www.roblox.com/games/920587237/Adopt-Me?privateServerLinkCode=123", JoinOptions{}, "", true},
+		{"missing code", "https://www.roblox.com/games/1537690962/Bee-Swarm-Simulator", JoinOptions{}, "", true},
+		{"malformed code", "https://www.roblox.com/games/1537690962?privateServerLinkCode=12%2034", JoinOptions{}, "", true},
+		{"other host", "https://example.com/games/1537690962?privateServerLinkCode=123", JoinOptions{}, "", true},
+		{"shortened", "https://ro.blox.com/Ebh5?af_dp=roblox", JoinOptions{}, "", true},
+		{"share invite", "https://www.roblox.com/share?code=" + shareCode + "&type=ExperienceInvite", JoinOptions{}, "", true},
+		{"share missing code", "https://www.roblox.com/share?type=Server", JoinOptions{}, "", true},
+		{"deep link other game", "roblox://placeID=1&linkCode=987", JoinOptions{}, "", true},
+		{"profile", "https://www.roblox.com/users/1/profile", JoinOptions{}, "", true},
+		{"bare garbage", "not a code", JoinOptions{}, "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options, err := ParsePrivateServerLink(test.link)
+			if test.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.options, options)
+			assert.Equal(t, test.url, options.String())
+		})
+	}
+}
+
+func TestJoinOptions_String(t *testing.T) {
+	assert.Equal(t, "roblox://placeID=1537690962&gameInstanceId=abc",
+		JoinOptions{GameInstance: "abc", LinkCode: "123"}.String())
+	assert.Equal(t, "https://example.com", JoinOptions{Url: "https://example.com", LinkCode: "123"}.String())
+}
@@ -8,7 +8,6 @@ import (
 	"github.com/sqweek/dialog"
 	"image"
 	"runtime"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -21,6 +20,7 @@ type Window struct {
 	screenshot atomic.Pointer[image.RGBA]
 	capturing  atomic.Bool
 	output     chan *image.RGBA
+	frameHook  atomic.Pointer[func(*image.RGBA)]
 	loaded     bool
 	mgr        *Manager
 	err        error
@@ -80,6 +80,11 @@ func (w *Window) CloseOutput() {
 	}
 }
 
+// OnFrame sets a function which receives every captured frame on the capture goroutine
+func (w *Window) OnFrame(fn func(*image.RGBA)) {
+	w.frameHook.Store(&fn)
+}
+
 func (w *Window) Screenshot() *image.RGBA {
 	return w.screenshot.Load()
 }
@@ -111,6 +116,9 @@ func (w *Window) StartCapture() error {
 					return
 				}
 				w.screenshot.Store(img)
+				if hook := w.frameHook.Load(); hook != nil {
+					(*hook)(img)
+				}
 				if w.output != nil {
 					if len(w.output) > 30 {
 						fmt.Printf("WARNING: Scheduler frame output buffer is %d frames behind!\n", len(w.output))
@@ -176,6 +184,7 @@ type Manager struct {
 	backend         Backend
 	reservedWindows []windowArray
 	reservedIds     map[string]*Window
+	accountIds      map[string]string
 	windowFrames    map[string]revimg.Frame
 	displayCount    int
 	frames          []revimg.ScreenFrame
@@ -206,6 +215,22 @@ func (m *Manager) freeWindow(id string) {
 	}
 	delete(m.reservedIds, id)
 	delete(m.windowFrames, id)
+	for account, accountId := range m.accountIds {
+		if accountId == id {
+			delete(m.accountIds, account)
+		}
+	}
+}
+
+// Release closes the window opened for an account and frees its reservation
+func (m *Manager) Release(accountName string) error {
+	m.Lock()
+	window, ok := m.reservedIds[m.accountIds[accountName]]
+	m.Unlock()
+	if !ok {
+		return nil
+	}
+	return window.Close()
 }
 
 func (m *Manager) adjustDisplays() error {
@@ -425,10 +450,15 @@ func (m *Manager) OpenWindow(accountName string, db Reactive, settings *Object[S
 		if account == nil {
 			return nil, errors.New(fmt.Sprintf("Account %s not found", accountName))
 		}
-		if url, err := account.GenerateJoinUrl(ignoreLink); err != nil {
-			return nil, errors.Wrap(err, "Failed to generate join url")
-		} else {
-			joinOptions = JoinOptions{Url: url}
+		if account.Invalid {
+			return nil, errors.New(fmt.Sprintf("The session for account %s has expired", accountName))
+		}
+		if account.LinkCode != "" && !ignoreLink {
+			options, err := ParsePrivateServerLink(account.LinkCode)
+			if err != nil {
+				return nil, errors.Wrap(err, "Failed to generate join url")
+			}
+			joinOptions = options
 		}
 		if account.WindowConfigID != "" {
 			windowConfig = Concrete[WindowConfig](settings, "windows[%s]", account.WindowConfigID)
@@ -438,11 +468,11 @@ func (m *Manager) OpenWindow(accountName string, db Reactive, settings *Object[S
 		}
 	} else {
 		if privateLink := Concrete[string](settings, "window.privateServerLink"); *privateLink != "" && !ignoreLink {
-			parts := strings.Split(*privateLink, "=")
-			if len(parts) != 2 {
-				return nil, errors.New("Invalid private server link format")
+			options, err := ParsePrivateServerLink(*privateLink)
+			if err != nil {
+				return nil, err
 			}
-			joinOptions = JoinOptions{LinkCode: parts[1]}
+			joinOptions = options
 		}
 	}
 	if windowConfig == nil {
@@ -481,6 +511,7 @@ func (m *Manager) OpenWindow(accountName string, db Reactive, settings *Object[S
 		mgr:     m,
 	}
 	m.reservedIds[windowConfig.ID] = win
+	m.accountIds[accountName] = windowConfig.ID
 	return win, nil
 }
 
@@ -489,5 +520,6 @@ func NewWindowManager(backend Backend) *Manager {
 		backend:      backend,
 		windowFrames: make(map[string]revimg.Frame),
 		reservedIds:  make(map[string]*Window),
+		accountIds:   make(map[string]string),
 	}
 }